@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	BackupPathName      = "backups"
+	BackupIndexFileName = "index.json"
+	BackupTimeFormat    = "20060102-150405"
+)
+
+// BackupEntry records original state of a system file before first modified in a run.
+// Backup is empty when file not existed, so that restoring removes it
+type BackupEntry struct {
+	ID     string      `json:"id"`
+	Path   string      `json:"path"`
+	Backup string      `json:"backup,omitempty"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+	UID    int         `json:"uid"`
+	GID    int         `json:"gid"`
+	Time   string      `json:"time"`
+}
+
+// BackupStore keeps timestamped copies of files outside project path before modified, with an index of all copies.
+// Store lives in data path of installer, which kept when uninstalling, so that files changed by uninstall could be restored
+type BackupStore struct {
+	path     string
+	excluded []string
+	saved    map[string]bool
+}
+
+var backups = NewBackupStore(filepath.Join(DefaultProjectPath, InstallerDataPathName, BackupPathName), DefaultProjectPath)
+
+// NewBackupStore creates store in path, files under path of store and excluded paths never saved
+func NewBackupStore(path string, excluded ...string) *BackupStore {
+	var store = &BackupStore{path: filepath.Clean(path), saved: map[string]bool{}}
+	for _, path := range excluded {
+		store.Exclude(path)
+	}
+	return store
+}
+
+// Exclude skips files under path, like project path managed by installer
+func (store *BackupStore) Exclude(path string) {
+	store.excluded = append(store.excluded, filepath.Clean(path))
+}
+
+func (store *BackupStore) isExcluded(filename string) bool {
+	var cleaned = filepath.Clean(filename)
+	for _, path := range append([]string{store.path}, store.excluded...) {
+		if cleaned == path || strings.HasPrefix(cleaned, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Save copies file before the first modification in current run, skips excluded files
+func (store *BackupStore) Save(filename string) (err error) {
+	if !filepath.IsAbs(filename) || store.isExcluded(filename) || store.saved[filename] {
+		return nil
+	}
+	var entries []BackupEntry
+	if entries, err = store.List(); err != nil {
+		return
+	}
+	var now = time.Now()
+	var entry = BackupEntry{
+		ID:   fmt.Sprintf("%s-%d", now.Format(BackupTimeFormat), len(entries)+1),
+		Path: filename,
+		UID:  -1,
+		GID:  -1,
+		Time: now.Format(time.RFC3339),
+	}
+	var info os.FileInfo
+	if info, err = os.Stat(filename); nil == err {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		var data []byte
+		if data, err = ioutil.ReadFile(filename); err != nil {
+			return
+		}
+		if err = os.MkdirAll(store.path, 0700); err != nil {
+			return
+		}
+		entry.Backup = fmt.Sprintf("%s_%s", entry.ID, filepath.Base(filename))
+		entry.Mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.UID, entry.GID = int(stat.Uid), int(stat.Gid)
+		}
+		if err = replaceFile(filepath.Join(store.path, entry.Backup), data, 0600, false); err != nil {
+			err = fmt.Errorf("backup '%s' fail: %s", filename, err.Error())
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	} else if err = os.MkdirAll(store.path, 0700); err != nil {
+		return
+	}
+	if err = store.saveIndex(append(entries, entry)); err != nil {
+		return
+	}
+	store.saved[filename] = true
+	return nil
+}
+
+// List returns backups in order of creation
+func (store *BackupStore) List() (entries []BackupEntry, err error) {
+	var filename = filepath.Join(store.path, BackupIndexFileName)
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		err = fmt.Errorf("invalid backup index '%s': %s", filename, err.Error())
+		return
+	}
+	return entries, nil
+}
+
+func (store *BackupStore) saveIndex(entries []BackupEntry) (err error) {
+	var data []byte
+	if data, err = json.MarshalIndent(entries, "", " "); err != nil {
+		return
+	}
+	return replaceFile(filepath.Join(store.path, BackupIndexFileName), data, 0600, false)
+}
+
+// Restore puts file back to the state recorded by backup, or removes it when not existed
+func (store *BackupStore) Restore(id string) (err error) {
+	var entries []BackupEntry
+	if entries, err = store.List(); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if id != entry.ID {
+			continue
+		}
+		if "" == entry.Backup {
+			if !host.Exists(entry.Path) {
+				fmt.Printf("'%s' not exists already\n", entry.Path)
+				return nil
+			}
+			if err = host.Remove(entry.Path); err != nil {
+				return
+			}
+			fmt.Printf("'%s' removed, which not existed before backup %s\n", entry.Path, entry.ID)
+			return nil
+		}
+		var data []byte
+		if data, err = ioutil.ReadFile(filepath.Join(store.path, entry.Backup)); err != nil {
+			return
+		}
+		if err = host.WriteFile(entry.Path, data, entry.Mode); err != nil {
+			return
+		}
+		if err = host.Chmod(entry.Path, entry.Mode); err != nil {
+			return
+		}
+		if entry.UID >= 0 {
+			if err = host.Chown(entry.Path, entry.UID, entry.GID); err != nil {
+				return
+			}
+		}
+		fmt.Printf("'%s' restored from backup %s at %s\n", entry.Path, entry.ID, entry.Time)
+		return nil
+	}
+	return fmt.Errorf("no backup '%s' available", id)
+}
+
+// replaceFile writes data to a temporary file in the same directory then renames it to target,
+// so that target never partially written. Mode and owner of existing target kept when keepMode enabled
+func replaceFile(filename string, data []byte, perm os.FileMode, keepMode bool) (err error) {
+	if resolved, resolveError := filepath.EvalSymlinks(filename); nil == resolveError {
+		//replace target of link, instead of link itself
+		filename = resolved
+	}
+	var mode = perm.Perm()
+	var uid, gid = -1, -1
+	if info, statError := os.Stat(filename); nil == statError && keepMode {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != os.Getuid() || int(stat.Gid) != os.Getgid()) {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+	//created with 0600, so that content never exposed before mode applied
+	var file *os.File
+	if file, err = ioutil.TempFile(filepath.Dir(filename), fmt.Sprintf(".%s.*", filepath.Base(filename))); err != nil {
+		return
+	}
+	var tempFile = file.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tempFile)
+		}
+	}()
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tempFile, mode); err != nil {
+		return
+	}
+	if uid >= 0 {
+		if err = os.Chown(tempFile, uid, gid); err != nil {
+			return
+		}
+	}
+	return os.Rename(tempFile, filename)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BridgeBackendAuto           = "auto"
+	BridgeBackendNetworkScripts = "network-scripts"
+	BridgeBackendNetworkManager = "networkmanager"
+)
+
+// BridgeConfigurator creates bridge for cell persistently by network configure of host,
+// address, gateway and DNS of bridged interface migrated to bridge
+type BridgeConfigurator interface {
+	Name() string
+	// Available checks whether host network managed by this backend
+	Available() bool
+	// Check verifies devices of uplink could be bridged
+	Check(uplink Uplink) error
+	// Link creates bond and VLAN of uplink when required, then attaches it to bridge with configure of carrier
+	Link(uplink Uplink, bridgeName, zone string) error
+	// Unlink moves configure back to carrier of uplink, then removes bridge and devices created
+	Unlink(uplink Uplink, bridgeName string) error
+}
+
+func bridgeConfigurators() []BridgeConfigurator {
+	//netplan renders configure for NetworkManager or networkd, so checked first
+	return []BridgeConfigurator{&NetplanBridge{}, &NetworkManagerBridge{}, &NetworkdBridge{}, &NetworkScriptsBridge{}}
+}
+
+// newBridgeConfigurator returns backend by name, or the first available backend for auto
+func newBridgeConfigurator(name string) (configurator BridgeConfigurator, err error) {
+	var names []string
+	for _, current := range bridgeConfigurators() {
+		if name == current.Name() {
+			return current, nil
+		} else if BridgeBackendAuto == name && current.Available() {
+			return current, nil
+		}
+		names = append(names, current.Name())
+	}
+	if BridgeBackendAuto == name {
+		err = errors.New("no available network backend detected")
+	} else {
+		err = fmt.Errorf("invalid network backend '%s', must be %s/%s", name, BridgeBackendAuto, strings.Join(names, "/"))
+	}
+	return
+}
+
+// chooseBridgeConfigurator uses backend preset by answer, or detects when not specified
+func chooseBridgeConfigurator() (configurator BridgeConfigurator, err error) {
+	if configurator, err = newBridgeConfigurator(optionalAnswer(AnswerNetworkBackend, BridgeBackendAuto)); err != nil {
+		return
+	}
+	fmt.Printf("network backend %s selected\n", configurator.Name())
+	return configurator, nil
+}
+
+// installedBridgeConfigurator returns backend recorded in session, network-scripts for installation before recorded
+func installedBridgeConfigurator(session *SessionInfo) (BridgeConfigurator, error) {
+	if "" == session.NetworkBackend {
+		return newBridgeConfigurator(BridgeBackendNetworkScripts)
+	}
+	return newBridgeConfigurator(session.NetworkBackend)
+}
+
+// waitBridgeRoute waits until default route moved to bridge after configure applied
+func waitBridgeRoute(bridgeName string, timeout time.Duration) (err error) {
+	const (
+		CheckInterval = time.Second
+	)
+	if dryRunEnabled() {
+		return nil
+	}
+	var deadline = time.Now().Add(timeout)
+	for {
+		var routeInterface string
+		if routeInterface, err = defaultRouteInterface(); nil == err && bridgeName == routeInterface {
+			fmt.Printf("default route moved to %s\n", bridgeName)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(CheckInterval)
+	}
+	if err != nil {
+		return fmt.Errorf("default route not available on %s after %s: %s", bridgeName, timeout, err.Error())
+	}
+	return fmt.Errorf("default route not moved to %s after %s", bridgeName, timeout)
+}
+
+// NetworkScriptsBridge writes ifcfg scripts and restarts legacy network service, NetworkManager disabled
+type NetworkScriptsBridge struct {
+}
+
+func (configurator *NetworkScriptsBridge) Name() string {
+	return BridgeBackendNetworkScripts
+}
+
+func (configurator *NetworkScriptsBridge) Available() bool {
+	const (
+		NetworkServiceScript = "/etc/rc.d/init.d/network"
+	)
+	return host.Exists(NetworkScriptsPath) && host.Exists(NetworkServiceScript)
+}
+
+func (configurator *NetworkScriptsBridge) Check(uplink Uplink) (err error) {
+	for _, device := range uplink.Devices() {
+		var config InterfaceConfig
+		if config, err = readInterfaceConfig(interfaceScriptPath(device)); err != nil {
+			return fmt.Errorf("no usable script of %s: %s", device, err.Error())
+		}
+		if bridge, exists := config.Params["BRIDGE"]; exists {
+			return fmt.Errorf("%s already attached to %s", device, bridge)
+		}
+		if master, exists := config.Params["MASTER"]; exists {
+			return fmt.Errorf("%s already enslaved to %s", device, master)
+		}
+	}
+	return nil
+}
+
+func (configurator *NetworkScriptsBridge) Link(uplink Uplink, bridgeName, zone string) (err error) {
+	stopNetworkManager()
+	registerUndo("restart network service", func(operator HostOperator) error {
+		return services.Restart("network")
+	})
+	if err = linkBridge(uplink, bridgeName, zone); err != nil {
+		return
+	}
+	{
+		//restart network
+		if err = services.Stop("network"); err != nil {
+			fmt.Printf("warning: stop network service fail: %s\n", err.Error())
+		} else {
+			fmt.Println("network service stopped")
+		}
+		if err = services.Start("network"); err != nil {
+			fmt.Printf("warning: start network service fail: %s\n", err.Error())
+			return
+		} else {
+			fmt.Println("network service restarted")
+		}
+	}
+	return nil
+}
+
+func (configurator *NetworkScriptsBridge) Unlink(uplink Uplink, bridgeName string) error {
+	return unlinkBridge(uplink, bridgeName)
+}
+
+// stopNetworkManager stops and disables NetworkManager, only state changed here restored when rolling back
+func stopNetworkManager() {
+	const (
+		ServiceName = "NetworkManager"
+	)
+	var active, enabled bool
+	var err error
+	if active, err = services.IsActive(ServiceName); err != nil {
+		fmt.Printf("warning: query state of networkmanager fail: %s\n", err.Error())
+	} else if !active {
+		fmt.Println("network manager already stopped")
+	} else if err = services.Stop(ServiceName); err != nil {
+		fmt.Printf("warning: stop networkmanager fail: %s\n", err.Error())
+	} else {
+		//host operator already restored when rolling back
+		registerUndo("stop NetworkManager", func(operator HostOperator) error {
+			return services.Start(ServiceName)
+		})
+		fmt.Println("network manager stopped")
+	}
+	if enabled, err = services.IsEnabled(ServiceName); err != nil {
+		fmt.Printf("warning: query state of networkmanager fail: %s\n", err.Error())
+	} else if !enabled {
+		fmt.Println("network manager already disabled")
+	} else if err = services.Disable(ServiceName); err != nil {
+		fmt.Printf("warning: disable networkmanager fail: %s\n", err.Error())
+	} else {
+		registerUndo("disable NetworkManager", func(operator HostOperator) error {
+			return services.Enable(ServiceName)
+		})
+		fmt.Println("network manager disabled")
+	}
+}
+
+// NetworkManagerBridge creates bridge and slave connections by nmcli, saved as keyfile or ifcfg by the plugin of NetworkManager.
+// Original connection of interface kept with autoconnect disabled, and activated again when unlink
+type NetworkManagerBridge struct {
+}
+
+// settings migrated from connection of interface to bridge
+var networkManagerMigrateSettings = []string{
+	"ipv4.method", "ipv4.addresses", "ipv4.gateway", "ipv4.dns", "ipv4.dns-search", "ipv4.routes", "ipv4.never-default",
+	"ipv6.method", "ipv6.addresses", "ipv6.gateway", "ipv6.dns", "ipv6.dns-search", "ipv6.routes", "ipv6.addr-gen-mode",
+}
+
+func (configurator *NetworkManagerBridge) Name() string {
+	return BridgeBackendNetworkManager
+}
+
+func (configurator *NetworkManagerBridge) Available() bool {
+	if _, err := exec.LookPath("nmcli"); err != nil {
+		return false
+	}
+	active, _ := services.IsActive("NetworkManager")
+	return active
+}
+
+func (configurator *NetworkManagerBridge) Check(uplink Uplink) (err error) {
+	for _, device := range uplink.Devices() {
+		var connection string
+		if connection, err = configurator.deviceConnection(device); err != nil {
+			if device != uplink.Carrier {
+				//slave not connected
+				continue
+			}
+			return
+		}
+		var master string
+		if master, err = configurator.connectionSetting(connection, "connection.master"); err != nil {
+			return
+		} else if "" != master {
+			return fmt.Errorf("%s already attached to %s", device, master)
+		}
+	}
+	return nil
+}
+
+func (configurator *NetworkManagerBridge) slaveConnection(interfaceName, bridgeName string) string {
+	return fmt.Sprintf("%s-slave-%s", bridgeName, interfaceName)
+}
+
+// deviceConnection returns name of connection active on device
+func (configurator *NetworkManagerBridge) deviceConnection(interfaceName string) (connection string, err error) {
+	var output []byte
+	if output, err = exec.Command("nmcli", "-g", "GENERAL.CONNECTION", "device", "show", interfaceName).Output(); err != nil {
+		err = fmt.Errorf("get connection of %s fail: %s", interfaceName, err.Error())
+		return
+	}
+	if connection = strings.TrimSpace(string(output)); "" == connection {
+		err = fmt.Errorf("no active connection on %s", interfaceName)
+		return
+	}
+	return connection, nil
+}
+
+func (configurator *NetworkManagerBridge) connectionSetting(connection, setting string) (value string, err error) {
+	var output []byte
+	if output, err = exec.Command("nmcli", "-g", setting, "connection", "show", connection).Output(); err != nil {
+		err = fmt.Errorf("get %s of connection '%s' fail: %s", setting, connection, err.Error())
+		return
+	}
+	//terse output escapes colon
+	return strings.Replace(strings.TrimSpace(string(output)), `\:`, ":", -1), nil
+}
+
+// generatedConnections returns connections created for uplink, attached to bridge in order
+func (configurator *NetworkManagerBridge) generatedConnections(uplink Uplink, bridgeName string) (connections []string) {
+	var port = uplink.Port()
+	if uplink.CreateBond() {
+		for _, slave := range uplink.Slaves {
+			connections = append(connections, configurator.slaveConnection(slave, uplink.Interface))
+		}
+		connections = append(connections, uplink.Interface)
+	} else if 0 != uplink.VLAN {
+		connections = append(connections, configurator.parentConnection(uplink.Interface, bridgeName))
+	}
+	if 0 != uplink.VLAN {
+		connections = append(connections, port)
+	} else if !uplink.CreateBond() {
+		connections = append(connections, configurator.slaveConnection(port, bridgeName))
+	}
+	return connections
+}
+
+// parentConnection is the copy of original connection on parent of VLAN, without address
+func (configurator *NetworkManagerBridge) parentConnection(interfaceName, bridgeName string) string {
+	return fmt.Sprintf("%s-parent-%s", bridgeName, interfaceName)
+}
+
+func (configurator *NetworkManagerBridge) Link(uplink Uplink, bridgeName, zone string) (err error) {
+	var connection string
+	if connection, err = configurator.deviceConnection(uplink.Carrier); err != nil {
+		return
+	}
+	var bridgeArgs = []string{"connection", "add", "type", "bridge", "ifname", bridgeName, "con-name", bridgeName,
+		"bridge.stp", "no", "connection.zone", zone, "connection.autoconnect", "yes"}
+	var output []byte
+	//keep MAC, so that DHCP assigns the same address to bridge
+	if output, err = exec.Command("nmcli", "-g", "GENERAL.HWADDR", "device", "show", uplink.Carrier).Output(); nil == err {
+		if hardware := strings.Replace(strings.TrimSpace(string(output)), `\:`, ":", -1); "" != hardware {
+			bridgeArgs = append(bridgeArgs, "bridge.mac-address", hardware)
+		}
+	}
+	for _, setting := range networkManagerMigrateSettings {
+		var value string
+		if value, err = configurator.connectionSetting(connection, setting); err != nil {
+			return
+		}
+		if "" != value {
+			bridgeArgs = append(bridgeArgs, setting, value)
+		}
+	}
+	for _, device := range uplink.Devices() {
+		var origin string
+		if device == uplink.Carrier {
+			origin = connection
+		} else if origin, err = configurator.deviceConnection(device); err != nil {
+			//slave not connected
+			continue
+		}
+		registerUndo(fmt.Sprintf("disable autoconnect of connection '%s'", origin), func(operator HostOperator) (err error) {
+			if err = operator.Execute("nmcli", "connection", "modify", origin, "connection.autoconnect", "yes"); err != nil {
+				return
+			}
+			return operator.Execute("nmcli", "connection", "up", origin)
+		})
+		if err = host.Execute("nmcli", "connection", "modify", origin, "connection.autoconnect", "no"); err != nil {
+			return
+		}
+	}
+	if err = configurator.addConnection(bridgeName, bridgeArgs...); err != nil {
+		return
+	}
+	fmt.Printf("bridge connection '%s' created with settings of '%s'\n", bridgeName, connection)
+	var port = uplink.Port()
+	var bridgePort = []string{"connection.master", bridgeName, "connection.slave-type", "bridge"}
+	var noAddress = []string{"ipv4.method", "disabled", "ipv6.method", "ignore"}
+	if uplink.CreateBond() {
+		var args = []string{"connection", "add", "type", "bond", "ifname", uplink.Interface, "con-name", uplink.Interface,
+			"bond.options", fmt.Sprintf("mode=%s,miimon=%d", uplink.BondMode, BondMonitorInterval), "connection.autoconnect", "yes"}
+		if 0 == uplink.VLAN {
+			args = append(args, bridgePort...)
+		} else {
+			args = append(args, noAddress...)
+		}
+		if err = configurator.addConnection(uplink.Interface, args...); err != nil {
+			return
+		}
+		fmt.Printf("bond connection '%s' created\n", uplink.Interface)
+		for _, slave := range uplink.Slaves {
+			var name = configurator.slaveConnection(slave, uplink.Interface)
+			if err = configurator.addConnection(name, "connection", "add", "type", "bond-slave", "ifname", slave,
+				"con-name", name, "master", uplink.Interface, "connection.autoconnect", "yes"); err != nil {
+				return
+			}
+			fmt.Printf("slave connection '%s' created\n", name)
+		}
+	} else if 0 != uplink.VLAN {
+		//parent keeps link settings of original connection, address moved to bridge
+		var parent = configurator.parentConnection(uplink.Interface, bridgeName)
+		if err = host.Execute("nmcli", "connection", "clone", connection, parent); err != nil {
+			return
+		}
+		registerUndo(fmt.Sprintf("create connection '%s'", parent), func(operator HostOperator) error {
+			return operator.Execute("nmcli", "connection", "delete", parent)
+		})
+		if err = host.Execute("nmcli", append([]string{"connection", "modify", parent, "connection.autoconnect", "yes"}, noAddress...)...); err != nil {
+			return
+		}
+		fmt.Printf("parent connection '%s' created\n", parent)
+	}
+	if 0 != uplink.VLAN {
+		var args = []string{"connection", "add", "type", "vlan", "ifname", port, "con-name", port,
+			"dev", uplink.Interface, "id", strconv.Itoa(uplink.VLAN), "connection.autoconnect", "yes"}
+		if err = configurator.addConnection(port, append(args, bridgePort...)...); err != nil {
+			return
+		}
+		fmt.Printf("VLAN connection '%s' created\n", port)
+	} else if !uplink.CreateBond() {
+		var slave = configurator.slaveConnection(port, bridgeName)
+		if err = configurator.addConnection(slave, "connection", "add", "type", "bridge-slave", "ifname", port,
+			"con-name", slave, "master", bridgeName, "connection.autoconnect", "yes"); err != nil {
+			return
+		}
+		fmt.Printf("slave connection '%s' created\n", slave)
+	}
+	//activating generated connections takes over devices from original connections
+	for _, name := range configurator.generatedConnections(uplink, bridgeName) {
+		if err = host.Execute("nmcli", "connection", "up", name); err != nil {
+			return
+		}
+	}
+	if err = host.Execute("nmcli", "connection", "up", bridgeName); err != nil {
+		return
+	}
+	fmt.Printf("bridge %s activated\n", bridgeName)
+	return nil
+}
+
+// addConnection executes nmcli with args to add connection, which deleted when rolling back
+func (configurator *NetworkManagerBridge) addConnection(name string, args ...string) (err error) {
+	if err = host.Execute("nmcli", args...); err != nil {
+		return
+	}
+	registerUndo(fmt.Sprintf("create connection '%s'", name), func(operator HostOperator) error {
+		return operator.Execute("nmcli", "connection", "delete", name)
+	})
+	return nil
+}
+
+func (configurator *NetworkManagerBridge) Unlink(uplink Uplink, bridgeName string) (err error) {
+	var output []byte
+	if output, err = exec.Command("nmcli", "-g", "NAME", "connection", "show").Output(); err != nil {
+		return
+	}
+	var generated = append(configurator.generatedConnections(uplink, bridgeName), bridgeName)
+	var origins = map[string]string{}
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name = strings.Replace(name, `\:`, ":", -1)
+		if "" == name {
+			continue
+		}
+		var isGenerated = false
+		for _, current := range generated {
+			if name == current {
+				isGenerated = true
+				break
+			}
+		}
+		if isGenerated {
+			continue
+		}
+		var value, _ = configurator.connectionSetting(name, "connection.interface-name")
+		for _, device := range uplink.Devices() {
+			if _, exists := origins[device]; !exists && device == value {
+				origins[device] = name
+			}
+		}
+	}
+	for _, name := range generated {
+		if exists, _ := queryCommand("nmcli", "connection", "show", name); !exists {
+			continue
+		}
+		if err = host.Execute("nmcli", "connection", "delete", name); err != nil {
+			return
+		}
+		fmt.Printf("connection '%s' deleted\n", name)
+	}
+	for _, device := range uplink.Devices() {
+		var origin, exists = origins[device]
+		if !exists {
+			if device == uplink.Carrier {
+				fmt.Printf("warning: no original connection of %s available, configure it manually\n", device)
+			}
+			continue
+		}
+		if err = host.Execute("nmcli", "connection", "modify", origin, "connection.autoconnect", "yes"); err != nil {
+			return
+		}
+		if err = host.Execute("nmcli", "connection", "up", origin); err != nil {
+			return
+		}
+		fmt.Printf("connection '%s' of %s restored\n", origin, device)
+	}
+	return nil
+}
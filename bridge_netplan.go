@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	BridgeBackendNetplan = "netplan"
+	NetplanConfigPath    = "/etc/netplan"
+	//parsed after configure of system, so that overrides definition of uplink
+	NetplanFilePrefix = "90-nano"
+)
+
+// keys kept on device when bridged, others moved to bridge
+var netplanInterfaceKeys = map[string]bool{
+	"match": true, "set-name": true, "mtu": true, "wakeonlan": true, "optional": true, "renderer": true,
+	//members and options of bond, or parent and id of VLAN
+	"interfaces": true, "parameters": true, "id": true, "link": true,
+}
+
+// categories of device could carry address before bridged
+var netplanDeviceCategories = []string{"ethernets", "bonds", "vlans"}
+
+// NetplanBridge writes a separated netplan file defining bridge with settings copied from interface,
+// which overrides the interface defined in file of system, then applies by netplan.
+// Files of system never modified, and file of bridge removed when unlinked
+type NetplanBridge struct {
+}
+
+func (configurator *NetplanBridge) Name() string {
+	return BridgeBackendNetplan
+}
+
+func (configurator *NetplanBridge) Available() bool {
+	if _, err := exec.LookPath("netplan"); err != nil {
+		return false
+	}
+	files, _ := configurator.configFiles()
+	return 0 != len(files)
+}
+
+// configFiles returns netplan files of system, files generated by installer excluded
+func (configurator *NetplanBridge) configFiles() (files []string, err error) {
+	var matched []string
+	if matched, err = filepath.Glob(filepath.Join(NetplanConfigPath, "*.yaml")); err != nil {
+		return
+	}
+	sort.Strings(matched)
+	for _, filename := range matched {
+		if !strings.HasPrefix(filepath.Base(filename), NetplanFilePrefix+"-") {
+			files = append(files, filename)
+		}
+	}
+	return files, nil
+}
+
+func (configurator *NetplanBridge) bridgeFile(bridgeName string) string {
+	return filepath.Join(NetplanConfigPath, fmt.Sprintf("%s-%s.yaml", NetplanFilePrefix, bridgeName))
+}
+
+func (configurator *NetplanBridge) Check(uplink Uplink) (err error) {
+	if _, _, _, err = configurator.findDevice(uplink.Carrier); err != nil {
+		return
+	}
+	//including bridges generated by installer
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(NetplanConfigPath, "*.yaml")); err != nil {
+		return
+	}
+	for _, filename := range files {
+		var document yaml.MapSlice
+		if document, err = configurator.readFile(filename); err != nil {
+			return
+		}
+		var network, _ = netplanMapping(document, "network")
+		for _, category := range []string{"bridges", "bonds"} {
+			var definitions, _ = netplanMapping(network, category)
+			for _, item := range definitions {
+				var master = fmt.Sprint(item.Key)
+				var definition, _ = item.Value.(yaml.MapSlice)
+				for _, member := range netplanList(definition, "interfaces") {
+					for _, device := range uplink.Devices() {
+						if device == member && device != master {
+							return fmt.Errorf("%s already attached to %s", device, master)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (configurator *NetplanBridge) readFile(filename string) (document yaml.MapSlice, err error) {
+	var data []byte
+	if data, err = host.ReadFile(filename); err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		err = fmt.Errorf("invalid netplan file '%s': %s", filename, err.Error())
+		return
+	}
+	return document, nil
+}
+
+// findDefinition returns file containing definition of device in category like ethernets or bridges
+func (configurator *NetplanBridge) findDefinition(category, name string) (filename string, document yaml.MapSlice, err error) {
+	var files []string
+	if files, err = configurator.configFiles(); err != nil {
+		return
+	}
+	//later file overrides former
+	for index := len(files) - 1; index >= 0; index-- {
+		if document, err = configurator.readFile(files[index]); err != nil {
+			return
+		}
+		var network, _ = netplanMapping(document, "network")
+		var definitions, _ = netplanMapping(network, category)
+		if _, exists := netplanLookup(definitions, name); exists {
+			return files[index], document, nil
+		}
+	}
+	err = fmt.Errorf("no %s definition of %s in '%s'", category, name, NetplanConfigPath)
+	return
+}
+
+// findDevice returns category and file containing definition of device
+func (configurator *NetplanBridge) findDevice(name string) (category, filename string, document yaml.MapSlice, err error) {
+	for _, category = range netplanDeviceCategories {
+		if filename, document, err = configurator.findDefinition(category, name); nil == err {
+			return
+		}
+	}
+	err = fmt.Errorf("no definition of %s in '%s'", name, NetplanConfigPath)
+	return
+}
+
+func (configurator *NetplanBridge) Link(uplink Uplink, bridgeName, zone string) (err error) {
+	var category, filename string
+	var document yaml.MapSlice
+	if category, filename, document, err = configurator.findDevice(uplink.Carrier); err != nil {
+		return
+	}
+	var port = uplink.Port()
+	var network, _ = netplanMapping(document, "network")
+	var definitions, _ = netplanMapping(network, category)
+	var origin, _ = netplanMapping(definitions, uplink.Carrier)
+	//settings moved to bridge reset to null, which clears value defined in former file
+	var carrier = yaml.MapSlice{}
+	var bridge = yaml.MapSlice{{Key: "interfaces", Value: []interface{}{port}}}
+	for _, item := range origin {
+		if !netplanInterfaceKeys[fmt.Sprint(item.Key)] {
+			carrier = append(carrier, yaml.MapItem{Key: item.Key, Value: nil})
+			bridge = append(bridge, item)
+		}
+	}
+	carrier = netplanSet(carrier, "dhcp4", false)
+	carrier = netplanSet(carrier, "dhcp6", false)
+	//keep MAC, so that DHCP assigns the same address to bridge
+	if link, linkError := net.InterfaceByName(uplink.Carrier); nil == linkError && 0 != len(link.HardwareAddr) {
+		bridge = netplanSet(bridge, "macaddress", link.HardwareAddr.String())
+	}
+	bridge = netplanSet(bridge, "parameters", yaml.MapSlice{{Key: "stp", Value: false}, {Key: "forward-delay", Value: 0}})
+	var generated = yaml.MapSlice{{Key: "version", Value: 2}}
+	if renderer, exists := netplanLookup(network, "renderer"); exists {
+		generated = netplanSet(generated, "renderer", renderer)
+	}
+	generated = netplanSet(generated, category, yaml.MapSlice{{Key: uplink.Carrier, Value: carrier}})
+	var noAddress = yaml.MapSlice{{Key: "dhcp4", Value: false}, {Key: "dhcp6", Value: false}}
+	if uplink.CreateBond() {
+		var ethernets, _ = netplanMapping(generated, "ethernets")
+		var members []interface{}
+		for _, slave := range uplink.Slaves {
+			//members must be defined, even without settings
+			if _, _, definitionError := configurator.findDefinition("ethernets", slave); definitionError != nil {
+				ethernets = netplanSet(ethernets, slave, yaml.MapSlice{})
+			}
+			members = append(members, slave)
+		}
+		if 0 != len(ethernets) {
+			generated = netplanSet(generated, "ethernets", ethernets)
+		}
+		var bonds, _ = netplanMapping(generated, "bonds")
+		var bond = append(yaml.MapSlice{
+			{Key: "interfaces", Value: members},
+			{Key: "parameters", Value: yaml.MapSlice{{Key: "mode", Value: uplink.BondMode}, {Key: "mii-monitor-interval", Value: BondMonitorInterval}}},
+		}, noAddress...)
+		generated = netplanSet(generated, "bonds", netplanSet(bonds, uplink.Interface, bond))
+	}
+	if 0 != uplink.VLAN {
+		var vlans, _ = netplanMapping(generated, "vlans")
+		var vlan = append(yaml.MapSlice{{Key: "id", Value: uplink.VLAN}, {Key: "link", Value: uplink.Interface}}, noAddress...)
+		generated = netplanSet(generated, "vlans", netplanSet(vlans, port, vlan))
+	}
+	generated = netplanSet(generated, "bridges", yaml.MapSlice{{Key: bridgeName, Value: bridge}})
+	registerUndo("apply netplan", func(operator HostOperator) error {
+		return operator.Execute("netplan", "apply")
+	})
+	var bridgeFile = configurator.bridgeFile(bridgeName)
+	if err = configurator.writeFile(bridgeFile, yaml.MapSlice{{Key: "network", Value: generated}}); err != nil {
+		return
+	}
+	fmt.Printf("bridge %s defined in '%s' with settings of %s copied from '%s'\n", bridgeName, bridgeFile, uplink.Carrier, filename)
+	return configurator.apply()
+}
+
+func (configurator *NetplanBridge) Unlink(uplink Uplink, bridgeName string) (err error) {
+	var bridgeFile = configurator.bridgeFile(bridgeName)
+	if !host.Exists(bridgeFile) {
+		err = fmt.Errorf("no netplan file '%s' generated for bridge %s", bridgeFile, bridgeName)
+		return
+	}
+	if err = host.Remove(bridgeFile); err != nil {
+		return
+	}
+	fmt.Printf("'%s' removed, settings of %s in netplan files of system take effect again\n", bridgeFile, uplink.Carrier)
+	if err = deleteUplinkLinks(uplink, bridgeName); err != nil {
+		return
+	}
+	return configurator.apply()
+}
+
+func (configurator *NetplanBridge) writeFile(filename string, document yaml.MapSlice) (err error) {
+	var data []byte
+	if data, err = yaml.Marshal(document); err != nil {
+		return
+	}
+	//netplan warns when configure readable by others
+	return host.WriteFile(filename, data, 0600)
+}
+
+// apply validates by generating backend configure before applying
+func (configurator *NetplanBridge) apply() (err error) {
+	if err = host.Execute("netplan", "generate"); err != nil {
+		err = fmt.Errorf("netplan generate fail: %s", err.Error())
+		return
+	}
+	if err = host.Execute("netplan", "apply"); err != nil {
+		err = fmt.Errorf("netplan apply fail: %s", err.Error())
+		return
+	}
+	fmt.Println("netplan applied")
+	return nil
+}
+
+// netplanList returns items of sequence as strings
+func netplanList(mapping yaml.MapSlice, key string) (items []string) {
+	var value, _ = netplanLookup(mapping, key)
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+	}
+	return items
+}
+
+func netplanLookup(mapping yaml.MapSlice, key string) (value interface{}, exists bool) {
+	for _, item := range mapping {
+		if key == fmt.Sprint(item.Key) {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// netplanMapping returns empty mapping when key not exists or not a mapping
+func netplanMapping(mapping yaml.MapSlice, key string) (value yaml.MapSlice, exists bool) {
+	var current interface{}
+	if current, exists = netplanLookup(mapping, key); !exists {
+		return yaml.MapSlice{}, false
+	}
+	if value, exists = current.(yaml.MapSlice); !exists {
+		return yaml.MapSlice{}, false
+	}
+	return value, true
+}
+
+// netplanSet replaces value in place, or appends when key not exists
+func netplanSet(mapping yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for index, item := range mapping {
+		if key == fmt.Sprint(item.Key) {
+			mapping[index].Value = value
+			return mapping
+		}
+	}
+	return append(mapping, yaml.MapItem{Key: key, Value: value})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	BridgeBackendNetworkd = "networkd"
+	NetworkdConfigPath    = "/etc/systemd/network"
+	NetworkdServiceName   = "systemd-networkd"
+	// lexically before configures of host, so that matched first
+	NetworkdFilePrefix = "05-nano"
+)
+
+// NetworkdBridge generates .netdev/.network files for systemd-networkd with higher priority than the configure of interface,
+// which kept unchanged, so that removing generated files restores the interface
+type NetworkdBridge struct {
+}
+
+// networkdSection is a section of unit file, lines kept as is
+type networkdSection struct {
+	Name  string
+	Lines []string
+}
+
+func (configurator *NetworkdBridge) Name() string {
+	return BridgeBackendNetworkd
+}
+
+func (configurator *NetworkdBridge) Available() bool {
+	active, _ := services.IsActive(NetworkdServiceName)
+	return active
+}
+
+func (configurator *NetworkdBridge) Check(uplink Uplink) (err error) {
+	for _, device := range uplink.Devices() {
+		var filename string
+		if filename, err = configurator.networkFile(device); err != nil {
+			if device != uplink.Carrier {
+				//slave not configured
+				continue
+			}
+			return
+		}
+		var sections []networkdSection
+		if sections, err = configurator.readSections(filename); err != nil {
+			return
+		}
+		for _, section := range sections {
+			for _, line := range section.Lines {
+				if key, value := splitNetworkdLine(line); "Network" == section.Name && ("Bridge" == key || "Bond" == key) {
+					return fmt.Errorf("%s already attached to %s", device, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (configurator *NetworkdBridge) generatedFile(name, extension string) string {
+	return filepath.Join(NetworkdConfigPath, fmt.Sprintf("%s-%s.%s", NetworkdFilePrefix, name, extension))
+}
+
+// generatedFiles returns .netdev and .network files of bridge and devices of uplink
+func (configurator *NetworkdBridge) generatedFiles(uplink Uplink, bridgeName string) (files []string) {
+	var netdevs = []string{bridgeName}
+	var networks = append([]string{bridgeName}, uplink.Devices()...)
+	if uplink.CreateBond() {
+		netdevs = append(netdevs, uplink.Interface)
+		networks = append(networks, uplink.Interface)
+	}
+	if 0 != uplink.VLAN {
+		netdevs = append(netdevs, uplink.Port())
+		networks = append(networks, uplink.Port())
+	}
+	for _, name := range netdevs {
+		files = append(files, configurator.generatedFile(name, "netdev"))
+	}
+	for _, name := range networks {
+		files = append(files, configurator.generatedFile(name, "network"))
+	}
+	return files
+}
+
+// networkFile returns .network file applied to interface
+func (configurator *NetworkdBridge) networkFile(interfaceName string) (filename string, err error) {
+	const (
+		NetworkFileLabel = "Network File:"
+	)
+	var output []byte
+	if output, err = exec.Command("networkctl", "status", "--no-pager", interfaceName).Output(); err != nil {
+		err = fmt.Errorf("get status of %s fail: %s", interfaceName, err.Error())
+		return
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, NetworkFileLabel) {
+			filename = strings.TrimSpace(strings.TrimPrefix(line, NetworkFileLabel))
+			break
+		}
+	}
+	if "" == filename || "n/a" == filename {
+		err = fmt.Errorf("%s not managed by %s", interfaceName, NetworkdServiceName)
+		return
+	}
+	return filename, nil
+}
+
+func (configurator *NetworkdBridge) readSections(filename string) (sections []networkdSection, err error) {
+	var data []byte
+	if data, err = host.ReadFile(filename); err != nil {
+		return
+	}
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sections = append(sections, networkdSection{Name: strings.Trim(line, "[]")})
+			continue
+		}
+		if 0 == len(sections) {
+			err = fmt.Errorf("line '%s' out of section in '%s'", line, filename)
+			return
+		}
+		var current = &sections[len(sections)-1]
+		current.Lines = append(current.Lines, line)
+	}
+	return sections, nil
+}
+
+func splitNetworkdLine(line string) (key, value string) {
+	var index = strings.Index(line, "=")
+	if -1 == index {
+		return line, ""
+	}
+	return strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:])
+}
+
+func formatNetworkdSections(sections []networkdSection) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("#generated by nano installer\n")
+	for index, section := range sections {
+		if 0 != index {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(fmt.Sprintf("[%s]\n", section.Name))
+		for _, line := range section.Lines {
+			buffer.WriteString(line + "\n")
+		}
+	}
+	return buffer.Bytes()
+}
+
+func (configurator *NetworkdBridge) Link(uplink Uplink, bridgeName, zone string) (err error) {
+	var origin string
+	if origin, err = configurator.networkFile(uplink.Carrier); err != nil {
+		return
+	}
+	var sections []networkdSection
+	if sections, err = configurator.readSections(origin); err != nil {
+		return
+	}
+	type unitFile struct {
+		Name     string
+		Sections []networkdSection
+	}
+	var port = uplink.Port()
+	var bridgeNetdev = []networkdSection{
+		{"NetDev", []string{"Name=" + bridgeName, "Kind=bridge"}},
+		{"Bridge", []string{"STP=false", "ForwardDelaySec=0"}},
+	}
+	//keep MAC, so that DHCP assigns the same address to bridge
+	if link, linkError := net.InterfaceByName(uplink.Carrier); nil == linkError && 0 != len(link.HardwareAddr) {
+		bridgeNetdev[0].Lines = append(bridgeNetdev[0].Lines, "MACAddress="+link.HardwareAddr.String())
+	}
+	//address, route and DHCP settings moved to bridge
+	var bridgeNetwork = []networkdSection{{"Match", []string{"Name=" + bridgeName}}}
+	for _, section := range sections {
+		if "Match" == section.Name || "Link" == section.Name {
+			continue
+		}
+		bridgeNetwork = append(bridgeNetwork, section)
+	}
+	var files = []unitFile{
+		{configurator.generatedFile(bridgeName, "netdev"), bridgeNetdev},
+		{configurator.generatedFile(bridgeName, "network"), bridgeNetwork},
+	}
+	//member of bridge, or parent of VLAN without address
+	var lowerNetwork = func(name string) []networkdSection {
+		var network = networkdSection{"Network", []string{"LinkLocalAddressing=no"}}
+		if port == name {
+			network.Lines = append(network.Lines, "Bridge="+bridgeName)
+		} else {
+			network.Lines = append(network.Lines, "VLAN="+port)
+		}
+		return []networkdSection{{"Match", []string{"Name=" + name}}, network}
+	}
+	if uplink.CreateBond() {
+		files = append(files, unitFile{configurator.generatedFile(uplink.Interface, "netdev"), []networkdSection{
+			{"NetDev", []string{"Name=" + uplink.Interface, "Kind=bond"}},
+			{"Bond", []string{"Mode=" + uplink.BondMode, fmt.Sprintf("MIIMonitorSec=%dms", BondMonitorInterval)}},
+		}})
+		files = append(files, unitFile{configurator.generatedFile(uplink.Interface, "network"), lowerNetwork(uplink.Interface)})
+		for _, slave := range uplink.Slaves {
+			files = append(files, unitFile{configurator.generatedFile(slave, "network"), []networkdSection{
+				{"Match", []string{"Name=" + slave}},
+				{"Network", []string{"Bond=" + uplink.Interface}},
+			}})
+		}
+	} else {
+		files = append(files, unitFile{configurator.generatedFile(uplink.Interface, "network"), lowerNetwork(uplink.Interface)})
+	}
+	if 0 != uplink.VLAN {
+		files = append(files, unitFile{configurator.generatedFile(port, "netdev"), []networkdSection{
+			{"NetDev", []string{"Name=" + port, "Kind=vlan"}},
+			{"VLAN", []string{"Id=" + strconv.Itoa(uplink.VLAN)}},
+		}})
+		files = append(files, unitFile{configurator.generatedFile(port, "network"), lowerNetwork(port)})
+	}
+	registerUndo(fmt.Sprintf("restart %s", NetworkdServiceName), func(operator HostOperator) error {
+		return services.Restart(NetworkdServiceName)
+	})
+	for _, file := range files {
+		if err = host.WriteFile(file.Name, formatNetworkdSections(file.Sections), 0644); err != nil {
+			return
+		}
+		fmt.Printf("'%s' generated\n", file.Name)
+	}
+	fmt.Printf("settings of %s migrated from '%s'\n", bridgeName, origin)
+	if err = services.Restart(NetworkdServiceName); err != nil {
+		return
+	}
+	fmt.Printf("%s restarted\n", NetworkdServiceName)
+	return nil
+}
+
+func (configurator *NetworkdBridge) Unlink(uplink Uplink, bridgeName string) (err error) {
+	for _, filename := range configurator.generatedFiles(uplink, bridgeName) {
+		if !host.Exists(filename) {
+			continue
+		}
+		if err = host.Remove(filename); err != nil {
+			return
+		}
+		fmt.Printf("'%s' removed\n", filename)
+	}
+	if err = deleteUplinkLinks(uplink, bridgeName); err != nil {
+		return
+	}
+	if err = services.Restart(NetworkdServiceName); err != nil {
+		return
+	}
+	fmt.Printf("%s restarted\n", NetworkdServiceName)
+	return nil
+}
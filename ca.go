@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KeyTypeRSA3072   = "rsa3072"
+	KeyTypeRSA4096   = "rsa4096"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeEd25519   = "ed25519"
+)
+
+const (
+	DefaultCertKeyType       = KeyTypeRSA3072
+	DefaultCALifetimeDays    = 3650
+	DefaultCertLifetimeDays  = 825
+	DefaultExpiryWarningDays = 30
+	SerialNumberBits         = 128
+	CertificatePathName      = "cert"
+	CertFileSuffix           = ".crt.pem"
+	KeyFileSuffix            = ".key.pem"
+	GeneratedCAName          = ProjectName + " Root CA"
+)
+
+var certKeyTypes = []string{KeyTypeRSA3072, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519}
+
+// CertificateProfile decides key and lifetime of certificates issued, recorded in session so that renewal issues the same
+type CertificateProfile struct {
+	KeyType          string   `json:"key_type"`
+	CALifetimeDays   int      `json:"ca_lifetime_days"`
+	CertLifetimeDays int      `json:"cert_lifetime_days"`
+	Names            []string `json:"names,omitempty"`
+}
+
+// ModuleCertificate is a server certificate issued for module, stored in cert path of module.
+// Certificate and key specified by answers imported instead of issued.
+// ConfigFile is configure of module which loads the certificate, empty when not consumed by module yet
+type ModuleCertificate struct {
+	Module     string
+	Name       string
+	CommonName string
+	CertAnswer string
+	KeyAnswer  string
+	ConfigFile string
+}
+
+var (
+	ImageCertificate  = ModuleCertificate{"core", "image", "ImageServer", AnswerImageCertFile, AnswerImageKeyFile, "image.cfg"}
+	APICertificate    = ModuleCertificate{"core", "api", "API", AnswerAPICertFile, AnswerAPIKeyFile, ""}
+	PortalCertificate = ModuleCertificate{"frontend", "portal", "Portal", AnswerPortalCertFile, AnswerPortalKeyFile, ""}
+)
+
+var moduleCertificates = []ModuleCertificate{ImageCertificate, APICertificate, PortalCertificate}
+
+// Consumed returns true when certificate loaded by module, so that module restarted after renewed
+func (target ModuleCertificate) Consumed() bool {
+	return "" != target.ConfigFile
+}
+
+func (target ModuleCertificate) Files(projectPath string) (certFile, keyFile string) {
+	var prefix = filepath.Join(projectPath, target.Module, CertificatePathName, fmt.Sprintf("%s_%s", ProjectName, target.Name))
+	return prefix + CertFileSuffix, prefix + KeyFileSuffix
+}
+
+// CertificateFile is a certificate installed under project path
+type CertificateFile struct {
+	Name string
+	File string
+}
+
+// installedCertificateFiles lists CA and certificates of all modules, which may not exist
+func installedCertificateFiles(projectPath string) []CertificateFile {
+	var files = []CertificateFile{{"root CA", filepath.Join(projectPath, CertificatePathName, fmt.Sprintf("%s_ca%s", ProjectName, CertFileSuffix))}}
+	for _, target := range moduleCertificates {
+		var certFile, _ = target.Files(projectPath)
+		files = append(files, CertificateFile{target.Name, certFile})
+	}
+	return files
+}
+
+// CertificateAuthority signs leaf certificates for modules. CA could be an intermediate,
+// then Chain holds the CA and its issuers sent with leaf certificates, and Root is nil when not included
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	CertPEM     []byte
+	Chain       []*x509.Certificate
+	Root        *x509.Certificate
+	signer      crypto.Signer
+}
+
+// inputCertificateProfile loads profile from answers, values of base used when not specified, then defaults
+func inputCertificateProfile(base CertificateProfile) (profile CertificateProfile, err error) {
+	if "" == base.KeyType {
+		base.KeyType = DefaultCertKeyType
+	}
+	if 0 == base.CALifetimeDays {
+		base.CALifetimeDays = DefaultCALifetimeDays
+	}
+	if 0 == base.CertLifetimeDays {
+		base.CertLifetimeDays = DefaultCertLifetimeDays
+	}
+	profile.KeyType = strings.ToLower(optionalAnswer(AnswerCertKeyType, base.KeyType))
+	if err = validateKeyType(profile.KeyType); err != nil {
+		return
+	}
+	if profile.CALifetimeDays, err = lifetimeAnswer(AnswerCALifetimeDays, base.CALifetimeDays); err != nil {
+		return
+	}
+	if profile.CertLifetimeDays, err = lifetimeAnswer(AnswerCertLifetimeDays, base.CertLifetimeDays); err != nil {
+		return
+	}
+	profile.Names = base.Names
+	if names := optionalAnswer(AnswerCertNames, ""); "" != names {
+		profile.Names = nil
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); "" != name {
+				profile.Names = append(profile.Names, name)
+			}
+		}
+	}
+	return profile, nil
+}
+
+func lifetimeAnswer(key string, defaultDays int) (days int, err error) {
+	var value = optionalAnswer(key, strconv.Itoa(defaultDays))
+	if days, err = strconv.Atoi(value); err != nil || days <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s', days required", key, value)
+	}
+	return days, nil
+}
+
+func validateKeyType(keyType string) error {
+	for _, supported := range certKeyTypes {
+		if keyType == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid key type '%s', %s supported", keyType, strings.Join(certKeyTypes, "/"))
+}
+
+func generatePrivateKey(keyType string) (key crypto.Signer, err error) {
+	switch keyType {
+	case KeyTypeRSA3072:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeRSA4096:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = validateKeyType(keyType)
+	}
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s private key generated\n", keyType)
+	return key, nil
+}
+
+// marshalPrivateKey encodes key as PKCS#8 PEM
+func marshalPrivateKey(key crypto.Signer) (data []byte, err error) {
+	var content []byte
+	if content, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+		return
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: content}), nil
+}
+
+// parsePrivateKey decodes PKCS#8, and PKCS#1 / SEC 1 key generated by earlier version
+func parsePrivateKey(data []byte) (key crypto.Signer, err error) {
+	var block, _ = pem.Decode(data)
+	if nil == block {
+		return nil, errors.New("no PEM data")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return
+		}
+		var ok bool
+		if key, ok = parsed.(crypto.Signer); !ok {
+			return nil, fmt.Errorf("unsupported private key %T", parsed)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM type '%s'", block.Type)
+	}
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	var limit = new(big.Int).Lsh(big.NewInt(1), SerialNumberBits)
+	return rand.Int(rand.Reader, limit)
+}
+
+// keyUsage returns usage of leaf key, key encipherment only available for RSA
+func keyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, isRSA := key.(*rsa.PrivateKey); isRSA {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// newCertificateAuthority generates a self-signed root CA, returns PEM of key for storing
+func newCertificateAuthority(profile CertificateProfile) (authority *CertificateAuthority, keyPEM []byte, err error) {
+	var key crypto.Signer
+	if key, err = generatePrivateKey(profile.KeyType); err != nil {
+		return
+	}
+	var serialNumber *big.Int
+	if serialNumber, err = randomSerialNumber(); err != nil {
+		return
+	}
+	var now = time.Now()
+	var template = x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   GeneratedCAName,
+			Organization: []string{ProjectName},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 0, profile.CALifetimeDays),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	var content []byte
+	if content, err = x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key); err != nil {
+		return
+	}
+	if keyPEM, err = marshalPrivateKey(key); err != nil {
+		return
+	}
+	authority = &CertificateAuthority{signer: key, CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: content})}
+	if authority.Certificate, err = x509.ParseCertificate(content); err != nil {
+		return
+	}
+	authority.Root = authority.Certificate
+	return authority, keyPEM, nil
+}
+
+// loadCertificateAuthority loads CA from PEM files, the key must match the certificate.
+// Certificate file may contain issuers following the CA, and key file is empty when key not available
+func loadCertificateAuthority(certFile, keyFile string) (authority *CertificateAuthority, err error) {
+	authority = &CertificateAuthority{}
+	if authority.CertPEM, err = host.ReadFile(certFile); err != nil {
+		return
+	}
+	var certificates []*x509.Certificate
+	if certificates, err = parseCertificateChain(authority.CertPEM); err != nil {
+		err = fmt.Errorf("load CA certificate '%s' fail: %s", certFile, err.Error())
+		return
+	}
+	authority.Certificate = certificates[0]
+	for _, certificate := range certificates {
+		if isSelfSigned(certificate) {
+			authority.Root = certificate
+		} else {
+			authority.Chain = append(authority.Chain, certificate)
+		}
+	}
+	if "" == keyFile {
+		return authority, nil
+	}
+	var keyPEM []byte
+	if keyPEM, err = host.ReadFile(keyFile); err != nil {
+		return
+	}
+	if keyPEM, err = decryptCAKey(keyPEM); err != nil {
+		err = fmt.Errorf("load CA key '%s' fail: %s", keyFile, err.Error())
+		return
+	}
+	if authority.signer, err = parsePrivateKey(keyPEM); err != nil {
+		err = fmt.Errorf("load CA key '%s' fail: %s", keyFile, err.Error())
+		return
+	}
+	if err = matchPublicKey(authority.Certificate, authority.signer); err != nil {
+		err = fmt.Errorf("CA key '%s' not match certificate '%s': %s", keyFile, certFile, err.Error())
+		return
+	}
+	return authority, nil
+}
+
+func matchPublicKey(certificate *x509.Certificate, key crypto.Signer) error {
+	type comparablePublicKey interface {
+		Equal(crypto.PublicKey) bool
+	}
+	public, ok := key.Public().(comparablePublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key %T", key.Public())
+	}
+	if !public.Equal(certificate.PublicKey) {
+		return errors.New("public key mismatched")
+	}
+	return nil
+}
+
+// Issue signs a server certificate with a new key, PEM of certificate and key returned
+func (authority *CertificateAuthority) Issue(commonName string, dnsNames []string, addresses []net.IP,
+	profile CertificateProfile) (certPEM, keyPEM []byte, err error) {
+	if nil == authority.signer {
+		err = errors.New("key of CA not available, import certificate of module instead")
+		return
+	}
+	var key crypto.Signer
+	if key, err = generatePrivateKey(profile.KeyType); err != nil {
+		return
+	}
+	var serialNumber *big.Int
+	if serialNumber, err = randomSerialNumber(); err != nil {
+		return
+	}
+	var now = time.Now()
+	var notAfter = now.AddDate(0, 0, profile.CertLifetimeDays)
+	if notAfter.After(authority.Certificate.NotAfter) {
+		//never outlive the CA
+		notAfter = authority.Certificate.NotAfter
+	}
+	var template = x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("%s %s", ProjectName, commonName),
+			Organization: []string{ProjectName},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              notAfter,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              keyUsage(key),
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           addresses,
+	}
+	var content []byte
+	if content, err = x509.CreateCertificate(rand.Reader, &template, authority.Certificate, key.Public(), authority.signer); err != nil {
+		return
+	}
+	if keyPEM, err = marshalPrivateKey(key); err != nil {
+		return
+	}
+	certPEM = encodeCertificates(append([]*x509.Certificate{{Raw: content}}, authority.Chain...))
+	return certPEM, keyPEM, nil
+}
+
+// parseCertificateChain parses all certificates in PEM data, at least one required
+func parseCertificateChain(data []byte) (certificates []*x509.Certificate, err error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); nil == block {
+			break
+		}
+		if "CERTIFICATE" != block.Type {
+			continue
+		}
+		var certificate *x509.Certificate
+		if certificate, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return
+		}
+		certificates = append(certificates, certificate)
+	}
+	if 0 == len(certificates) {
+		return nil, errors.New("no certificate available")
+	}
+	return certificates, nil
+}
+
+func encodeCertificates(certificates []*x509.Certificate) []byte {
+	var data []byte
+	for _, certificate := range certificates {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+	return data
+}
+
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return bytes.Equal(certificate.RawSubject, certificate.RawIssuer) && nil == certificate.CheckSignatureFrom(certificate)
+}
+
+// certificateAddresses returns addresses module listens on, API address only served by core,
+// since it is address of remote core for frontend installed alone
+func certificateAddresses(session *SessionInfo, target ModuleCertificate) []string {
+	var addresses = []string{session.LocalAddress}
+	if APICertificate.Module == target.Module {
+		addresses = append(addresses, session.APIAddress)
+	}
+	return addresses
+}
+
+// certificateCandidates returns addresses and names certificate of module serving for: listen address, hostname and names specified
+func certificateCandidates(session *SessionInfo, target ModuleCertificate) []string {
+	var candidates = certificateAddresses(session, target)
+	if hostname, hostError := os.Hostname(); nil == hostError {
+		candidates = append(candidates, hostname)
+	}
+	return append(candidates, session.CertProfile.Names...)
+}
+
+// certificateNames collects DNS and IP SANs of certificate for module
+func certificateNames(session *SessionInfo, target ModuleCertificate) (dnsNames []string, addresses []net.IP, err error) {
+	var candidates = certificateCandidates(session, target)
+	var added = map[string]bool{}
+	for _, name := range candidates {
+		if "" == name || added[name] {
+			continue
+		}
+		added[name] = true
+		if ip := net.ParseIP(name); nil != ip {
+			addresses = append(addresses, ip)
+		} else {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	if 0 == len(addresses) && 0 == len(dnsNames) {
+		err = errors.New("no address or hostname available for certificate")
+		return
+	}
+	return dnsNames, addresses, nil
+}
+
+// issueModuleCertificate signs certificate of module by installed CA, skipped when already issued.
+// Certificate specified by answer imported after validated
+func issueModuleCertificate(session *SessionInfo, target ModuleCertificate) (certFile, keyFile string, err error) {
+	certFile, keyFile = target.Files(session.ProjectPath)
+	if !target.Consumed() {
+		fmt.Printf("note: %s certificate reserved, not loaded by module %s yet\n", target.Name, target.Module)
+	}
+	if importFile := optionalAnswer(target.CertAnswer, ""); "" != importFile {
+		if err = importModuleCertificate(session, target, importFile, optionalAnswer(target.KeyAnswer, "")); err != nil {
+			err = fmt.Errorf("import %s certificate fail: %s", target.Name, err.Error())
+			return
+		}
+		return certFile, keyFile, nil
+	}
+	if host.Exists(certFile) && host.Exists(keyFile) {
+		fmt.Printf("%s certificate '%s' already issued\n", target.Name, certFile)
+		return certFile, keyFile, nil
+	}
+	if err = signModuleCertificate(session, target); err != nil {
+		return
+	}
+	return certFile, keyFile, nil
+}
+
+// signModuleCertificate issues a new certificate of module by installed CA, overwriting the current one
+func signModuleCertificate(session *SessionInfo, target ModuleCertificate) (err error) {
+	var certFile, keyFile = target.Files(session.ProjectPath)
+	if err = ensurePath(filepath.Dir(certFile), target.Name+" cert", session.UID, session.GID); err != nil {
+		return
+	}
+	var signingKey string
+	if signingKey, err = signingKeyFile(session); err != nil {
+		return
+	}
+	var authority *CertificateAuthority
+	if authority, err = loadCertificateAuthority(session.CACertPath, signingKey); err != nil {
+		return
+	}
+	var dnsNames []string
+	var addresses []net.IP
+	if dnsNames, addresses, err = certificateNames(session, target); err != nil {
+		return
+	}
+	var certPEM, keyPEM []byte
+	if certPEM, keyPEM, err = authority.Issue(target.CommonName, dnsNames, addresses, session.CertProfile); err != nil {
+		err = fmt.Errorf("issue %s certificate fail: %s", target.Name, err.Error())
+		return
+	}
+	if err = writeCertificatePair(session, certFile, certPEM, keyFile, keyPEM); err != nil {
+		return
+	}
+	fmt.Printf("%s certificate '%s' issued for %s\n", target.Name, certFile, describeNames(dnsNames, addresses))
+	return nil
+}
+
+// writeCertificatePair writes key readable only by service user
+func writeCertificatePair(session *SessionInfo, certFile string, certPEM []byte, keyFile string, keyPEM []byte) (err error) {
+	if err = writePrivateKey(session, keyFile, keyPEM); err != nil {
+		return
+	}
+	if err = host.WriteFile(certFile, certPEM, DefaultFilePerm); err != nil {
+		return
+	}
+	return updateAccess(session, certFile)
+}
+
+func describeNames(dnsNames []string, addresses []net.IP) string {
+	var names = append([]string{}, dnsNames...)
+	for _, address := range addresses {
+		names = append(names, address.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	CAKeyPassphraseEnv      = "NANO_CA_KEY_PASSPHRASE"
+	EncryptedKeyPEMType     = "ENCRYPTED PRIVATE KEY"
+	KeyFilePerm             = 0600
+	KeyDerivationIterations = 210000
+	KeyDerivationSaltSize   = 16
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo defined by RFC 5208, encrypted with PBES2 of RFC 8018
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Parameters struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Parameters struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// encryptPrivateKey encodes key as encrypted PKCS#8 by passphrase, PBKDF2 with HMAC-SHA256 and AES-256-CBC, readable by openssl
+func encryptPrivateKey(keyPEM []byte, passphrase string) (data []byte, err error) {
+	var key crypto.Signer
+	if key, err = parsePrivateKey(keyPEM); err != nil {
+		return
+	}
+	var content []byte
+	if content, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+		return
+	}
+	var salt = make([]byte, KeyDerivationSaltSize)
+	var iv = make([]byte, aes.BlockSize)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	if _, err = rand.Read(iv); err != nil {
+		return
+	}
+	const (
+		KeyLength = 32
+	)
+	var block cipher.Block
+	if block, err = aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, KeyDerivationIterations, KeyLength, sha256.New)); err != nil {
+		return
+	}
+	//PKCS#7 padding
+	var padding = aes.BlockSize - len(content)%aes.BlockSize
+	content = append(content, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	var encrypted = make([]byte, len(content))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, content)
+
+	var kdf pbkdf2Parameters
+	kdf.Salt = salt
+	kdf.IterationCount = KeyDerivationIterations
+	kdf.PRF = pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}
+	var info encryptedPrivateKeyInfo
+	var parameters pbes2Parameters
+	parameters.KeyDerivationFunc.Algorithm = oidPBKDF2
+	if parameters.KeyDerivationFunc.Parameters.FullBytes, err = asn1.Marshal(kdf); err != nil {
+		return
+	}
+	parameters.EncryptionScheme.Algorithm = oidAES256CBC
+	if parameters.EncryptionScheme.Parameters.FullBytes, err = asn1.Marshal(iv); err != nil {
+		return
+	}
+	info.Algorithm.Algorithm = oidPBES2
+	if info.Algorithm.Parameters.FullBytes, err = asn1.Marshal(parameters); err != nil {
+		return
+	}
+	info.EncryptedData = encrypted
+	if content, err = asn1.Marshal(info); err != nil {
+		return
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: EncryptedKeyPEMType, Bytes: content}), nil
+}
+
+// decryptPrivateKey decodes encrypted PKCS#8 to PEM of plain PKCS#8, only PBES2 with PBKDF2 and AES-CBC supported
+func decryptPrivateKey(data []byte, passphrase string) (keyPEM []byte, err error) {
+	var block, _ = pem.Decode(data)
+	if nil == block || EncryptedKeyPEMType != block.Type {
+		return nil, errors.New("no encrypted private key")
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption %s, only PBES2 supported", info.Algorithm.Algorithm)
+	}
+	var parameters pbes2Parameters
+	if _, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &parameters); err != nil {
+		return
+	}
+	if !parameters.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s, only PBKDF2 supported", parameters.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Parameters
+	if _, err = asn1.Unmarshal(parameters.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return
+	}
+	var digest func() hash.Hash
+	switch {
+	case 0 == len(kdf.PRF.Algorithm) || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		digest = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		digest = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PRF %s of PBKDF2", kdf.PRF.Algorithm)
+	}
+	var keyLength int
+	switch {
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %s, only AES-CBC supported", parameters.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err = asn1.Unmarshal(parameters.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return
+	}
+	if aes.BlockSize != len(iv) || 0 == len(info.EncryptedData) || 0 != len(info.EncryptedData)%aes.BlockSize {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	var decrypter cipher.Block
+	if decrypter, err = aes.NewCipher(pbkdf2.Key([]byte(passphrase), kdf.Salt, kdf.IterationCount, keyLength, digest)); err != nil {
+		return
+	}
+	var content = make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(decrypter, iv).CryptBlocks(content, info.EncryptedData)
+	var padding = int(content[len(content)-1])
+	if 0 == padding || padding > aes.BlockSize || !bytes.Equal(content[len(content)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("incorrect passphrase")
+	}
+	content = content[:len(content)-padding]
+	if _, err = x509.ParsePKCS8PrivateKey(content); err != nil {
+		return nil, errors.New("incorrect passphrase")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: content}), nil
+}
+
+func isEncryptedKey(data []byte) bool {
+	var block, _ = pem.Decode(data)
+	return nil != block && EncryptedKeyPEMType == block.Type
+}
+
+// loadedCAKeyPassphrase is passphrase of CA key loaded in current run, nil when not loaded yet
+var loadedCAKeyPassphrase *string
+
+// caKeyPassphrase returns passphrase of CA key from environment, the file specified by answer, or input on console
+// with echo disabled. Passphrase is optional when encrypting, and loaded only once, then used by all keys in a run
+func caKeyPassphrase(encrypting bool) (passphrase string, err error) {
+	if nil != loadedCAKeyPassphrase {
+		return *loadedCAKeyPassphrase, nil
+	}
+	if passphrase = os.Getenv(CAKeyPassphraseEnv); "" != passphrase {
+		fmt.Printf("passphrase of CA key loaded from environment %s\n", CAKeyPassphraseEnv)
+	} else if passphrase, err = prompter.InputPassword(AnswerCAKeyPassphraseFile, "passphrase of CA key", encrypting); err != nil {
+		return
+	}
+	loadedCAKeyPassphrase = &passphrase
+	return passphrase, nil
+}
+
+// decryptCAKey decrypts key of CA by passphrase, plain key returned unchanged
+func decryptCAKey(data []byte) (keyPEM []byte, err error) {
+	if !isEncryptedKey(data) {
+		return data, nil
+	}
+	var passphrase string
+	if passphrase, err = caKeyPassphrase(false); err != nil || "" == passphrase {
+		var reason = "no passphrase"
+		if err != nil {
+			reason = err.Error()
+		}
+		return nil, fmt.Errorf("key encrypted, specify passphrase by environment %s or %s: %s",
+			CAKeyPassphraseEnv, AnswerCAKeyPassphraseFile, reason)
+	}
+	return decryptPrivateKey(data, passphrase)
+}
+
+// writePrivateKey writes key readable only by service user, file never exposed since created with 0600
+func writePrivateKey(session *SessionInfo, keyFile string, keyPEM []byte) (err error) {
+	if host.Exists(keyFile) {
+		//mode of file replaced applied to the new one before renamed, restrict it first
+		if err = host.Chmod(keyFile, KeyFilePerm); err != nil {
+			return
+		}
+	}
+	if err = host.WriteFile(keyFile, keyPEM, KeyFilePerm); err != nil {
+		return
+	}
+	return updateAccess(session, keyFile)
+}
+
+// protectedCAKey encrypts key of CA when passphrase available
+func protectedCAKey(keyPEM []byte) (data []byte, err error) {
+	if isEncryptedKey(keyPEM) {
+		return keyPEM, nil
+	}
+	var passphrase string
+	if passphrase, err = caKeyPassphrase(true); err != nil {
+		return
+	} else if "" == passphrase {
+		return keyPEM, nil
+	}
+	if data, err = encryptPrivateKey(keyPEM, passphrase); err != nil {
+		err = fmt.Errorf("encrypt key of CA fail: %s", err.Error())
+		return
+	}
+	return data, nil
+}
+
+// signingKeyFile returns key of CA used for signing, which specified by answer when kept offline
+func signingKeyFile(session *SessionInfo) (keyFile string, err error) {
+	if !session.CAKeyOffline {
+		return session.CAKeyPath, nil
+	}
+	if keyFile = optionalAnswer(AnswerCAKeyFile, ""); "" == keyFile {
+		err = fmt.Errorf("key of CA kept offline, %s required for signing", AnswerCAKeyFile)
+		return
+	}
+	return keyFile, nil
+}
+
+// saveCertificateAuthority writes CA with its issuers into cert path of project, and key into the same path unless kept offline.
+// Key generated in offline mode written to the new path specified by answer, such as removable media, instead of the host
+func saveCertificateAuthority(session *SessionInfo, authority *CertificateAuthority, keyPEM []byte, generated bool) (err error) {
+	var certPath = filepath.Join(session.ProjectPath, CertificatePathName)
+	if err = ensurePath(certPath, "cert install", session.UID, session.GID); err != nil {
+		return
+	}
+	var certFile = filepath.Join(certPath, fmt.Sprintf("%s_ca%s", ProjectName, CertFileSuffix))
+	var keyFile = filepath.Join(certPath, fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix))
+	//CA and its issuers, root at last
+	var certificates = append([]*x509.Certificate{}, authority.Chain...)
+	if nil != authority.Root {
+		certificates = append(certificates, authority.Root)
+	}
+	if err = host.WriteFile(certFile, encodeCertificates(certificates), DefaultFilePerm); err != nil {
+		return
+	}
+	if err = updateAccess(session, certFile); err != nil {
+		return
+	}
+	fmt.Printf("CA '%s' installed, serial %X, expire at %s\n", certFile, authority.Certificate.SerialNumber,
+		authority.Certificate.NotAfter.Format("2006-01-02"))
+	session.CACertPath = certFile
+	session.CAKeyPath = ""
+	if nil == keyPEM {
+		fmt.Println("key of CA not imported, certificates of modules must be imported")
+		return nil
+	}
+	if keyPEM, err = protectedCAKey(keyPEM); err != nil {
+		return
+	}
+	if !session.CAKeyOffline {
+		if err = writePrivateKey(session, keyFile, keyPEM); err != nil {
+			return
+		}
+		session.CAKeyPath = keyFile
+		fmt.Printf("key of CA '%s' installed\n", keyFile)
+		return nil
+	}
+	if !generated {
+		fmt.Println("key of CA kept offline, not installed")
+		return nil
+	}
+	var offlineFile = optionalAnswer(AnswerCAKeyFile, "")
+	if "" == offlineFile {
+		return fmt.Errorf("%s required for key generated in offline mode", AnswerCAKeyFile)
+	}
+	if host.Exists(offlineFile) {
+		return fmt.Errorf("'%s' already exists, specify a new path for key generated", offlineFile)
+	}
+	//never leave a copy in backups of host, and owned by operator instead of service user
+	backups.Exclude(offlineFile)
+	if err = host.WriteFile(offlineFile, keyPEM, KeyFilePerm); err != nil {
+		return
+	}
+	fmt.Printf("key of CA written to '%s', keep it offline and specify it by %s when signing certificates\n",
+		offlineFile, AnswerCAKeyFile)
+	return nil
+}
+
+// protectCAKey removes key of CA not used any more, restricts mode of key installed by earlier version,
+// and encrypts plain key when passphrase specified
+func protectCAKey(session *SessionInfo) (err error) {
+	var keyFile = filepath.Join(filepath.Dir(session.CACertPath), fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix))
+	if "" == session.CAKeyPath {
+		if !host.Exists(keyFile) {
+			return nil
+		}
+		if session.CAKeyOffline {
+			//project path excluded from backups, so never remove the only copy
+			if err = moveCAKeyOffline(keyFile); err != nil {
+				return
+			}
+		}
+		if err = host.Remove(keyFile); err != nil {
+			return
+		}
+		fmt.Printf("key of CA '%s' removed from host\n", keyFile)
+		return nil
+	}
+	var info os.FileInfo
+	if info, err = os.Stat(session.CAKeyPath); err != nil {
+		//generated in dry run
+		return nil
+	}
+	var keyPEM []byte
+	if keyPEM, err = host.ReadFile(session.CAKeyPath); err != nil {
+		return
+	}
+	var protected []byte
+	if protected, err = protectedCAKey(keyPEM); err != nil {
+		return
+	}
+	if !bytes.Equal(protected, keyPEM) {
+		if err = writePrivateKey(session, session.CAKeyPath, protected); err != nil {
+			return
+		}
+		fmt.Printf("key of CA '%s' encrypted\n", session.CAKeyPath)
+	} else if KeyFilePerm != info.Mode().Perm() {
+		if err = host.Chmod(session.CAKeyPath, KeyFilePerm); err != nil {
+			return
+		}
+		fmt.Printf("mode of CA key '%s' changed from %04o to %04o\n", session.CAKeyPath, info.Mode().Perm(), KeyFilePerm)
+	}
+	return nil
+}
+
+// moveCAKeyOffline writes key of CA installed to the path specified by answer before removed from host,
+// refused when path not specified or holds a different key
+func moveCAKeyOffline(keyFile string) (err error) {
+	var offlineFile = optionalAnswer(AnswerCAKeyFile, "")
+	if "" == offlineFile {
+		return fmt.Errorf("key of CA '%s' installed, %s required to keep it offline before removed from host",
+			keyFile, AnswerCAKeyFile)
+	}
+	var keyPEM []byte
+	if keyPEM, err = host.ReadFile(keyFile); err != nil {
+		return
+	}
+	if host.Exists(offlineFile) {
+		var current []byte
+		if current, err = host.ReadFile(offlineFile); err != nil {
+			return
+		}
+		if !bytes.Equal(current, keyPEM) {
+			return fmt.Errorf("'%s' already exists and differs from key of CA '%s', keep a copy of the installed one and remove it manually",
+				offlineFile, keyFile)
+		}
+		return nil
+	}
+	backups.Exclude(offlineFile)
+	if err = host.WriteFile(offlineFile, keyPEM, KeyFilePerm); err != nil {
+		return
+	}
+	fmt.Printf("key of CA '%s' written to '%s', keep it offline and specify it by %s when signing certificates\n",
+		keyFile, offlineFile, AnswerCAKeyFile)
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// encryptTestKey encrypts key like openssl with PRF and cipher specified, PRF omitted when nil.
+// Content padded by pad, PKCS#7 used when nil
+func encryptTestKey(t *testing.T, keyPEM []byte, passphrase string, prf asn1.ObjectIdentifier, digest func() hash.Hash,
+	scheme asn1.ObjectIdentifier, keyLength int, pad func([]byte) []byte) []byte {
+	const (
+		Iterations = 2048
+	)
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nil == pad {
+		var padding = aes.BlockSize - len(content)%aes.BlockSize
+		content = append(content, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	} else {
+		content = pad(content)
+	}
+	var salt = make([]byte, KeyDerivationSaltSize)
+	var iv = make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+	encrypter, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, Iterations, keyLength, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted = make([]byte, len(content))
+	cipher.NewCBCEncrypter(encrypter, iv).CryptBlocks(encrypted, content)
+	var kdf = pbkdf2Parameters{Salt: salt, IterationCount: Iterations}
+	if nil != prf {
+		kdf.PRF = pkix.AlgorithmIdentifier{Algorithm: prf, Parameters: asn1.NullRawValue}
+	}
+	var parameters pbes2Parameters
+	parameters.KeyDerivationFunc.Algorithm = oidPBKDF2
+	if parameters.KeyDerivationFunc.Parameters.FullBytes, err = asn1.Marshal(kdf); err != nil {
+		t.Fatal(err)
+	}
+	parameters.EncryptionScheme.Algorithm = scheme
+	if parameters.EncryptionScheme.Parameters.FullBytes, err = asn1.Marshal(iv); err != nil {
+		t.Fatal(err)
+	}
+	var info = encryptedPrivateKeyInfo{EncryptedData: encrypted}
+	info.Algorithm.Algorithm = oidPBES2
+	if info.Algorithm.Parameters.FullBytes, err = asn1.Marshal(parameters); err != nil {
+		t.Fatal(err)
+	}
+	data, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: EncryptedKeyPEMType, Bytes: data})
+}
+
+// sameTestKey checks whether two PEM encoded keys are identical
+func sameTestKey(t *testing.T, expected, actual []byte) bool {
+	origin, err := parsePrivateKey(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := parsePrivateKey(actual)
+	if err != nil {
+		t.Fatalf("parse decrypted key fail: %s", err.Error())
+	}
+	return origin.Public().(interface{ Equal(x crypto.PublicKey) bool }).Equal(decrypted.Public())
+}
+
+func TestEncryptPrivateKey(t *testing.T) {
+	const (
+		passphrase = "correct horse battery staple"
+	)
+	for _, keyType := range []string{KeyTypeRSA3072, KeyTypeECDSAP256, KeyTypeEd25519} {
+		t.Run(keyType, func(t *testing.T) {
+			var _, keyPEM = newTestAuthority(t, keyType, 30)
+			encrypted, err := encryptPrivateKey(keyPEM, passphrase)
+			if err != nil {
+				t.Fatalf("encrypt fail: %s", err.Error())
+			}
+			if !isEncryptedKey(encrypted) {
+				t.Fatal("key not encrypted")
+			}
+			if bytes.Contains(encrypted, keyPEM) {
+				t.Fatal("plain key included")
+			}
+			decrypted, err := decryptPrivateKey(encrypted, passphrase)
+			if err != nil {
+				t.Fatalf("decrypt fail: %s", err.Error())
+			}
+			if !sameTestKey(t, keyPEM, decrypted) {
+				t.Fatal("decrypted key mismatch")
+			}
+			if _, err = decryptPrivateKey(encrypted, passphrase+"!"); nil == err || "incorrect passphrase" != err.Error() {
+				t.Fatalf("expect incorrect passphrase, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptPrivateKey(t *testing.T) {
+	const (
+		passphrase = "nano"
+	)
+	var _, keyPEM = newTestAuthority(t, KeyTypeECDSAP256, 30)
+	var badPadding = func(value byte) func([]byte) []byte {
+		return func(content []byte) []byte {
+			var padding = aes.BlockSize - len(content)%aes.BlockSize
+			content = append(content, bytes.Repeat([]byte{byte(padding)}, padding)...)
+			content[len(content)-1] = value
+			return content
+		}
+	}
+	var cases = []struct {
+		name      string
+		prf       asn1.ObjectIdentifier
+		digest    func() hash.Hash
+		scheme    asn1.ObjectIdentifier
+		keyLength int
+		pad       func([]byte) []byte
+		expected  string
+	}{
+		{"AES-128 with SHA-256", oidHMACWithSHA256, sha256.New, oidAES128CBC, 16, nil, ""},
+		{"AES-192 with SHA-256", oidHMACWithSHA256, sha256.New, oidAES192CBC, 24, nil, ""},
+		{"AES-256 with SHA-1", oidHMACWithSHA1, sha1.New, oidAES256CBC, 32, nil, ""},
+		{"PRF omitted as SHA-1", nil, sha1.New, oidAES128CBC, 16, nil, ""},
+		{"PRF omitted not SHA-256", nil, sha256.New, oidAES256CBC, 32, nil, "incorrect passphrase"},
+		{"zero padding", oidHMACWithSHA256, sha256.New, oidAES256CBC, 32, badPadding(0), "incorrect passphrase"},
+		{"padding over block", oidHMACWithSHA256, sha256.New, oidAES256CBC, 32, badPadding(aes.BlockSize + 1),
+			"incorrect passphrase"},
+		{"unsupported PRF", oidPBKDF2, sha256.New, oidAES256CBC, 32, nil,
+			fmt.Sprintf("unsupported PRF %s of PBKDF2", oidPBKDF2)},
+		{"unsupported cipher", oidHMACWithSHA256, sha256.New, oidPBES2, 32, nil,
+			fmt.Sprintf("unsupported cipher %s, only AES-CBC supported", oidPBES2)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var encrypted = encryptTestKey(t, keyPEM, passphrase, c.prf, c.digest, c.scheme, c.keyLength, c.pad)
+			decrypted, err := decryptPrivateKey(encrypted, passphrase)
+			if "" != c.expected {
+				if nil == err || c.expected != err.Error() {
+					t.Fatalf("expect error '%s', but got %v", c.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt fail: %s", err.Error())
+			}
+			if !sameTestKey(t, keyPEM, decrypted) {
+				t.Fatal("decrypted key mismatch")
+			}
+		})
+	}
+	t.Run("plain key", func(t *testing.T) {
+		if _, err := decryptPrivateKey(keyPEM, passphrase); nil == err {
+			t.Fatal("plain key decrypted")
+		}
+	})
+}
+
+func TestWritePrivateKey(t *testing.T) {
+	var projectPath = useTempProject(t)
+	var session = &SessionInfo{ProjectPath: projectPath, UID: os.Getuid(), GID: os.Getgid()}
+	var keyFile = writeTestFile(t, projectPath, "module.key.pem", []byte("previous"))
+	if err := os.Chmod(keyFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePrivateKey(session, keyFile, []byte("key")); err != nil {
+		t.Fatalf("write key fail: %s", err.Error())
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyFilePerm != info.Mode().Perm() {
+		t.Fatalf("expect mode %04o, but got %04o", KeyFilePerm, info.Mode().Perm())
+	}
+}
+
+func TestProtectCAKeyOffline(t *testing.T) {
+	var keyName = fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix)
+	var cases = []struct {
+		name       string
+		offline    string
+		existing   []byte
+		expectFail bool
+	}{
+		{"move to offline path", "removable/ca.key.pem", nil, false},
+		{"offline path not specified", "", nil, true},
+		{"same key in offline path", "removable/ca.key.pem", []byte("installed key"), false},
+		{"different key in offline path", "removable/ca.key.pem", []byte("other key"), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var projectPath = useTempProject(t)
+			var certPath = filepath.Join(projectPath, CertificatePathName)
+			if err := os.MkdirAll(certPath, 0700); err != nil {
+				t.Fatal(err)
+			}
+			var keyFile = writeTestFile(t, certPath, keyName, []byte("installed key"))
+			var offlineFile = c.offline
+			if "" != offlineFile {
+				offlineFile = filepath.Join(t.TempDir(), offlineFile)
+				if err := os.MkdirAll(filepath.Dir(offlineFile), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if nil != c.existing {
+					writeTestFile(t, filepath.Dir(offlineFile), filepath.Base(offlineFile), c.existing)
+				}
+			}
+			useAnswers(t, map[string]string{AnswerCAKeyFile: offlineFile})
+			var session = &SessionInfo{ProjectPath: projectPath, CAKeyOffline: true,
+				CACertPath: filepath.Join(certPath, fmt.Sprintf("%s_ca%s", ProjectName, CertFileSuffix))}
+			var err = protectCAKey(session)
+			if c.expectFail {
+				if nil == err {
+					t.Fatal("key removed without offline copy")
+				}
+				if !host.Exists(keyFile) {
+					t.Fatal("installed key removed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("protect key fail: %s", err.Error())
+			}
+			if host.Exists(keyFile) {
+				t.Fatal("installed key not removed")
+			}
+			content, err := ioutil.ReadFile(offlineFile)
+			if err != nil {
+				t.Fatalf("read offline key fail: %s", err.Error())
+			}
+			if "installed key" != string(content) {
+				t.Fatalf("unexpected offline key: %s", content)
+			}
+			if nil == c.existing {
+				if info, _ := os.Stat(offlineFile); KeyFilePerm != info.Mode().Perm() {
+					t.Fatalf("expect mode %04o, but got %04o", KeyFilePerm, info.Mode().Perm())
+				}
+			}
+		})
+	}
+}
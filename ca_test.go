@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAuthority generates a root CA with key type and lifetime specified
+func newTestAuthority(t *testing.T, keyType string, lifetimeDays int) (authority *CertificateAuthority, keyPEM []byte) {
+	var err error
+	if authority, keyPEM, err = newCertificateAuthority(CertificateProfile{KeyType: keyType, CALifetimeDays: lifetimeDays}); err != nil {
+		t.Fatalf("generate CA fail: %s", err.Error())
+	}
+	return authority, keyPEM
+}
+
+// parseTestCertificate parses the first certificate in PEM data
+func parseTestCertificate(t *testing.T, data []byte) *x509.Certificate {
+	certificates, err := parseCertificateChain(data)
+	if err != nil {
+		t.Fatalf("parse certificate fail: %s", err.Error())
+	}
+	return certificates[0]
+}
+
+func TestCertificateCandidates(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("hostname not available: %s", err.Error())
+	}
+	var session = &SessionInfo{LocalAddress: "192.168.1.10", APIAddress: "192.168.1.20",
+		CertProfile: CertificateProfile{Names: []string{"nano.example.com"}}}
+	var cases = []struct {
+		target   ModuleCertificate
+		expected []string
+	}{
+		{ImageCertificate, []string{"192.168.1.10", "192.168.1.20", hostname, "nano.example.com"}},
+		{APICertificate, []string{"192.168.1.10", "192.168.1.20", hostname, "nano.example.com"}},
+		{PortalCertificate, []string{"192.168.1.10", hostname, "nano.example.com"}},
+	}
+	for _, c := range cases {
+		if candidates := certificateCandidates(session, c.target); !reflect.DeepEqual(c.expected, candidates) {
+			t.Fatalf("%s certificate expect %v, but got %v", c.target.Name, c.expected, candidates)
+		}
+	}
+}
+
+func TestIssue(t *testing.T) {
+	var authority, _ = newTestAuthority(t, KeyTypeECDSAP256, DefaultCALifetimeDays)
+	var roots = x509.NewCertPool()
+	roots.AddCert(authority.Certificate)
+	var dnsNames = []string{"nano.example.com", "core.example.com"}
+	var addresses = []net.IP{net.ParseIP("192.168.1.10").To4(), net.ParseIP("fd00::10")}
+	var cases = []struct {
+		keyType string
+		usage   x509.KeyUsage
+		public  interface{}
+	}{
+		{KeyTypeRSA3072, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, &rsa.PublicKey{}},
+		{KeyTypeRSA4096, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, &rsa.PublicKey{}},
+		{KeyTypeECDSAP256, x509.KeyUsageDigitalSignature, &ecdsa.PublicKey{}},
+		{KeyTypeECDSAP384, x509.KeyUsageDigitalSignature, &ecdsa.PublicKey{}},
+		{KeyTypeEd25519, x509.KeyUsageDigitalSignature, ed25519.PublicKey{}},
+	}
+	for _, c := range cases {
+		t.Run(c.keyType, func(t *testing.T) {
+			var profile = CertificateProfile{KeyType: c.keyType, CertLifetimeDays: DefaultCertLifetimeDays}
+			certPEM, keyPEM, err := authority.Issue("API", dnsNames, addresses, profile)
+			if err != nil {
+				t.Fatalf("issue fail: %s", err.Error())
+			}
+			var certificate = parseTestCertificate(t, certPEM)
+			if bits := certificate.SerialNumber.BitLen(); bits > SerialNumberBits || bits <= SerialNumberBits/2 {
+				t.Fatalf("serial number of %d bits", bits)
+			}
+			if c.usage != certificate.KeyUsage {
+				t.Fatalf("expect key usage %d, but got %d", c.usage, certificate.KeyUsage)
+			}
+			if reflect.TypeOf(c.public) != reflect.TypeOf(certificate.PublicKey) {
+				t.Fatalf("expect public key %T, but got %T", c.public, certificate.PublicKey)
+			}
+			if !reflect.DeepEqual(dnsNames, certificate.DNSNames) {
+				t.Fatalf("expect DNS names %v, but got %v", dnsNames, certificate.DNSNames)
+			}
+			if len(addresses) != len(certificate.IPAddresses) {
+				t.Fatalf("expect addresses %v, but got %v", addresses, certificate.IPAddresses)
+			}
+			for index, address := range addresses {
+				if !address.Equal(certificate.IPAddresses[index]) {
+					t.Fatalf("expect addresses %v, but got %v", addresses, certificate.IPAddresses)
+				}
+			}
+			if ProjectName+" API" != certificate.Subject.CommonName {
+				t.Fatalf("unexpected common name '%s'", certificate.Subject.CommonName)
+			}
+			if _, err = certificate.Verify(x509.VerifyOptions{Roots: roots, DNSName: dnsNames[1],
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err != nil {
+				t.Fatalf("verify fail: %s", err.Error())
+			}
+			key, err := parsePrivateKey(keyPEM)
+			if err != nil {
+				t.Fatalf("parse key fail: %s", err.Error())
+			}
+			if err = matchPublicKey(certificate, key); err != nil {
+				t.Fatalf("key not match: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestIssueLifetime(t *testing.T) {
+	var cases = []struct {
+		name     string
+		caDays   int
+		certDays int
+		capByCA  bool
+	}{
+		{"within CA", DefaultCALifetimeDays, 30, false},
+		{"capped at CA expiry", 10, DefaultCertLifetimeDays, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var authority, _ = newTestAuthority(t, KeyTypeECDSAP256, c.caDays)
+			var profile = CertificateProfile{KeyType: KeyTypeECDSAP256, CertLifetimeDays: c.certDays}
+			certPEM, _, err := authority.Issue("Portal", nil, []net.IP{net.ParseIP("192.168.1.10")}, profile)
+			if err != nil {
+				t.Fatalf("issue fail: %s", err.Error())
+			}
+			var notAfter = parseTestCertificate(t, certPEM).NotAfter
+			var expected = time.Now().AddDate(0, 0, c.certDays)
+			if c.capByCA {
+				expected = authority.Certificate.NotAfter
+			}
+			if notAfter.Sub(expected) > time.Minute || expected.Sub(notAfter) > time.Minute {
+				t.Fatalf("expect expire at %s, but got %s", expected, notAfter)
+			}
+			if notAfter.After(authority.Certificate.NotAfter) {
+				t.Fatalf("certificate expires at %s, after CA at %s", notAfter, authority.Certificate.NotAfter)
+			}
+		})
+	}
+}
+
+func TestIssueWithoutKey(t *testing.T) {
+	var authority, _ = newTestAuthority(t, KeyTypeECDSAP256, DefaultCALifetimeDays)
+	authority.signer = nil
+	if _, _, err := authority.Issue("API", nil, nil, CertificateProfile{KeyType: KeyTypeECDSAP256}); nil == err {
+		t.Fatal("issued without key of CA")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkcs8 = func(key crypto.Signer) []byte {
+		data, marshalError := marshalPrivateKey(key)
+		if marshalError != nil {
+			t.Fatal(marshalError)
+		}
+		return data
+	}
+	var cases = []struct {
+		name string
+		data []byte
+		key  crypto.Signer
+	}{
+		{"PKCS#1 RSA", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), rsaKey},
+		{"SEC 1 ECDSA", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), ecKey},
+		{"PKCS#8 RSA", pkcs8(rsaKey), rsaKey},
+		{"PKCS#8 ECDSA", pkcs8(ecKey), ecKey},
+		{"PKCS#8 Ed25519", pkcs8(edKey), edKey},
+		{"unsupported type", pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte{0}}), nil},
+		{"corrupted PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("corrupted")}), nil},
+		{"not PEM", []byte("private key"), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, err := parsePrivateKey(c.data)
+			if nil == c.key {
+				if nil == err {
+					t.Fatalf("expect fail, but got %T", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse fail: %s", err.Error())
+			}
+			if !reflect.DeepEqual(c.key.Public(), key.Public()) {
+				t.Fatal("public key mismatched")
+			}
+		})
+	}
+}
+
+func TestLoadCertificateAuthority(t *testing.T) {
+	var authority, keyPEM = newTestAuthority(t, KeyTypeECDSAP256, DefaultCALifetimeDays)
+	var _, otherKeyPEM = newTestAuthority(t, KeyTypeECDSAP256, DefaultCALifetimeDays)
+	var path = t.TempDir()
+	var write = func(name string, data []byte) string {
+		var filename = filepath.Join(path, name)
+		if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	var certFile = write("ca.crt.pem", authority.CertPEM)
+	var cases = []struct {
+		name    string
+		keyFile string
+		failure string
+	}{
+		{"matched key", write("ca.key.pem", keyPEM), ""},
+		{"without key", "", ""},
+		{"mismatched key", write("other.key.pem", otherKeyPEM), "not match certificate"},
+		{"invalid key", write("invalid.key.pem", []byte("invalid")), "load CA key"},
+		{"missing key", filepath.Join(path, "missing.key.pem"), "no such file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			loaded, err := loadCertificateAuthority(certFile, c.keyFile)
+			if "" != c.failure {
+				if nil == err || !strings.Contains(err.Error(), c.failure) {
+					t.Fatalf("expect error contains '%s', but got %v", c.failure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load fail: %s", err.Error())
+			}
+			if !loaded.Certificate.Equal(authority.Certificate) || nil == loaded.Root {
+				t.Fatal("certificate of CA not loaded as root")
+			}
+			if ("" != c.keyFile) != (nil != loaded.signer) {
+				t.Fatalf("key loaded %t, expect %t", nil != loaded.signer, "" != c.keyFile)
+			}
+		})
+	}
+}
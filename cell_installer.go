@@ -1,19 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/project-nano/framework"
 	"github.com/vishvananda/netlink"
-	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
-	"strings"
+	"strconv"
+	"time"
 )
 
 const (
@@ -23,6 +21,15 @@ const (
 	DHCPServerPort     = 67
 )
 
+const (
+	PolkitAccessFile    = "/etc/polkit-1/localauthority/50-local.d/50-org.libvirt-group-access.pkla"
+	QEMUConfigPath      = "/etc/libvirt/qemu.conf"
+	KVMDevice           = "/dev/kvm"
+	LibvirtGroupName    = "libvirt"
+	NetworkScriptsPath  = "/etc/sysconfig/network-scripts"
+	NetworkScriptPrefix = "ifcfg"
+)
+
 func CellInstaller(session *SessionInfo) (ranges []PortRange, err error){
 	const (
 		ModulePathName    = "cell"
@@ -61,41 +68,37 @@ func CellInstaller(session *SessionInfo) (ranges []PortRange, err error){
 		return
 	}
 
-	ranges = []PortRange{
+	fmt.Println("cell module installed")
+	return cellPortRanges(), nil
+}
+
+func cellPortRanges() []PortRange {
+	return []PortRange{
 		{MonitorPortBegin, MonitorPortEnd, "tcp"},
 		{InitiatorMagicPort, InitiatorMagicPort, "tcp"},
 		{DHCPServerPort, DHCPServerPort, "udp"},
 	}
-	fmt.Println("cell module installed")
-	return ranges, nil
 }
 
 func installCellDependencyPackages() (err error){
-	const (
-		PackagePath = "rpms"
-		CellPath = "cell"
-	)
-	var packagePath = filepath.Join(PackagePath, CellPath)
+	var packagePath = dependencyPackagePath("cell")
 	if _, err = os.Stat(packagePath); os.IsNotExist(err){
 		err = fmt.Errorf("can not find dependency package path %s", packagePath)
 		return
 	}
 	fmt.Println("installing cell dependency packages...")
-	var cmd = exec.Command("rpm", "-i", "--force", fmt.Sprintf("%s/*", packagePath))
-	if err = executeWithOutput(cmd);err != nil{
+	if err = host.Execute("rpm", "-i", "--force", fmt.Sprintf("%s/*", packagePath));err != nil{
 		fmt.Printf("install pacakge fail: %s\n", err.Error())
 		fmt.Println("try installing from online reciprocity...")
 		{
 			//install EPEL first
-			epel := exec.Command("yum", "install", "-y", "epel-release")
-			if err = epel.Run(); nil != err{
+			if err = host.Execute("yum", "install", "-y", "epel-release"); nil != err{
 				fmt.Printf("install EPEL fail: %s\n", err.Error())
 				return
 			}
 		}
-		cmd = exec.Command("yum", "install", "-y", "qemu-system-x86", "bridge-utils","libvirt",
-			"seabios", "genisoimage", "nfs-utils", "policycoreutils-python")
-		if err = executeWithOutput(cmd);err != nil {
+		if err = host.Execute("yum", "install", "-y", "qemu-system-x86", "bridge-utils","libvirt",
+			"seabios", "genisoimage", "nfs-utils", "policycoreutils-python");err != nil {
 			fmt.Printf("install online reciprocity fail: %s\n", err.Error())
 			return
 		}
@@ -104,22 +107,46 @@ func installCellDependencyPackages() (err error){
 	return nil
 }
 
+// dependencyPackagePath returns path of rpm packages required by module in payload
+func dependencyPackagePath(moduleName string) string {
+	const (
+		PackagePath = "rpms"
+	)
+	return filepath.Join(PackagePath, moduleName)
+}
+
 func enableLibvirtService(session *SessionInfo) (err error){
+	var configChanged bool
+	if configChanged, err = enableQEMUAuthority(session); err != nil{
+		return
+	}
 	if err = configureLibvirtGroup(session);err != nil{
 		return
 	}
+	return startLibvirtService(configChanged)
+}
+
+// startLibvirtService enables libvirtd, restarts it only when running with configure changed
+func startLibvirtService(configChanged bool) (err error){
 	{
-		var cmd = exec.Command("systemctl", "enable", "libvirtd")
-		if err = executeWithOutput(cmd);err != nil{
+		if err = services.Enable("libvirtd");err != nil{
 			fmt.Printf("enable libvirt fail: %s\n", err.Error())
 			return
 		}else{
 			fmt.Println("libvirt enabled")
 		}
 	}
-	{
-		var cmd = exec.Command("systemctl", "start", "libvirtd")
-		if err = executeWithOutput(cmd);err != nil{
+	if active, _ := services.IsActive("libvirtd"); active{
+		if !configChanged{
+			fmt.Println("libvirt already started, configure not changed")
+		}else if err = services.Restart("libvirtd");err != nil{
+			fmt.Printf("restart libvirt fail: %s\n", err.Error())
+			return
+		}else{
+			fmt.Println("libvirt restarted for configure changed")
+		}
+	}else{
+		if err = services.Start("libvirtd");err != nil{
 			fmt.Printf("start libvirt fail: %s\n", err.Error())
 			return
 		}else{
@@ -140,7 +167,7 @@ func writeCellDomainConfig(session *SessionInfo, configPath string) (err error){
 	}
 
 	var configFile = filepath.Join(configPath, DomainConfigFileName)
-	if _, err = os.Stat(configFile); os.IsNotExist(err) {
+	if !host.Exists(configFile) {
 		var config = DomainConfig{Domain:session.Domain, GroupAddress:session.GroupAddress, GroupPort:session.GroupPort}
 		//write
 		var data []byte
@@ -148,7 +175,7 @@ func writeCellDomainConfig(session *SessionInfo, configPath string) (err error){
 		if err != nil {
 			return
 		}
-		if err = ioutil.WriteFile(configFile, data, DefaultFilePerm); err != nil {
+		if err = host.WriteFile(configFile, data, DefaultFilePerm); err != nil {
 			return
 		}
 		fmt.Printf("domain configure '%s' generated\n", configFile)
@@ -157,23 +184,19 @@ func writeCellDomainConfig(session *SessionInfo, configPath string) (err error){
 }
 
 func installPolkitAccess(session *SessionInfo) (err error){
-	const (
-		FileName = "/etc/polkit-1/localauthority/50-local.d/50-org.libvirt-group-access.pkla"
-	)
-	if _, err = os.Stat(FileName);os.IsNotExist(err){
+	if !host.Exists(PolkitAccessFile){
 		//need install
-		var file *os.File
-		file, err = os.Create(FileName)
-		if err != nil{
+		var content bytes.Buffer
+		fmt.Fprintln(&content, "[libvirt group Management Access]")
+		fmt.Fprintln(&content, "Identity=unix-group:libvirt")
+		fmt.Fprintln(&content, "Action=org.libvirt.unix.manage")
+		fmt.Fprintln(&content, "ResultAny=yes")
+		fmt.Fprintln(&content, "ResultInactive=yes")
+		fmt.Fprintln(&content, "ResultActive=yes")
+		if err = host.WriteFile(PolkitAccessFile, content.Bytes(), 0644); err != nil{
 			return err
 		}
-		fmt.Fprintln(file, "[libvirt group Management Access]")
-		fmt.Fprintln(file, "Identity=unix-group:libvirt")
-		fmt.Fprintln(file, "Action=org.libvirt.unix.manage")
-		fmt.Fprintln(file, "ResultAny=yes")
-		fmt.Fprintln(file, "ResultInactive=yes")
-		fmt.Fprintln(file, "ResultActive=yes")
-		file.Close()
+		session.PolkitAccessCreated = true
 		fmt.Println("polkit access installed")
 
 	}else{
@@ -184,17 +207,16 @@ func installPolkitAccess(session *SessionInfo) (err error){
 
 func configureLibvirtGroup(session *SessionInfo) (err error) {
 	const (
-		GroupName = "libvirt"
+		GroupName = LibvirtGroupName
 	)
-	if err = enableQEMUAuthority(session.User, session.UserGroup); err != nil{
-		return err
-	}
 	if _, err = user.LookupGroup(GroupName);err != nil{
-		var cmd = exec.Command("groupadd","libvirt")
-		if err = executeWithOutput(cmd);err != nil{
+		if err = host.Execute("groupadd","libvirt");err != nil{
 			fmt.Printf("create group fail: %s\n", err.Error())
 			return
 		}else{
+			registerUndo(fmt.Sprintf("create group %s", GroupName), func(operator HostOperator) error {
+				return operator.Execute("groupdel", GroupName)
+			})
 			fmt.Printf("new group %s created", GroupName)
 		}
 	}else{
@@ -218,236 +240,280 @@ func configureLibvirtGroup(session *SessionInfo) (err error) {
 
 	for _, groupID := range groups{
 		if groupID == libvirtGroup.Gid{
-			fmt.Printf("user %s already in group %s", session.User, GroupName)
+			fmt.Printf("user %s already in group %s\n", session.User, GroupName)
 			return nil
 		}
 	}
 	//need add
-	var cmd = exec.Command("usermod","-a", "-G", GroupName, session.User)
-	if err = executeWithOutput(cmd);err != nil{
+	if err = host.Execute("usermod","-a", "-G", GroupName, session.User);err != nil{
 		fmt.Printf("add %s to group %s fail: %s\n", session.User, GroupName, err.Error())
 		return
 	}else{
+		registerUndo(fmt.Sprintf("add user %s to group %s", session.User, GroupName), func(operator HostOperator) error {
+			return operator.Execute("gpasswd", "-d", session.User, GroupName)
+		})
+		session.LibvirtGroupMember = session.User
 		fmt.Printf("user %s added to group %s\n", session.User, GroupName)
 	}
 	return nil
 }
 
-func enableQEMUAuthority(user, group string) (err error){
+// enableQEMUAuthority runs QEMU as user of Nano, original values recorded in session so that reverted when uninstall.
+// Returns true when qemu.conf changed, so that libvirtd restarted to apply
+func enableQEMUAuthority(session *SessionInfo) (changed bool, err error){
 	const (
-		ConfigPath = "/etc/libvirt/qemu.conf"
-		DefaultUser = "#user = \"root\""
-		DefaultGroup = "#group = \"root\""
-		KVMDevice = "/dev/kvm"
+		ConfigPath = QEMUConfigPath
 	)
-	data, err := ioutil.ReadFile(ConfigPath)
-	if err != nil{
-		return err
+	if _, err = os.Stat(KVMDevice); os.IsNotExist(err){
+		err = errors.New("No KVM module available, check Intel VT-x/AMD-v in BIOS to enable virtualization before installing Nano")
+		return
 	}
-	var userString = fmt.Sprintf("user = \"%s\"", user)
-	var groupString = fmt.Sprintf("group = \"%s\"", group)
-	var content = strings.Replace(string(data), DefaultUser, userString, 1)
-	content = strings.Replace(content, DefaultGroup, groupString, 1)
-	if err = ioutil.WriteFile(ConfigPath, []byte(content), DefaultFilePerm);err != nil{
+	data, err := host.ReadFile(ConfigPath)
+	if err != nil{
 		return
 	}
-	fmt.Printf("user %s / group %s updated in %s\n", user, group, ConfigPath)
-	{
-		if _, err = os.Stat(KVMDevice); os.IsNotExist(err){
-			err = errors.New("No KVM module available, check Intel VT-x/AMD-v in BIOS to enable virtualization before installing Nano")
+	var config = parseLibvirtConfig(data)
+	var assignments = []struct{
+		Key   string
+		Value string
+	}{
+		{"user", formatLibvirtString(session.User)},
+		{"group", formatLibvirtString(session.UserGroup)},
+		{"dynamic_ownership", "1"},
+	}
+	session.QEMUConfigOrigin = map[string]string{}
+	for _, assignment := range assignments{
+		var origin, _ = config.Get(assignment.Key)
+		session.QEMUConfigOrigin[assignment.Key] = origin
+		if !config.Set(assignment.Key, assignment.Value){
+			fmt.Printf("%s already set to %s in %s\n", assignment.Key, assignment.Value, ConfigPath)
+			continue
+		}
+		if "" == origin{
+			fmt.Printf("%s set to %s in %s\n", assignment.Key, assignment.Value, ConfigPath)
+		}else{
+			fmt.Printf("%s changed from %s to %s in %s\n", assignment.Key, origin, assignment.Value, ConfigPath)
+		}
+		changed = true
+	}
+	if changed{
+		if err = host.WriteFile(ConfigPath, config.Bytes(), DefaultFilePerm);err != nil{
 			return
 		}
-		var cmd = exec.Command("chown", fmt.Sprintf("%s:%s", user, group), KVMDevice)
-		if err = executeWithOutput(cmd); err != nil{
+		fmt.Printf("%s updated\n", ConfigPath)
+	}
+	if err = installKVMUdevRule(session); err != nil{
+		return
+	}
+	return changed, nil
+}
+
+
+func configureNetworkForCell(session *SessionInfo) (err error) {
+	var names []string
+	if names, err = cellBridgeNames(); err != nil{
+		return
+	}
+	var configurator BridgeConfigurator
+	session.Bridges = nil
+	for index, bridgeName := range names{
+		var first = 0 == index
+		if _, linkError := net.InterfaceByName(bridgeName); nil == linkError{
+			if err = validateBridge(bridgeName, expectedBridgePort(bridgeName, first), first); err != nil{
+				err = fmt.Errorf("invalid bridge %s: %s", bridgeName, err.Error())
+				return
+			}
+			fmt.Printf("bridge %s already exists\n", bridgeName)
+			session.Bridges = append(session.Bridges, CellBridge{Name: bridgeName})
+			continue
+		}
+		if nil == configurator{
+			if configurator, err = chooseBridgeConfigurator(); err != nil{
+				return
+			}
+		}
+		var uplink Uplink
+		if uplink, err = createBridge(configurator, session, bridgeName, first); err != nil{
 			return
 		}
-		fmt.Printf("%s owner changed\n", KVMDevice)
+		session.Bridges = append(session.Bridges, CellBridge{Name: bridgeName, Uplink: &uplink})
+		session.NetworkBackend = configurator.Name()
 	}
 	return nil
 }
 
-
-func configureNetworkForCell() (err error) {
-	if hasDefaultBridge(){
-		fmt.Printf("bridge %s already exists\n", DefaultBridgeName)
-		return nil
+func createBridge(configurator BridgeConfigurator, session *SessionInfo, bridgeName string, first bool) (uplink Uplink, err error) {
+	if uplink, err = chooseUplink(bridgeName, first); err != nil{
+		return
 	}
-	ename, err := framework.SelectEthernetInterface("interface to bridge", true)
+	var confirmed bool
+	confirmed, err = prompter.Confirm(AnswerConfirmBridge, fmt.Sprintf("try link %s to bridge '%s'", uplink.String(), bridgeName))
 	if err != nil{
 		return
 	}
-	fmt.Printf("try link interface '%s' to bridge '%s', input 'yes' to confirm:", ename, DefaultBridgeName)
-	var input string
-	_, err = fmt.Scanln(&input)
-	if err != nil{
+	if !confirmed{
+		err = errors.New("user interrupted")
 		return
 	}
-	if "yes" != input{
-		return errors.New("user interrupted")
+	var zone = session.FirewallZone
+	if "" == zone{
+		zone = DefaultFirewallZone
 	}
-	{
-		//disable & stop network manager
-		var cmd = exec.Command("systemctl", "stop", "NetworkManager")
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("warning: stop networkmanager fail: %s", err.Error())
-		}else{
-			fmt.Println("network manager stopped")
+	//only interface carrying default route expected to move it to bridge
+	var routeInterface, _ = defaultRouteInterface()
+	var applyBridge = func() (err error) {
+		if err = configurator.Link(uplink, bridgeName, zone);err != nil{
+			return
 		}
-		cmd = exec.Command("systemctl", "disable", "NetworkManager")
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("warning: disable networkmanager fail: %s", err.Error())
-		}else{
-			fmt.Println("network manager disabled")
+		if routeInterface == uplink.Carrier{
+			const (
+				RouteTimeout = 30 * time.Second
+			)
+			return waitBridgeRoute(bridgeName, RouteTimeout)
 		}
+		return nil
 	}
-
-	if err = linkBridge(ename, DefaultBridgeName);err != nil{
+	var confirmTimeout time.Duration
+	if confirmTimeout, err = networkConfirmTimeout(); err != nil{
 		return
 	}
-
-	{
-		//restart network
-		var cmd = exec.Command("systemctl", "stop", "network")
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("warning: stop network service fail: %s", err.Error())
-		}else{
-			fmt.Println("network service stopped")
-		}
-		cmd = exec.Command("systemctl", "start", "network")
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("warning: start network service fail: %s", err.Error())
-			return
-		}else{
-			fmt.Println("network service restarted")
-		}
+	if 0 == confirmTimeout{
+		err = applyBridge()
+	}else{
+		err = commitNetworkChange(uplink.Carrier, confirmTimeout, applyBridge)
 	}
 	return
 }
 
-func hasDefaultBridge() bool{
-	list, err := net.Interfaces()
+func linkBridge(uplink Uplink, bridgeName, zone string) (err error){
+	var port = uplink.Port()
+	bridgeConfig, err := generateBridgeConfig(bridgeName, zone)
 	if err != nil{
-		fmt.Printf("fetch interface fail: %s", err.Error())
-		return false
+		return
 	}
-	for _, i := range list{
-		if DefaultBridgeName == i.Name{
-			return true
+	for _, device := range uplink.Devices(){
+		var script = interfaceScriptPath(device)
+		var config InterfaceConfig
+		if config, err = readInterfaceConfig(script); err != nil{
+			return
 		}
+		if device == uplink.Carrier{
+			if err = migrateInterfaceConfig(bridgeName, &config, &bridgeConfig); err != nil{
+				return
+			}
+		}
+		if uplink.CreateBond(){
+			delete(config.Params, "BRIDGE")
+			config.Params["MASTER"] = uplink.Interface
+			config.Params["SLAVE"] = "yes"
+			config.Params["NM_CONTROLLED"] = "no"
+			config.Params["ONBOOT"] = "yes"
+		}else if device != port{
+			//parent of VLAN
+			delete(config.Params, "BRIDGE")
+		}
+		if err = writeInterfaceConfig(config, script); err != nil{
+			return
+		}
+		fmt.Printf("interface script %s updated\n", script)
 	}
-	return false
-}
-
-func linkBridge(interfaceName, bridgeName string) (err error){
-	const (
-		ScriptsPath = "/etc/sysconfig/network-scripts"
-		ScriptPrefix = "ifcfg"
-	)
-	var interfaceScript = filepath.Join(ScriptsPath, fmt.Sprintf("%s-%s", ScriptPrefix, interfaceName))
-	var bridgeScript = filepath.Join(ScriptsPath, fmt.Sprintf("%s-%s", ScriptPrefix, bridgeName))
-	interfaceConfig, err := readInterfaceConfig(interfaceScript)
-	if err != nil{
-		return
+	var generated = []InterfaceConfig{bridgeConfig}
+	if uplink.CreateBond(){
+		generated = append(generated, generateBondConfig(uplink, bridgeName))
 	}
-	bridgeConfig, err := generateBridgeConfig(bridgeName)
-	if err != nil{
-		return
+	if 0 != uplink.VLAN{
+		generated = append(generated, generateVLANConfig(uplink, bridgeName))
 	}
-	err = migrateInterfaceConfig(bridgeName, &interfaceConfig, &bridgeConfig)
-	if err != nil{
-		return
-	}
-	err = writeInterfaceConfig(interfaceConfig, interfaceScript)
-	if err != nil{
-		return
+	for _, config := range generated{
+		var script = interfaceScriptPath(config.Params["DEVICE"])
+		if err = writeInterfaceConfig(config, script); err != nil{
+			return
+		}
+		fmt.Printf("script %s generated\n", script)
 	}
-	fmt.Printf("interface script %s updated\n", interfaceScript)
-	err = writeInterfaceConfig(bridgeConfig, bridgeScript)
-	if err != nil{
-		return
+	if uplink.CreateBond() || 0 != uplink.VLAN{
+		//bond and VLAN created by network service
+		return nil
 	}
-	fmt.Printf("bridge script %s generated\n", bridgeScript)
-	link, err := netlink.LinkByName(interfaceName)
-	if err != nil{
+	var interfaceName = uplink.Interface
+	if _, err = netlink.LinkByName(interfaceName); err != nil{
 		return
 	}
-	if err = netlink.LinkSetDown(link);err != nil{
+	if err = host.SetLinkDown(interfaceName);err != nil{
 		fmt.Printf("warning:set down link fail: %s\n", err.Error())
 	}
-	var bridgeAttrs = netlink.NewLinkAttrs()
-	bridgeAttrs.Name = bridgeName
-	var bridge = &netlink.Bridge{LinkAttrs: bridgeAttrs}
-	if err = netlink.LinkAdd(bridge);err != nil{
+	if err = host.AddBridge(bridgeName);err != nil{
 		return
 	}
 	fmt.Printf("new bridge %s created\n", bridgeName)
-	if err = netlink.LinkSetMaster(link, bridge);err != nil{
+	if err = host.SetLinkMaster(interfaceName, bridgeName);err != nil{
 		return
 	}
 	fmt.Printf("link %s added to bridge %s\n", interfaceName, bridgeName)
-	if err = netlink.LinkSetUp(bridge); err != nil{
+	if err = host.SetLinkUp(bridgeName); err != nil{
 		return
 	}
 	fmt.Printf("bridge %s up\n", bridgeName)
-	if err = netlink.LinkSetUp(link); err != nil{
+	if err = host.SetLinkUp(interfaceName); err != nil{
 		return
 	}
 	fmt.Printf("link %s up\n", interfaceName)
 	return nil
 }
 
-type InterfaceConfig struct {
-	Params map[string]string
+func interfaceScriptPath(interfaceName string) string {
+	return filepath.Join(NetworkScriptsPath, fmt.Sprintf("%s-%s", NetworkScriptPrefix, interfaceName))
 }
 
-func generateBridgeConfig(bridgeName string)(config InterfaceConfig, err error){
+func generateBridgeConfig(bridgeName, zone string)(config InterfaceConfig, err error){
 	config.Params = map[string]string{
 		"NM_CONTROLLED": "no",
 		"DELAY": "0",
 		"TYPE": "Bridge",
 		"ONBOOT": "yes",
-		"ZONE": "public",
+		"ZONE": zone,
 	}
 	config.Params["NAME"] = bridgeName
 	config.Params["DEVICE"] = bridgeName
 	return config, nil
 }
-func readInterfaceConfig(filepath string) (config InterfaceConfig, err error){
-	const (
-		ValidDataCount = 2
-		DataName = 0
-		DataValue = 1
-	)
-	file, err := os.Open(filepath)
-	if err != nil{
-		return
+func generateBondConfig(uplink Uplink, bridgeName string) (config InterfaceConfig){
+	config.Params = map[string]string{
+		"NM_CONTROLLED": "no",
+		"TYPE": "Bond",
+		"BONDING_MASTER": "yes",
+		"BONDING_OPTS": fmt.Sprintf("mode=%s miimon=%d", uplink.BondMode, BondMonitorInterval),
+		"BOOTPROTO": "none",
+		"ONBOOT": "yes",
 	}
-	config.Params = map[string]string{}
-	var scanner = bufio.NewScanner(file)
-	var lineIndex = 0
-	for scanner.Scan(){
-		var line = scanner.Text()
-		var data = strings.Split(line, "=")
-		lineIndex++
-		if ValidDataCount != len(data){
-			fmt.Printf("ignore line %d of '%s': %s\n", lineIndex, filepath, line)
-			continue
-		}
-		config.Params[data[DataName]] = data[DataValue]
+	config.Params["NAME"] = uplink.Interface
+	config.Params["DEVICE"] = uplink.Interface
+	if 0 == uplink.VLAN{
+		config.Params["BRIDGE"] = bridgeName
 	}
-	fmt.Printf("%d params loaded from '%s'\n", len(config.Params), filepath)
-	return config, nil
+	return config
 }
 
-func writeInterfaceConfig(config InterfaceConfig, filepath string) (err error){
-	file, err := os.Create(filepath)
-	if err != nil{
-		return err
-	}
-	for name, value := range config.Params{
-		fmt.Fprintf(file, "%s=%s\n", name, value)
+func generateVLANConfig(uplink Uplink, bridgeName string) (config InterfaceConfig){
+	config.Params = map[string]string{
+		"NM_CONTROLLED": "no",
+		"VLAN": "yes",
+		"BOOTPROTO": "none",
+		"ONBOOT": "yes",
+		"BRIDGE": bridgeName,
 	}
-	return file.Close()
+	config.Params["NAME"] = uplink.Port()
+	config.Params["DEVICE"] = uplink.Port()
+	config.Params["PHYSDEV"] = uplink.Interface
+	config.Params["VLAN_ID"] = strconv.Itoa(uplink.VLAN)
+	return config
+}
+
+//params of address and route moved from interface to bridge
+var bridgeMigrateParams = []string{
+	"BOOTPROTO", "PREFIX", "IPADDR", "GATEWAY", "NETMASK", "DNS1", "DNS2", "DOMAIN",
+	"DEFROUTE", "PEERDNS", "PEERROUTES", "IPV4_FAILURE_FATAL", "IPV6_FAILURE_FATAL", "PROXY_METHOD",
+	"IPV6ADDR", "IPV6_DEFAULTGW", "IPV6_AUTOCONF", "IPV6_DEFROUTE", "IPV6INIT", "IPV6_ADDR_GEN_MODE",
 }
 
 func migrateInterfaceConfig(bridgeName string, ifcfg, brcfg *InterfaceConfig) (err error){
@@ -456,13 +522,7 @@ func migrateInterfaceConfig(bridgeName string, ifcfg, brcfg *InterfaceConfig) (e
 		BRIDGE    = "BRIDGE"
 		ONBOOT    = "ONBOOT"
 	)
-	var migrateList = []string{
-		"BOOTPROTO", "PREFIX", "IPADDR", "GATEWAY", "NETMASK", "DNS1", "DNS2", "DOMAIN",
-		"DEFROUTE", "PEERDNS", "PEERROUTES", "IPV4_FAILURE_FATAL", "IPV6_FAILURE_FATAL", "PROXY_METHOD",
-		"IPV6ADDR", "IPV6_DEFAULTGW", "IPV6_AUTOCONF", "IPV6_DEFROUTE", "IPV6INIT", "IPV6_ADDR_GEN_MODE",
-	}
-
-	for _, name := range migrateList{
+	for _, name := range bridgeMigrateParams{
 		if value, exists := ifcfg.Params[name]; exists{
 			brcfg.Params[name] = value
 			delete(ifcfg.Params, name)
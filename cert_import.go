@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// importCertificateAuthority loads CA specified instead of generating one, could be a root or an intermediate
+// followed by its issuers. Key is optional, then all leaf certificates must be imported
+func importCertificateAuthority(certSource, keySource string) (authority *CertificateAuthority, keyPEM []byte, err error) {
+	if authority, err = loadCertificateAuthority(certSource, keySource); err != nil {
+		return
+	}
+	if nil != authority.signer {
+		//decrypted, encrypted again by passphrase of cluster when installed
+		if keyPEM, err = marshalPrivateKey(authority.signer); err != nil {
+			return
+		}
+	}
+	var certificate = authority.Certificate
+	if !certificate.BasicConstraintsValid || !certificate.IsCA {
+		err = fmt.Errorf("'%s' is not a CA certificate", certSource)
+		return
+	}
+	if 0 != certificate.KeyUsage && 0 == certificate.KeyUsage&x509.KeyUsageCertSign {
+		err = fmt.Errorf("'%s' not allowed to sign certificates", certSource)
+		return
+	}
+	if err = checkValidity(certificate); err != nil {
+		err = fmt.Errorf("CA '%s' %s", certSource, err.Error())
+		return
+	}
+	var options x509.VerifyOptions
+	if options, err = authority.verifyOptions(nil, x509.ExtKeyUsageAny); err != nil {
+		return
+	}
+	if _, err = certificate.Verify(options); err != nil {
+		err = fmt.Errorf("verify chain of CA '%s' fail: %s, include issuers in the file when not trusted by system", certSource, err.Error())
+		return
+	}
+	if nil == authority.Root {
+		fmt.Printf("intermediate CA '%s' imported, issued by %s trusted by system\n", certificate.Subject.CommonName, certificate.Issuer.CommonName)
+	} else if authority.Root == certificate {
+		fmt.Printf("root CA '%s' imported\n", certificate.Subject.CommonName)
+	} else {
+		fmt.Printf("intermediate CA '%s' imported, issued by root '%s'\n", certificate.Subject.CommonName, authority.Root.Subject.CommonName)
+	}
+	return authority, keyPEM, nil
+}
+
+// verifyOptions trusts root in CA file, or roots of system when root not included
+func (authority *CertificateAuthority) verifyOptions(intermediates []*x509.Certificate, usage x509.ExtKeyUsage) (options x509.VerifyOptions, err error) {
+	options.KeyUsages = []x509.ExtKeyUsage{usage}
+	if nil != authority.Root {
+		options.Roots = x509.NewCertPool()
+		options.Roots.AddCert(authority.Root)
+	} else if options.Roots, err = x509.SystemCertPool(); err != nil {
+		err = fmt.Errorf("load trusted CA of system fail: %s", err.Error())
+		return
+	}
+	options.Intermediates = x509.NewCertPool()
+	for _, certificate := range append(intermediates, authority.Chain...) {
+		options.Intermediates.AddCert(certificate)
+	}
+	return options, nil
+}
+
+func checkValidity(certificate *x509.Certificate) error {
+	var now = time.Now()
+	if now.Before(certificate.NotBefore) {
+		return fmt.Errorf("not valid before %s", certificate.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(certificate.NotAfter) {
+		return fmt.Errorf("expired at %s", certificate.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadCertificatePair loads certificate chain and matched key, the leaf must be valid now
+func loadCertificatePair(certSource, keySource string) (chain []*x509.Certificate, key crypto.Signer, keyPEM []byte, err error) {
+	var certPEM []byte
+	if certPEM, err = host.ReadFile(certSource); err != nil {
+		return
+	}
+	if chain, err = parseCertificateChain(certPEM); err != nil {
+		err = fmt.Errorf("load certificate '%s' fail: %s", certSource, err.Error())
+		return
+	}
+	if keyPEM, err = host.ReadFile(keySource); err != nil {
+		return
+	}
+	if key, err = parsePrivateKey(keyPEM); err != nil {
+		err = fmt.Errorf("load key '%s' fail: %s", keySource, err.Error())
+		return
+	}
+	if err = matchPublicKey(chain[0], key); err != nil {
+		err = fmt.Errorf("key '%s' not match certificate '%s': %s", keySource, certSource, err.Error())
+		return
+	}
+	if err = checkValidity(chain[0]); err != nil {
+		err = fmt.Errorf("certificate '%s' %s", certSource, err.Error())
+		return
+	}
+	return chain, key, keyPEM, nil
+}
+
+// importModuleCertificate validates certificate specified is issued by CA of cluster,
+// valid for addresses chosen and names specified, then installs it with intermediates in place of the issued one
+func importModuleCertificate(session *SessionInfo, target ModuleCertificate, certSource, keySource string) (err error) {
+	if "" == keySource {
+		return fmt.Errorf("%s required", target.KeyAnswer)
+	}
+	var chain []*x509.Certificate
+	var keyPEM []byte
+	if chain, _, keyPEM, err = loadCertificatePair(certSource, keySource); err != nil {
+		return
+	}
+	var leaf = chain[0]
+	var authority *CertificateAuthority
+	if authority, err = loadCertificateAuthority(session.CACertPath, ""); err != nil {
+		return
+	}
+	var options x509.VerifyOptions
+	if options, err = authority.verifyOptions(chain[1:], x509.ExtKeyUsageServerAuth); err != nil {
+		return
+	}
+	var chains [][]*x509.Certificate
+	if chains, err = leaf.Verify(options); err != nil {
+		return fmt.Errorf("verify '%s' fail: %s", certSource, err.Error())
+	}
+	var verified []*x509.Certificate
+	for _, candidate := range chains {
+		for _, issuer := range candidate[1:] {
+			if issuer.Equal(authority.Certificate) {
+				verified = candidate
+				break
+			}
+		}
+		if nil != verified {
+			break
+		}
+	}
+	if nil == verified {
+		return fmt.Errorf("'%s' not issued by CA '%s' of cluster", certSource, authority.Certificate.Subject.CommonName)
+	}
+	//addresses chosen and names specified required, hostname may not be used by clients
+	for _, name := range append(certificateAddresses(session, target), session.CertProfile.Names...) {
+		if "" == name {
+			continue
+		}
+		if err = leaf.VerifyHostname(name); err != nil {
+			return fmt.Errorf("'%s' not valid for %s: %s", certSource, name, err.Error())
+		}
+	}
+	if hostname, hostError := os.Hostname(); nil == hostError && nil != leaf.VerifyHostname(hostname) {
+		fmt.Printf("warning: '%s' not valid for hostname %s\n", certSource, hostname)
+	}
+	//send intermediates with leaf, root excluded
+	var certPEM = encodeCertificates(verified[:len(verified)-1])
+	var certFile, keyFile = target.Files(session.ProjectPath)
+	if err = ensurePath(filepath.Dir(certFile), target.Name+" cert", session.UID, session.GID); err != nil {
+		return
+	}
+	if err = writeCertificatePair(session, certFile, certPEM, keyFile, keyPEM); err != nil {
+		return
+	}
+	fmt.Printf("%s certificate '%s' imported from '%s', expire at %s\n", target.Name, certFile, certSource,
+		leaf.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// checkCertificateImports validates files specified for import before any change, chains and SANs
+// checked when installing, since CA and addresses not ready yet
+func checkCertificateImports() (results []CheckResult) {
+	const (
+		Name = "certificate import"
+	)
+	if certSource := optionalAnswer(AnswerCACertFile, ""); "" != certSource {
+		if _, _, err := importCertificateAuthority(certSource, optionalAnswer(AnswerCAKeyFile, "")); err != nil {
+			results = append(results, CheckResult{Name + " CA", CheckFail, err.Error()})
+		} else {
+			results = append(results, CheckResult{Name + " CA", CheckPass, certSource})
+		}
+	} else if "" != optionalAnswer(AnswerCAKeyFile, "") && !isPositiveAnswer(optionalAnswer(AnswerCAKeyOffline, "")) {
+		results = append(results, CheckResult{Name + " CA", CheckFail, fmt.Sprintf("%s required", AnswerCACertFile)})
+	}
+	if isPositiveAnswer(optionalAnswer(AnswerCAKeyOffline, "")) {
+		if keyFile := optionalAnswer(AnswerCAKeyFile, ""); "" == keyFile {
+			results = append(results, CheckResult{"CA key offline", CheckFail, fmt.Sprintf("%s required", AnswerCAKeyFile)})
+		} else if !host.Exists(keyFile) {
+			results = append(results, CheckResult{"CA key offline", CheckPass, fmt.Sprintf("key generated written to '%s'", keyFile)})
+		} else {
+			results = append(results, CheckResult{"CA key offline", CheckPass, fmt.Sprintf("signing by '%s'", keyFile)})
+		}
+	}
+	for _, target := range moduleCertificates {
+		var certSource = optionalAnswer(target.CertAnswer, "")
+		if "" == certSource {
+			continue
+		}
+		var keySource = optionalAnswer(target.KeyAnswer, "")
+		if "" == keySource {
+			results = append(results, CheckResult{Name + " " + target.Name, CheckFail, fmt.Sprintf("%s required", target.KeyAnswer)})
+		} else if _, _, _, err := loadCertificatePair(certSource, keySource); err != nil {
+			results = append(results, CheckResult{Name + " " + target.Name, CheckFail, err.Error()})
+		} else {
+			results = append(results, CheckResult{Name + " " + target.Name, CheckPass, certSource})
+		}
+	}
+	return results
+}
+
+// trustCertificateAuthority installs root of cluster into trust anchors of system, anchor removed when root is nil,
+// which means issuer of CA already trusted
+func trustCertificateAuthority(session *SessionInfo, rootPEM []byte) (err error) {
+	var trustedCertFile = filepath.Join(TrustedCAPath, fmt.Sprintf("%s_ca.crt.pem", ProjectName))
+	var current, readError = host.ReadFile(trustedCertFile)
+	if nil == rootPEM {
+		if nil != readError {
+			fmt.Println("issuer of CA trusted by system, no anchor required")
+			return nil
+		}
+	} else if nil == readError && bytes.Equal(current, rootPEM) {
+		fmt.Printf("'%s' already installed\n", trustedCertFile)
+		return nil
+	}
+	registerUndo("update trusted CA", func(operator HostOperator) error {
+		return operator.Execute("update-ca-trust")
+	})
+	if nil == rootPEM {
+		if err = host.Remove(trustedCertFile); err != nil {
+			return
+		}
+		fmt.Printf("'%s' removed, issuer of CA trusted by system\n", trustedCertFile)
+	} else {
+		if err = host.WriteFile(trustedCertFile, rootPEM, DefaultFilePerm); err != nil {
+			return
+		}
+		updateAccess(session, trustedCertFile)
+		fmt.Printf("'%s' installed\n", trustedCertFile)
+	}
+	if err = host.Execute("update-ca-trust"); err != nil {
+		return
+	}
+	fmt.Printf("'%s' updated\n", trustedCertFile)
+	return nil
+}
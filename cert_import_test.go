@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTempProject returns a temporary project path, and keeps backups of files written in it until test finished
+func useTempProject(t *testing.T) string {
+	var path = t.TempDir()
+	var origin = backups
+	backups = NewBackupStore(filepath.Join(path, InstallerDataPathName, BackupPathName), path)
+	t.Cleanup(func() { backups = origin })
+	return path
+}
+
+// testCertificate is a certificate fixture with its key, signed by issuer or self-signed when issuer is nil
+type testCertificate struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	CertPEM     []byte
+	KeyPEM      []byte
+}
+
+func newTestCertificate(t *testing.T, template x509.Certificate, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().AddDate(1, 0, 0)
+	}
+	template.BasicConstraintsValid = true
+	var parent, signer = &template, crypto.Signer(key)
+	if nil != issuer {
+		parent, signer = issuer.Certificate, issuer.Key
+	}
+	content, err := x509.CreateCertificate(rand.Reader, &template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture = &testCertificate{Key: key, CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: content})}
+	if fixture.Certificate, err = x509.ParseCertificate(content); err != nil {
+		t.Fatal(err)
+	}
+	if fixture.KeyPEM, err = marshalPrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+func newTestCA(t *testing.T, name string, issuer *testCertificate) *testCertificate {
+	return newTestCertificate(t, x509.Certificate{Subject: pkix.Name{CommonName: name}, IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign}, issuer)
+}
+
+func newTestLeaf(t *testing.T, issuer *testCertificate, names ...string) *testCertificate {
+	var template = x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}, KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	for _, name := range names {
+		if ip := net.ParseIP(name); nil != ip {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	return newTestCertificate(t, template, issuer)
+}
+
+func writeTestFile(t *testing.T, path, name string, data ...[]byte) string {
+	var filename = filepath.Join(path, name)
+	var content []byte
+	for _, item := range data {
+		content = append(content, item...)
+	}
+	if err := ioutil.WriteFile(filename, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestImportModuleCertificate(t *testing.T) {
+	var projectPath = useTempProject(t)
+	var root = newTestCA(t, "cluster root", nil)
+	var intermediate = newTestCA(t, "cluster intermediate", root)
+	var other = newTestCA(t, "other root", nil)
+	var names = []string{"192.168.1.10", "192.168.1.20", "nano.example.com"}
+	var session = &SessionInfo{ProjectPath: projectPath, LocalAddress: names[0], APIAddress: names[1],
+		CertProfile: CertificateProfile{Names: names[2:]}, UID: os.Getuid(), GID: os.Getgid()}
+	var issued = newTestLeaf(t, root, names...)
+	var byIntermediate = newTestLeaf(t, intermediate, names...)
+	var expiredTemplate = *issued.Certificate
+	expiredTemplate.NotBefore = time.Now().AddDate(-2, 0, 0)
+	expiredTemplate.NotAfter = time.Now().AddDate(-1, 0, 0)
+	var cases = []struct {
+		name    string
+		ca      []*testCertificate
+		leaf    *testCertificate
+		issuers []*testCertificate
+		key     *testCertificate
+		target  ModuleCertificate
+		failure string
+	}{
+		{"issued by cluster CA", []*testCertificate{root}, issued, nil, nil, APICertificate, ""},
+		{"hostname not required", []*testCertificate{root}, newTestLeaf(t, root, names[0], names[2]), nil, nil,
+			PortalCertificate, ""},
+		{"issued by intermediate CA of cluster", []*testCertificate{intermediate, root}, byIntermediate, nil, nil,
+			ImageCertificate, ""},
+		{"intermediate sent with leaf", []*testCertificate{root}, byIntermediate, []*testCertificate{intermediate}, nil,
+			ImageCertificate, ""},
+		{"not issued by cluster CA", []*testCertificate{root}, newTestLeaf(t, other, names...), nil, nil,
+			APICertificate, "verify"},
+		{"issued by parent of cluster CA", []*testCertificate{intermediate, root}, issued, nil, nil,
+			APICertificate, "not issued by CA"},
+		{"API address not covered", []*testCertificate{root}, newTestLeaf(t, root, names[0], names[2]), nil, nil,
+			APICertificate, "not valid for 192.168.1.20"},
+		{"name specified not covered", []*testCertificate{root}, newTestLeaf(t, root, names[:2]...), nil, nil,
+			ImageCertificate, "not valid for nano.example.com"},
+		{"expired", []*testCertificate{root}, newTestCertificate(t, expiredTemplate, root), nil, nil,
+			APICertificate, "expired at"},
+		{"key mismatched", []*testCertificate{root}, issued, nil, byIntermediate, APICertificate, "not match certificate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var path = t.TempDir()
+			var caPEM, certPEM [][]byte
+			for _, authority := range c.ca {
+				caPEM = append(caPEM, authority.CertPEM)
+			}
+			certPEM = append(certPEM, c.leaf.CertPEM)
+			for _, issuer := range c.issuers {
+				certPEM = append(certPEM, issuer.CertPEM)
+			}
+			var key = c.leaf
+			if nil != c.key {
+				key = c.key
+			}
+			session.CACertPath = writeTestFile(t, path, "ca.crt.pem", caPEM...)
+			var certSource = writeTestFile(t, path, "leaf.crt.pem", certPEM...)
+			var keySource = writeTestFile(t, path, "leaf.key.pem", key.KeyPEM)
+			var err = importModuleCertificate(session, c.target, certSource, keySource)
+			if "" != c.failure {
+				if nil == err || !strings.Contains(err.Error(), c.failure) {
+					t.Fatalf("expect error contains '%s', but got %v", c.failure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("import fail: %s", err.Error())
+			}
+			var certFile, keyFile = c.target.Files(projectPath)
+			data, err := ioutil.ReadFile(certFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain, err := parseCertificateChain(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//root of cluster never installed with leaf
+			var expected = 1
+			if intermediate == c.ca[0] || 0 != len(c.issuers) {
+				expected = 2
+			}
+			if expected != len(chain) || !chain[0].Equal(c.leaf.Certificate) {
+				t.Fatalf("expect leaf with %d certificate(s) installed, but got %d", expected, len(chain))
+			}
+			if info, err := os.Stat(keyFile); err != nil || KeyFilePerm != info.Mode().Perm() {
+				t.Fatalf("key not installed with mode %o: %v", KeyFilePerm, err)
+			}
+		})
+	}
+}
+
+func TestImportCertificateAuthority(t *testing.T) {
+	var root = newTestCA(t, "cluster root", nil)
+	var intermediate = newTestCA(t, "cluster intermediate", root)
+	var noCertSign = newTestCertificate(t, x509.Certificate{Subject: pkix.Name{CommonName: "no cert sign"}, IsCA: true,
+		KeyUsage: x509.KeyUsageDigitalSignature}, nil)
+	var expired = newTestCertificate(t, x509.Certificate{Subject: pkix.Name{CommonName: "expired"}, IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign, NotBefore: time.Now().AddDate(-2, 0, 0), NotAfter: time.Now().AddDate(-1, 0, 0)}, nil)
+	var leaf = newTestLeaf(t, root, "192.168.1.10")
+	var cases = []struct {
+		name    string
+		certs   []*testCertificate
+		key     *testCertificate
+		failure string
+	}{
+		{"root with key", []*testCertificate{root}, root, ""},
+		{"root without key", []*testCertificate{root}, nil, ""},
+		{"intermediate with root", []*testCertificate{intermediate, root}, intermediate, ""},
+		{"intermediate not trusted by system", []*testCertificate{intermediate}, intermediate, "not trusted by system"},
+		{"without cert sign usage", []*testCertificate{noCertSign}, noCertSign, "not allowed to sign certificates"},
+		{"not CA", []*testCertificate{leaf, root}, leaf, "is not a CA certificate"},
+		{"expired", []*testCertificate{expired}, expired, "expired at"},
+		{"key mismatched", []*testCertificate{root}, intermediate, "not match certificate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var path = t.TempDir()
+			var certPEM [][]byte
+			for _, certificate := range c.certs {
+				certPEM = append(certPEM, certificate.CertPEM)
+			}
+			var certSource = writeTestFile(t, path, "ca.crt.pem", certPEM...)
+			var keySource = ""
+			if nil != c.key {
+				keySource = writeTestFile(t, path, "ca.key.pem", c.key.KeyPEM)
+			}
+			authority, keyPEM, err := importCertificateAuthority(certSource, keySource)
+			if "" != c.failure {
+				if nil == err || !strings.Contains(err.Error(), c.failure) {
+					t.Fatalf("expect error contains '%s', but got %v", c.failure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("import fail: %s", err.Error())
+			}
+			if !authority.Certificate.Equal(c.certs[0].Certificate) || !authority.Root.Equal(root.Certificate) {
+				t.Fatal("unexpected CA or root imported")
+			}
+			if (nil == c.key) != (nil == keyPEM) {
+				t.Fatalf("key imported %t, expect %t", nil != keyPEM, nil != c.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// RenewCertificates reissues certificates of installed modules by CA of cluster, or only those of moduleName when specified.
+// A new CA generated before reissuing all certificates when renewCA enabled, and modules loading renewed certificates restarted
+func RenewCertificates(renewCA bool, moduleName string, warnDays int) (err error) {
+	if renewCA && "" != moduleName {
+		return errors.New("certificates of all modules reissued when renewing CA, no module should be specified")
+	}
+	var projectPath string
+	var manifest *InstallManifest
+	if projectPath, manifest, err = resolveProjectPath(); err != nil {
+		return
+	}
+	var targets []ModuleCertificate
+	var modules, restarts []string
+	for _, target := range moduleCertificates {
+		if "" != moduleName && moduleName != target.Module {
+			continue
+		}
+		if nil != manifest {
+			if !manifest.HasModule(target.Module) {
+				continue
+			}
+		} else if !host.Exists(filepath.Join(projectPath, target.Module)) {
+			continue
+		}
+		targets = append(targets, target)
+		if 0 == len(modules) || modules[len(modules)-1] != target.Module {
+			modules = append(modules, target.Module)
+		}
+		if target.Consumed() && (0 == len(restarts) || restarts[len(restarts)-1] != target.Module) {
+			restarts = append(restarts, target.Module)
+		}
+	}
+	if 0 == len(targets) {
+		if "" != moduleName {
+			return fmt.Errorf("no certificate of module '%s' installed in '%s'", moduleName, projectPath)
+		}
+		return fmt.Errorf("no module using certificate installed in '%s'", projectPath)
+	}
+	var session *SessionInfo
+	if nil != manifest {
+		session = &manifest.Session
+	} else if session, err = sessionOfInstalledPath(projectPath); err != nil {
+		return
+	}
+	if session.CertProfile, err = inputCertificateProfile(session.CertProfile); err != nil {
+		return
+	}
+	var addresses map[string]string
+	if addresses, err = loadListenAddresses(session, modules); err != nil {
+		return
+	}
+	var transaction = beginTransaction()
+	err = renewCertificateFiles(session, renewCA, targets, addresses)
+	if nil == err && nil != manifest {
+		//profile and key of CA may be changed
+		err = manifest.Save(projectPath, session)
+	}
+	finishTransaction(transaction, nil != err)
+	if err != nil {
+		return
+	}
+	for _, module := range restarts {
+		if err = restartRunningModule(module, filepath.Join(projectPath, module, module)); err != nil {
+			return
+		}
+	}
+	fmt.Printf("%d certificate(s) renewed\n", len(targets))
+	reportCertificateExpiry(projectPath, warnDays)
+	return nil
+}
+
+// CheckCertificates lists expiry of installed certificates, fails when any expires in warnDays
+func CheckCertificates(warnDays int) (err error) {
+	var projectPath string
+	if projectPath, _, err = resolveProjectPath(); err != nil {
+		return
+	}
+	if expiring := reportCertificateExpiry(projectPath, warnDays); 0 != expiring {
+		return fmt.Errorf("%d certificate(s) expire in %d days, run 'certs renew' to reissue", expiring, warnDays)
+	}
+	return nil
+}
+
+// sessionOfInstalledPath builds session for project path installed without manifest, owner of path used as service user
+func sessionOfInstalledPath(projectPath string) (session *SessionInfo, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(projectPath); err != nil {
+		return
+	}
+	session = &SessionInfo{ProjectPath: projectPath}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		session.UID = int(stat.Uid)
+		session.GID = int(stat.Gid)
+	}
+	var certPath = filepath.Join(projectPath, CertificatePathName)
+	session.CACertPath = filepath.Join(certPath, fmt.Sprintf("%s_ca%s", ProjectName, CertFileSuffix))
+	if !host.Exists(session.CACertPath) {
+		err = fmt.Errorf("no CA installed in '%s'", certPath)
+		return
+	}
+	if keyFile := filepath.Join(certPath, fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix)); host.Exists(keyFile) {
+		session.CAKeyPath = keyFile
+	} else {
+		session.CAKeyOffline = isPositiveAnswer(optionalAnswer(AnswerCAKeyOffline, ""))
+	}
+	return session, nil
+}
+
+// loadListenAddresses reads current listen address of modules from their configures, since it may be changed after installation.
+// Module without configure available not included, then address recorded in manifest used
+func loadListenAddresses(session *SessionInfo, modules []string) (addresses map[string]string, err error) {
+	const (
+		ConfigPathName = "config"
+	)
+	addresses = map[string]string{}
+	for _, module := range modules {
+		var configPath = filepath.Join(session.ProjectPath, module, ConfigPathName)
+		var address string
+		switch module {
+		case "core":
+			address, err = readCoreListenAddress(configPath)
+		case "frontend":
+			address, err = readFrontEndListenAddress(configPath)
+		}
+		if err != nil {
+			return
+		}
+		if "" != address {
+			addresses[module] = address
+			fmt.Printf("using listen address %s of %s in configure\n", address, module)
+		} else if "" != session.LocalAddress {
+			fmt.Printf("using listen address %s of %s recorded in manifest\n", session.LocalAddress, module)
+		} else {
+			return nil, fmt.Errorf("no listen address of %s available in configure or manifest", module)
+		}
+	}
+	return addresses, nil
+}
+
+// moduleSession returns copy of session with listen address of module, which serves on API address when it is core
+func moduleSession(session *SessionInfo, addresses map[string]string, module string) *SessionInfo {
+	var current = *session
+	if address, exists := addresses[module]; exists {
+		current.LocalAddress = address
+		if APICertificate.Module == module {
+			current.APIAddress = address
+		}
+	}
+	return &current
+}
+
+// renewCertificateFiles renews CA when required, then reissues certificates specified for listen address of each module.
+// Certificates specified by answers imported instead of issued
+func renewCertificateFiles(session *SessionInfo, renewCA bool, targets []ModuleCertificate, addresses map[string]string) (err error) {
+	if renewCA {
+		if err = renewCertificateAuthority(session); err != nil {
+			err = fmt.Errorf("renew CA fail: %s", err.Error())
+			return
+		}
+	}
+	for _, target := range targets {
+		if !target.Consumed() {
+			fmt.Printf("note: %s certificate reserved, not loaded by module %s yet\n", target.Name, target.Module)
+		}
+		var current = moduleSession(session, addresses, target.Module)
+		if importFile := optionalAnswer(target.CertAnswer, ""); "" != importFile {
+			if err = importModuleCertificate(current, target, importFile, optionalAnswer(target.KeyAnswer, "")); err != nil {
+				err = fmt.Errorf("import %s certificate fail: %s", target.Name, err.Error())
+				return
+			}
+			continue
+		}
+		if "" == session.CAKeyPath && !session.CAKeyOffline {
+			return fmt.Errorf("key of CA not available, %s and %s required", target.CertAnswer, target.KeyAnswer)
+		}
+		if err = signModuleCertificate(current, target); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// renewCertificateAuthority replaces installed CA by the one specified by answer, or a new generated one with the same profile.
+// CA imported must be renewed by its issuer, so never regenerated
+func renewCertificateAuthority(session *SessionInfo) (err error) {
+	var authority *CertificateAuthority
+	var keyPEM []byte
+	var generated = false
+	if importFile := optionalAnswer(AnswerCACertFile, ""); "" != importFile {
+		if authority, keyPEM, err = importCertificateAuthority(importFile, optionalAnswer(AnswerCAKeyFile, "")); err != nil {
+			return
+		}
+	} else {
+		var current *CertificateAuthority
+		if current, err = loadCertificateAuthority(session.CACertPath, ""); err != nil {
+			return
+		}
+		if current.Root != current.Certificate || GeneratedCAName != current.Certificate.Subject.CommonName {
+			return fmt.Errorf("CA '%s' not generated by installer, specify the one renewed by its issuer with %s",
+				current.Certificate.Subject.CommonName, AnswerCACertFile)
+		}
+		if authority, keyPEM, err = newCertificateAuthority(session.CertProfile); err != nil {
+			return
+		}
+		generated = true
+	}
+	if err = saveCertificateAuthority(session, authority, keyPEM, generated); err != nil {
+		return
+	}
+	if err = protectCAKey(session); err != nil {
+		return
+	}
+	//root not included when issuer of imported CA trusted by system already
+	var rootPEM []byte
+	if nil != authority.Root {
+		rootPEM = encodeCertificates([]*x509.Certificate{authority.Root})
+	}
+	if err = trustCertificateAuthority(session, rootPEM); err != nil {
+		return
+	}
+	fmt.Println("clients trusting the previous CA must trust the new one")
+	return nil
+}
+
+// restartRunningModule restarts module so that renewed certificates loaded, stopped module kept untouched
+func restartRunningModule(moduleName, binaryPath string) (err error) {
+	if !host.Exists(binaryPath) {
+		return nil
+	}
+	var running bool
+	if running, err = isModuleRunning(binaryPath); err != nil {
+		fmt.Printf("warning: check status of module %s fail: %s, restart it manually\n", moduleName, err.Error())
+		return nil
+	}
+	if !running {
+		fmt.Printf("module %s not running, renewed certificates loaded when started\n", moduleName)
+		return nil
+	}
+	if err = stopModule(binaryPath); err != nil {
+		err = fmt.Errorf("stop module %s fail: %s", moduleName, err.Error())
+		return
+	}
+	const (
+		StopGap = time.Millisecond * 300
+	)
+	time.Sleep(StopGap)
+	if err = startModule(binaryPath); err != nil {
+		err = fmt.Errorf("restart module %s fail: %s", moduleName, err.Error())
+		return
+	}
+	fmt.Printf("module %s restarted\n", moduleName)
+	return nil
+}
+
+// reportCertificateExpiry prints expiry of installed certificates, returns count of certificates expired or expire in warnDays
+func reportCertificateExpiry(projectPath string, warnDays int) (expiring int) {
+	for _, installed := range installedCertificateFiles(projectPath) {
+		if !host.Exists(installed.File) {
+			continue
+		}
+		var certificate, err = loadCertificate(installed.File)
+		if err != nil {
+			fmt.Printf("warning: load certificate '%s' fail: %s\n", installed.File, err.Error())
+			continue
+		}
+		var daysLeft = int(time.Until(certificate.NotAfter).Hours() / 24)
+		if daysLeft < 0 {
+			fmt.Printf("warning: %s certificate '%s' expired at %s\n", installed.Name, installed.File,
+				certificate.NotAfter.Format(time.RFC3339))
+			expiring++
+		} else if daysLeft < warnDays {
+			fmt.Printf("warning: %s certificate '%s' expire in %d days\n", installed.Name, installed.File, daysLeft)
+			expiring++
+		} else {
+			fmt.Printf("%s certificate '%s' expire at %s\n", installed.Name, installed.File,
+				certificate.NotAfter.Format("2006-01-02"))
+		}
+	}
+	return expiring
+}
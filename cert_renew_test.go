@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadListenAddresses(t *testing.T) {
+	const (
+		recorded = "172.16.0.1"
+		core     = "192.168.1.10"
+		portal   = "10.0.0.5"
+	)
+	var cases = []struct {
+		name      string
+		configs   map[string]string
+		recorded  string
+		expected  map[string]string
+		failed    bool
+		localAPI  [2]string
+		portalAPI [2]string
+	}{
+		{"address of each module",
+			map[string]string{"core/config/domain.cfg": `{"listen_address": "` + core + `"}`,
+				"frontend/config/frontend.cfg": `{"address": "` + portal + `", "service_host": "` + core + `"}`},
+			recorded, map[string]string{"core": core, "frontend": portal}, false,
+			[2]string{core, core}, [2]string{portal, recorded}},
+		{"recorded address for module without configure",
+			map[string]string{"core/config/domain.cfg": `{"listen_address": "` + core + `"}`},
+			recorded, map[string]string{"core": core}, false,
+			[2]string{core, core}, [2]string{recorded, recorded}},
+		{"no address available", nil, "", nil, true, [2]string{}, [2]string{}},
+		{"invalid configure", map[string]string{"frontend/config/frontend.cfg": "address"}, recorded, nil, true,
+			[2]string{}, [2]string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var projectPath = t.TempDir()
+			for name, content := range c.configs {
+				var filename = filepath.Join(projectPath, name)
+				if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, filepath.Dir(filename), filepath.Base(filename), []byte(content))
+			}
+			var session = &SessionInfo{ProjectPath: projectPath, LocalAddress: c.recorded, APIAddress: c.recorded}
+			addresses, err := loadListenAddresses(session, []string{"core", "frontend"})
+			if c.failed {
+				if nil == err {
+					t.Fatalf("expect fail, but got %v", addresses)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load fail: %s", err.Error())
+			}
+			if !reflect.DeepEqual(c.expected, addresses) {
+				t.Fatalf("expect addresses %v, but got %v", c.expected, addresses)
+			}
+			for module, expected := range map[string][2]string{"core": c.localAPI, "frontend": c.portalAPI} {
+				var current = moduleSession(session, addresses, module)
+				if expected != [2]string{current.LocalAddress, current.APIAddress} {
+					t.Fatalf("expect listen/API address %v of %s, but got %s/%s", expected, module,
+						current.LocalAddress, current.APIAddress)
+				}
+			}
+			if recorded != session.LocalAddress || recorded != session.APIAddress {
+				t.Fatal("addresses in session changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Command is a subcommand of installer, invoked like 'installer <name> [options] [arguments]'
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Execute     func(args []string) error
+}
+
+func availableCommands() []Command {
+	return []Command{
+		{"install", "[options] <core,frontend,cell|all>", "install modules", installCommand},
+		{"preflight", "[options] <core,frontend,cell|all>", "check host before installing modules, nothing changed", preflightCommand},
+		{"update", "[options]", "update installed modules", updateCommand},
+		{"uninstall", "[options]", "stop and remove installed modules, revert system configure", uninstallCommand},
+		{"status", "[options]", "report install state and health of node", statusCommand},
+		{"certs", "<renew|check> [options]", "reissue certificates of modules, or check expiry of certificates", certsCommand},
+		{"restore", "[options] <--list|backup id>", "list backups of system files, or put a file back from backup", restoreCommand},
+		{"version", "", "print version of installer and nano", versionCommand},
+		{"help", "", "print usage", helpCommand},
+	}
+}
+
+func executeCommand(name string, args []string) error {
+	for _, command := range availableCommands() {
+		if command.Name == name {
+			if err := command.Execute(args); err != nil && flag.ErrHelp != err {
+				return err
+			}
+			return nil
+		}
+	}
+	printUsage()
+	return fmt.Errorf("invalid command '%s'", name)
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %s <command> [options] [arguments]\n", os.Args[0])
+	fmt.Println("interactive menu available when no command specified\n\ncommands:")
+	for _, command := range availableCommands() {
+		fmt.Printf("  %-10s %s\n", command.Name, command.Description)
+	}
+	fmt.Printf("\nrun '%s <command> -h' for options of command\n", os.Args[0])
+}
+
+func newCommandFlags(name, usage string) *flag.FlagSet {
+	var set = flag.NewFlagSet(name, flag.ContinueOnError)
+	set.Usage = func() {
+		fmt.Printf("Usage: %s %s %s\n", os.Args[0], name, usage)
+		set.PrintDefaults()
+	}
+	return set
+}
+
+// parseCommandFlags parses options before and after arguments, like 'install core,frontend --dry-run',
+// which flag.FlagSet stops at the first argument. Anything after "--" is argument
+func parseCommandFlags(set *flag.FlagSet, args []string) (arguments []string, err error) {
+	for {
+		if err = set.Parse(args); err != nil {
+			return
+		}
+		var rest = set.Args()
+		if 0 == len(rest) {
+			return arguments, nil
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && "--" == args[parsed-1] {
+			return append(arguments, rest...), nil
+		}
+		arguments = append(arguments, rest[0])
+		args = rest[1:]
+	}
+}
+
+// answerFlag presets the value of an answer key from command line
+type answerFlag struct {
+	answers *AnswerPrompter
+	key     string
+	boolean bool
+}
+
+func (value *answerFlag) String() string {
+	return ""
+}
+
+func (value *answerFlag) Set(input string) error {
+	value.answers.Set(value.key, input)
+	return nil
+}
+
+func (value *answerFlag) IsBoolFlag() bool {
+	return value.boolean
+}
+
+// answerOptions binds answer keys as flags named like '--group-address', and an additional '--config' for answer file
+type answerOptions struct {
+	overrides  *AnswerPrompter
+	answerFile *string
+}
+
+func bindAnswerFlags(set *flag.FlagSet, valueKeys, booleanKeys []string) (options answerOptions) {
+	options.overrides = NewAnswerPrompter("command line", nil)
+	options.answerFile = set.String("config", "", "answer file (.json/.yaml/.yml), any value not specified by answer file or flags is an error")
+	for _, key := range valueKeys {
+		set.Var(&answerFlag{options.overrides, key, false}, answerFlagName(key), fmt.Sprintf("value of '%s'", key))
+	}
+	for _, key := range booleanKeys {
+		set.Var(&answerFlag{options.overrides, key, true}, answerFlagName(key), fmt.Sprintf("answer yes to '%s'", key))
+	}
+	return
+}
+
+// prepare builds the prompter: values from flags override the answer file,
+// and console used only when no answer file specified
+func (options answerOptions) prepare() (answers *AnswerPrompter, err error) {
+	if "" == *options.answerFile {
+		answers = NewAnswerPrompter("command line", &ConsolePrompter{})
+	} else if answers, err = LoadAnswerFile(*options.answerFile, nil); err != nil {
+		return
+	}
+	for key, value := range options.overrides.values {
+		answers.Set(key, value)
+	}
+	return answers, nil
+}
+
+func bindDryRunFlag(set *flag.FlagSet) *bool {
+	return set.Bool("dry-run", false, "print every file write, command and link operation instead of applying them")
+}
+
+func answerFlagName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+func installCommand(args []string) (err error) {
+	var set = newCommandFlags("install", "[options] <core,frontend,cell|all>")
+	var options = bindAnswerFlags(set, []string{
+		AnswerUser,
+		AnswerDomain,
+		AnswerGroupAddress,
+		AnswerGroupPort,
+		AnswerListenAddress,
+		AnswerAPIAddress,
+		AnswerAPIPort,
+		AnswerPortalPort,
+		AnswerBridgeName,
+		AnswerBridges,
+		AnswerBridgeInterface,
+		AnswerUplinkType,
+		AnswerBondSlaves,
+		AnswerBondName,
+		AnswerBondMode,
+		AnswerBridgeVLAN,
+		AnswerFirewall,
+		AnswerFirewallZone,
+		AnswerFirewallSources,
+		AnswerNetworkBackend,
+		AnswerNetworkConfirmTimeout,
+		AnswerCertKeyType,
+		AnswerCALifetimeDays,
+		AnswerCertLifetimeDays,
+		AnswerCertNames,
+		AnswerCACertFile,
+		AnswerCAKeyFile,
+		AnswerImageCertFile,
+		AnswerImageKeyFile,
+		AnswerAPICertFile,
+		AnswerAPIKeyFile,
+		AnswerPortalCertFile,
+		AnswerPortalKeyFile,
+		AnswerCAKeyPassphraseFile,
+	}, []string{
+		AnswerCAKeyOffline,
+		AnswerConfirmBridge,
+		AnswerConfirmNetwork,
+		AnswerIgnoreFirewalld,
+		AnswerIgnoreDependencyFailed,
+	})
+	var dryRun = bindDryRunFlag(set)
+	var arguments []string
+	if arguments, err = parseCommandFlags(set, args); err != nil {
+		return
+	}
+	var answers *AnswerPrompter
+	if answers, err = options.prepare(); err != nil {
+		return
+	}
+	if 0 != len(arguments) {
+		answers.Set(AnswerModules, strings.Join(arguments, ","))
+	} else if !answers.Has(AnswerModules) {
+		set.Usage()
+		return errors.New("no module specified")
+	}
+	var selected map[int]bool
+	if selected, err = selectModulesByAnswer(answers); err != nil {
+		return
+	}
+	prompter = answers
+	fmt.Printf("Installer v%s started\n\nReady to install Project-Nano v%s ...\n", CurrentVersion, NanoVersion)
+	defer enableDryRun(*dryRun)()
+	return installModules(selected)
+}
+
+func preflightCommand(args []string) (err error) {
+	var set = newCommandFlags("preflight", "[options] <core,frontend,cell|all>")
+	var options = bindAnswerFlags(set, []string{AnswerBridgeName, AnswerBridges, AnswerBridgeInterface, AnswerBondSlaves,
+		AnswerBondName, AnswerBondMode, AnswerBridgeVLAN, AnswerNetworkBackend, AnswerFirewall, AnswerCACertFile,
+		AnswerCAKeyFile, AnswerImageCertFile, AnswerImageKeyFile, AnswerAPICertFile, AnswerAPIKeyFile,
+		AnswerPortalCertFile, AnswerPortalKeyFile, AnswerCAKeyPassphraseFile}, []string{AnswerCAKeyOffline})
+	var arguments []string
+	if arguments, err = parseCommandFlags(set, args); err != nil {
+		return
+	}
+	var answers *AnswerPrompter
+	if answers, err = options.prepare(); err != nil {
+		return
+	}
+	if 0 != len(arguments) {
+		answers.Set(AnswerModules, strings.Join(arguments, ","))
+	} else if !answers.Has(AnswerModules) {
+		set.Usage()
+		return errors.New("no module specified")
+	}
+	var selected map[int]bool
+	if selected, err = selectModulesByAnswer(answers); err != nil {
+		return
+	}
+	prompter = answers
+	return runPreflight(selected)
+}
+
+func updateCommand(args []string) (err error) {
+	var set = newCommandFlags("update", "[options]")
+	var forcibly = set.Bool("force", false, "update modules forcibly without checking binary")
+	var options = bindAnswerFlags(set, []string{AnswerProjectPath}, nil)
+	var dryRun = bindDryRunFlag(set)
+	if err = set.Parse(args); err != nil {
+		return
+	}
+	if prompter, err = options.prepare(); err != nil {
+		return
+	}
+	defer enableDryRun(*dryRun)()
+	return UpdateAllModules(*forcibly)
+}
+
+func uninstallCommand(args []string) (err error) {
+	var set = newCommandFlags("uninstall", "[options]")
+	var keepData = set.Bool("keep-data", false, "keep certificates and configures for reinstallation")
+	var options = bindAnswerFlags(set, []string{AnswerProjectPath, AnswerFirewall}, []string{AnswerConfirmUninstall})
+	var dryRun = bindDryRunFlag(set)
+	if err = set.Parse(args); err != nil {
+		return
+	}
+	if prompter, err = options.prepare(); err != nil {
+		return
+	}
+	defer enableDryRun(*dryRun)()
+	return UninstallModules(*keepData)
+}
+
+func statusCommand(args []string) (err error) {
+	var set = newCommandFlags("status", "[options]")
+	var outputJSON = set.Bool("json", false, "output in JSON format")
+	var options = bindAnswerFlags(set, []string{AnswerProjectPath, AnswerFirewall}, nil)
+	if err = set.Parse(args); err != nil {
+		return
+	}
+	if prompter, err = options.prepare(); err != nil {
+		return
+	}
+	return ReportNodeStatus(*outputJSON)
+}
+
+func certsCommand(args []string) (err error) {
+	const (
+		Usage = "<renew|check> [options]"
+	)
+	if 0 == len(args) {
+		fmt.Printf("Usage: %s certs %s\n", os.Args[0], Usage)
+		return errors.New("no action specified")
+	}
+	switch args[0] {
+	case "renew":
+		return certsRenewCommand(args[1:])
+	case "check":
+		return certsCheckCommand(args[1:])
+	default:
+		fmt.Printf("Usage: %s certs %s\n", os.Args[0], Usage)
+		return fmt.Errorf("invalid action '%s' of certs", args[0])
+	}
+}
+
+func certsRenewCommand(args []string) (err error) {
+	var set = newCommandFlags("certs renew", "[options]")
+	var renewCA = set.Bool("ca", false, "generate a new CA, or replace with the one specified, then reissue certificates of all modules")
+	var moduleName = set.String("module", "", "only reissue certificates of module (core|frontend)")
+	var warnDays = set.Int("warn-days", DefaultExpiryWarningDays, "warn when certificate expires in days")
+	var options = bindAnswerFlags(set, []string{
+		AnswerProjectPath,
+		AnswerCertKeyType,
+		AnswerCALifetimeDays,
+		AnswerCertLifetimeDays,
+		AnswerCertNames,
+		AnswerCACertFile,
+		AnswerCAKeyFile,
+		AnswerImageCertFile,
+		AnswerImageKeyFile,
+		AnswerAPICertFile,
+		AnswerAPIKeyFile,
+		AnswerPortalCertFile,
+		AnswerPortalKeyFile,
+		AnswerCAKeyPassphraseFile,
+	}, []string{AnswerCAKeyOffline})
+	var dryRun = bindDryRunFlag(set)
+	if err = set.Parse(args); err != nil {
+		return
+	}
+	if prompter, err = options.prepare(); err != nil {
+		return
+	}
+	defer enableDryRun(*dryRun)()
+	return RenewCertificates(*renewCA, *moduleName, *warnDays)
+}
+
+func certsCheckCommand(args []string) (err error) {
+	var set = newCommandFlags("certs check", "[options]")
+	var warnDays = set.Int("warn-days", DefaultExpiryWarningDays, "fail when certificate expires in days")
+	var options = bindAnswerFlags(set, []string{AnswerProjectPath}, nil)
+	if err = set.Parse(args); err != nil {
+		return
+	}
+	if prompter, err = options.prepare(); err != nil {
+		return
+	}
+	return CheckCertificates(*warnDays)
+}
+
+func restoreCommand(args []string) (err error) {
+	var set = newCommandFlags("restore", "[options] <--list|backup id>")
+	var list = set.Bool("list", false, "list backups of system files modified by installer")
+	var dryRun = bindDryRunFlag(set)
+	var arguments []string
+	if arguments, err = parseCommandFlags(set, args); err != nil {
+		return
+	}
+	if *list {
+		return listBackups()
+	}
+	if 1 != len(arguments) {
+		set.Usage()
+		return errors.New("backup id required")
+	}
+	defer enableDryRun(*dryRun)()
+	return backups.Restore(arguments[0])
+}
+
+func listBackups() (err error) {
+	var entries []BackupEntry
+	if entries, err = backups.List(); err != nil {
+		return
+	}
+	if 0 == len(entries) {
+		fmt.Println("no backup available")
+		return nil
+	}
+	for _, entry := range entries {
+		var state = fmt.Sprintf("mode %04o", entry.Mode)
+		if "" == entry.Backup {
+			state = "not existed"
+		}
+		fmt.Printf("%-20s %-25s %-12s %s\n", entry.ID, entry.Time, state, entry.Path)
+	}
+	fmt.Printf("%d backup(s) available\n", len(entries))
+	return nil
+}
+
+func versionCommand(args []string) error {
+	fmt.Printf("installer %s\nnano %s\n", CurrentVersion, NanoVersion)
+	return nil
+}
+
+func helpCommand(args []string) error {
+	printUsage()
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandFlags(t *testing.T) {
+	var cases = []struct {
+		name      string
+		args      []string
+		arguments []string
+		dryRun    bool
+		firewall  string
+	}{
+		{"options first", []string{"--dry-run", "--firewall", "nftables", "core,frontend"}, []string{"core,frontend"}, true, "nftables"},
+		{"options after arguments", []string{"core,frontend", "--dry-run", "--firewall=iptables"}, []string{"core,frontend"}, true, "iptables"},
+		{"options between arguments", []string{"core", "--dry-run", "cell"}, []string{"core", "cell"}, true, ""},
+		{"arguments only", []string{"all"}, []string{"all"}, false, ""},
+		{"no argument", []string{"--dry-run"}, nil, true, ""},
+		{"terminated by double dash", []string{"core", "--", "--dry-run"}, []string{"core", "--dry-run"}, false, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var set = flag.NewFlagSet("test", flag.ContinueOnError)
+			set.SetOutput(ioutil.Discard)
+			var dryRun = set.Bool("dry-run", false, "")
+			var firewall = set.String("firewall", "", "")
+			arguments, err := parseCommandFlags(set, c.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(c.arguments, arguments) {
+				t.Fatalf("expect arguments %v, but got %v", c.arguments, arguments)
+			}
+			if c.dryRun != *dryRun || c.firewall != *firewall {
+				t.Fatalf("expect dry run %t firewall '%s', but got %t '%s'", c.dryRun, c.firewall, *dryRun, *firewall)
+			}
+		})
+	}
+	var set = flag.NewFlagSet("test", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	if _, err := parseCommandFlags(set, []string{"core", "--invalid"}); nil == err {
+		t.Fatal("expect error for invalid option after arguments")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errInputTimeout returned when operator not input in time
+var errInputTimeout = errors.New("input timeout")
+
+// readConsoleLine reads a line from stdin before timeout, or without limit when timeout is 0. Stdin read byte by byte
+// only when available, so that nothing buffered or left reading stdin after return, and later prompts receive all input
+func readConsoleLine(timeout time.Duration) (line string, err error) {
+	var deadline = time.Now().Add(timeout)
+	var input = []unix.PollFd{{Fd: int32(os.Stdin.Fd()), Events: unix.POLLIN}}
+	var builder strings.Builder
+	var buffer = make([]byte, 1)
+	for {
+		//wait without limit
+		var wait = -1
+		if 0 != timeout {
+			var left = time.Until(deadline)
+			if left <= 0 {
+				return "", errInputTimeout
+			}
+			wait = int(left/time.Millisecond) + 1
+		}
+		var ready int
+		if ready, err = unix.Poll(input, wait); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return
+		} else if 0 == ready {
+			continue
+		}
+		var count int
+		if count, err = os.Stdin.Read(buffer); err != nil {
+			if errors.Is(err, io.EOF) {
+				return strings.TrimSpace(builder.String()), nil
+			}
+			return
+		} else if 0 == count {
+			continue
+		}
+		if '\n' == buffer[0] {
+			return strings.TrimSpace(builder.String()), nil
+		}
+		builder.WriteByte(buffer[0])
+	}
+}
+
+// readConsolePassword reads a line from stdin with echo disabled when stdin is a terminal,
+// echo restored even when interrupted
+func readConsolePassword() (password string, err error) {
+	var fd = int(os.Stdin.Fd())
+	var origin *unix.Termios
+	if origin, err = unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		//not a terminal, like piped
+		return readConsoleLine(0)
+	}
+	var hidden = *origin
+	hidden.Lflag &^= unix.ECHO
+	hidden.Lflag |= unix.ICANON | unix.ISIG
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &hidden); err != nil {
+		return
+	}
+	var interrupted = make(chan os.Signal, 1)
+	var finished = make(chan bool)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-interrupted:
+			unix.IoctlSetTermios(fd, unix.TCSETS, origin)
+			fmt.Println()
+			os.Exit(1)
+		case <-finished:
+		}
+	}()
+	defer func() {
+		signal.Stop(interrupted)
+		close(finished)
+		unix.IoctlSetTermios(fd, unix.TCSETS, origin)
+		fmt.Println()
+	}()
+	return readConsoleLine(0)
+}
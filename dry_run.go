@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// DryRunHost records modifications instead of applying them.
+// Files written are kept in memory, so that following steps read what previous steps planned
+type DryRunHost struct {
+	files       map[string][]byte
+	directories map[string]bool
+	removed     map[string]bool
+	fileCount   int
+	execCount   int
+	linkCount   int
+}
+
+func NewDryRunHost() *DryRunHost {
+	return &DryRunHost{
+		files:       map[string][]byte{},
+		directories: map[string]bool{},
+		removed:     map[string]bool{},
+	}
+}
+
+func (operator *DryRunHost) ReadFile(filename string) ([]byte, error) {
+	if data, exists := operator.files[filename]; exists {
+		return data, nil
+	}
+	if operator.removed[filename] {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func (operator *DryRunHost) Exists(path string) bool {
+	if _, exists := operator.files[path]; exists {
+		return true
+	}
+	if operator.directories[path] {
+		return true
+	}
+	if operator.removed[path] {
+		return false
+	}
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+func (operator *DryRunHost) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	var current, _ = operator.ReadFile(filename)
+	operator.plan("write file '%s' (mode %04o)", filename, perm.Perm())
+	printContentDiff(current, data)
+	operator.files[filename] = data
+	delete(operator.removed, filename)
+	operator.fileCount++
+	return nil
+}
+
+func (operator *DryRunHost) AppendFile(filename string, data []byte, perm os.FileMode) error {
+	var current, _ = operator.ReadFile(filename)
+	var content = append(append([]byte{}, current...), data...)
+	operator.plan("append to file '%s'", filename)
+	printContentDiff(current, content)
+	operator.files[filename] = content
+	delete(operator.removed, filename)
+	operator.fileCount++
+	return nil
+}
+
+func (operator *DryRunHost) CopyFile(source, target string) (err error) {
+	var data []byte
+	if data, err = operator.ReadFile(source); err != nil {
+		return
+	}
+	operator.plan("copy '%s' to '%s' (%d bytes)", source, target, len(data))
+	operator.files[target] = data
+	delete(operator.removed, target)
+	operator.fileCount++
+	return nil
+}
+
+func (operator *DryRunHost) MakeDirectory(path string, perm os.FileMode) error {
+	operator.plan("create directory '%s' (mode %04o)", path, perm.Perm())
+	operator.directories[path] = true
+	delete(operator.removed, path)
+	operator.fileCount++
+	return nil
+}
+
+func (operator *DryRunHost) Remove(path string) error {
+	operator.plan("remove '%s'", path)
+	delete(operator.files, path)
+	delete(operator.directories, path)
+	operator.removed[path] = true
+	operator.fileCount++
+	return nil
+}
+
+func (operator *DryRunHost) Chown(path string, uid, gid int) error {
+	operator.plan("change owner of '%s' to %d:%d", path, uid, gid)
+	return nil
+}
+
+func (operator *DryRunHost) Chmod(path string, perm os.FileMode) error {
+	operator.plan("change mode of '%s' to %04o", path, perm.Perm())
+	return nil
+}
+
+func (operator *DryRunHost) Execute(name string, args ...string) error {
+	operator.plan("exec: %s", formatCommand(name, args))
+	operator.execCount++
+	return nil
+}
+
+func (operator *DryRunHost) ExecuteOutput(name string, args ...string) ([]byte, error) {
+	return nil, operator.Execute(name, args...)
+}
+
+func (operator *DryRunHost) AddBridge(name string) error {
+	operator.plan("netlink: add bridge %s", name)
+	operator.linkCount++
+	return nil
+}
+
+func (operator *DryRunHost) SetLinkMaster(link, master string) error {
+	operator.plan("netlink: set master of %s to %s", link, master)
+	operator.linkCount++
+	return nil
+}
+
+func (operator *DryRunHost) SetLinkNoMaster(link string) error {
+	operator.plan("netlink: remove master of %s", link)
+	operator.linkCount++
+	return nil
+}
+
+func (operator *DryRunHost) SetLinkUp(link string) error {
+	operator.plan("netlink: set %s up", link)
+	operator.linkCount++
+	return nil
+}
+
+func (operator *DryRunHost) SetLinkDown(link string) error {
+	operator.plan("netlink: set %s down", link)
+	operator.linkCount++
+	return nil
+}
+
+func (operator *DryRunHost) DeleteLink(link string) error {
+	operator.plan("netlink: delete %s", link)
+	operator.linkCount++
+	return nil
+}
+
+// Summary prints count of planned changes
+func (operator *DryRunHost) Summary() {
+	fmt.Printf("[dry-run] %d file operations, %d commands, %d link operations planned, nothing changed on host\n",
+		operator.fileCount, operator.execCount, operator.linkCount)
+}
+
+func (operator *DryRunHost) plan(format string, args ...interface{}) {
+	fmt.Printf("[dry-run] %s\n", fmt.Sprintf(format, args...))
+}
+
+func formatCommand(name string, args []string) string {
+	var elements = []string{name}
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		elements = append(elements, arg)
+	}
+	return strings.Join(elements, " ")
+}
+
+// printContentDiff prints changed lines with unified style prefix
+func printContentDiff(current, updated []byte) {
+	const (
+		ContextLines = 2
+	)
+	if !isTextContent(current) || !isTextContent(updated) {
+		fmt.Printf("    binary content: %d bytes -> %d bytes\n", len(current), len(updated))
+		return
+	}
+	if bytes.Contains(updated, []byte("PRIVATE KEY")) {
+		fmt.Printf("    private key content hidden: %d bytes\n", len(updated))
+		return
+	}
+	if bytes.Equal(current, updated) {
+		fmt.Println("    no content changed")
+		return
+	}
+	var lines = diffLines(splitLines(current), splitLines(updated))
+	var lastPrinted = -1
+	for index, line := range lines {
+		if ' ' == line[0] {
+			var nearChange = false
+			for offset := index - ContextLines; offset <= index+ContextLines; offset++ {
+				if offset >= 0 && offset < len(lines) && ' ' != lines[offset][0] {
+					nearChange = true
+					break
+				}
+			}
+			if !nearChange {
+				continue
+			}
+		}
+		if lastPrinted >= 0 && index != lastPrinted+1 {
+			fmt.Println("    ...")
+		}
+		fmt.Printf("    %s\n", line)
+		lastPrinted = index
+	}
+}
+
+func isTextContent(data []byte) bool {
+	return utf8.Valid(data) && -1 == bytes.IndexByte(data, 0)
+}
+
+func splitLines(data []byte) []string {
+	if 0 == len(data) {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diffLines compares lines by longest common subsequence, output lines prefixed with ' ', '-' or '+'
+func diffLines(origin, updated []string) (result []string) {
+	var common = make([][]int, len(origin)+1)
+	for i := range common {
+		common[i] = make([]int, len(updated)+1)
+	}
+	for i := len(origin) - 1; i >= 0; i-- {
+		for j := len(updated) - 1; j >= 0; j-- {
+			if origin[i] == updated[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	var i, j = 0, 0
+	for i < len(origin) && j < len(updated) {
+		if origin[i] == updated[j] {
+			result = append(result, " "+origin[i])
+			i++
+			j++
+		} else if common[i+1][j] >= common[i][j+1] {
+			result = append(result, "-"+origin[i])
+			i++
+		} else {
+			result = append(result, "+"+updated[j])
+			j++
+		}
+	}
+	for ; i < len(origin); i++ {
+		result = append(result, "-"+origin[i])
+	}
+	for ; j < len(updated); j++ {
+		result = append(result, "+"+updated[j])
+	}
+	return result
+}
+
+// enableDryRun replaces host operator with a recorder when required, returns function to print summary
+func enableDryRun(enabled bool) func() {
+	if !enabled {
+		return func() {}
+	}
+	var recorder = NewDryRunHost()
+	host = recorder
+	fmt.Println("[dry-run] changes will be printed only")
+	return recorder.Summary
+}
+
+// dryRunEnabled checks if changes only recorded, including those in a transaction
+func dryRunEnabled() bool {
+	var current = host
+	if transaction, ok := current.(*Transaction); ok {
+		current = transaction.Origin()
+	}
+	_, recording := current.(*DryRunHost)
+	return recording
+}
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	FirewallAuto      = "auto"
+	FirewallFirewalld = "firewalld"
+	FirewallNftables  = "nftables"
+	FirewallIptables  = "iptables"
+)
+
+const (
+	DefaultFirewallZone   = "public"
+	FirewallCommentPrefix = "nano:"
+	MulticastRuleName     = "multicast"
+)
+
+// Firewall opens port ranges of modules and allows multicast traffic for group communication.
+// Rules already exist are skipped, and every rule added is registered for rolling back
+type Firewall interface {
+	Name() string
+	// Ready checks whether the backend available on host
+	Ready() error
+	Apply(ranges []PortRange) error
+	// Remove deletes rules of ranges and multicast added by Apply
+	Remove(ranges []PortRange) error
+	// OpenedPorts returns port ranges opened, formatted like PortRange.String()
+	OpenedPorts() (map[string]bool, error)
+}
+
+// newFirewall creates backend by name, zone and source allowlist of firewalld read from session
+func newFirewall(name string, session *SessionInfo) (firewall Firewall, err error) {
+	switch name {
+	case FirewallFirewalld:
+		return &FirewalldFirewall{session}, nil
+	case FirewallNftables:
+		return newNftablesFirewall(session), nil
+	case FirewallIptables:
+		return &IptablesFirewall{Chain: "INPUT"}, nil
+	case FirewallAuto, "":
+		return detectFirewall(session)
+	default:
+		err = fmt.Errorf("invalid firewall '%s', must be %s/%s/%s/%s", name,
+			FirewallAuto, FirewallFirewalld, FirewallNftables, FirewallIptables)
+		return
+	}
+}
+
+// detectFirewall prefers running firewalld, then nftables and iptables available
+func detectFirewall(session *SessionInfo) (firewall Firewall, err error) {
+	if active, _ := services.IsActive(FirewallFirewalld); active {
+		return newFirewall(FirewallFirewalld, session)
+	}
+	if _, err = exec.LookPath("nft"); nil == err {
+		return newFirewall(FirewallNftables, session)
+	}
+	if _, err = exec.LookPath("iptables"); nil == err {
+		return newFirewall(FirewallIptables, session)
+	}
+	err = errors.New("no available firewall detected, firewalld/nftables/iptables required")
+	return
+}
+
+// chooseFirewall uses backend, zone and sources preset by answer, detects backend when not specified, then checks if ready
+func chooseFirewall(session *SessionInfo) (firewall Firewall, err error) {
+	session.FirewallZone = optionalAnswer(AnswerFirewallZone, DefaultFirewallZone)
+	if answers, ok := prompter.(*AnswerPrompter); ok && answers.Has(AnswerFirewallSources) {
+		if session.FirewallSources, err = answers.StringList(AnswerFirewallSources); err != nil {
+			return
+		}
+		for _, source := range session.FirewallSources {
+			if _, _, err = net.ParseCIDR(source); err != nil {
+				err = fmt.Errorf("invalid firewall source '%s': %s", source, err.Error())
+				return
+			}
+		}
+	}
+	if firewall, err = newFirewall(optionalAnswer(AnswerFirewall, FirewallAuto), session); err != nil {
+		return
+	}
+	session.Firewall = firewall.Name()
+	if FirewallFirewalld != firewall.Name() && (DefaultFirewallZone != session.FirewallZone || 0 != len(session.FirewallSources)) {
+		fmt.Printf("warning: firewall zone and sources only available for firewalld, ignored by %s\n", firewall.Name())
+	}
+	if err = firewall.Ready(); err != nil {
+		fmt.Printf("warning: firewall %s not ready: %s\n", firewall.Name(), err.Error())
+		fmt.Println("Nano requires a running firewall to work properly.")
+		var confirmed bool
+		confirmed, err = prompter.Confirm(AnswerIgnoreFirewalld, "Continue installation with risk")
+		if err != nil || !confirmed {
+			err = errors.New("quit installation")
+			return
+		}
+		fmt.Println("warning: choose to continue with risk, your installation may not work")
+	} else {
+		fmt.Printf("firewall %s ready\n", firewall.Name())
+	}
+	return firewall, nil
+}
+
+// installedFirewall returns backend preset by answer or recorded in manifest, firewalld for installation without manifest
+func installedFirewall(manifest *InstallManifest) (Firewall, error) {
+	var session = &SessionInfo{}
+	if nil != manifest {
+		session = &manifest.Session
+	}
+	if name := optionalAnswer(AnswerFirewall, ""); "" != name {
+		return newFirewall(name, session)
+	}
+	if "" != session.Firewall {
+		return newFirewall(session.Firewall, session)
+	}
+	return newFirewall(FirewallFirewalld, session)
+}
+
+// restrictedPortRanges returns ranges limited to firewall sources: group communication and API
+func restrictedPortRanges() []PortRange {
+	return append(defaultPortRanges(), PortRange{APIPortBegin, APIPortEnd, "tcp"})
+}
+
+func firewallRuleComment(name string) string {
+	return FirewallCommentPrefix + name
+}
+
+// queryCommand returns true when command exits with 0
+func queryCommand(name string, args ...string) (bool, error) {
+	var err = exec.Command(name, args...).Run()
+	if nil == err {
+		return true, nil
+	}
+	if _, exited := err.(*exec.ExitError); exited {
+		return false, nil
+	}
+	return false, err
+}
+
+// FirewalldFirewall opens ports in a zone of firewalld, multicast allowed by rich rule instead of direct interface.
+// Zone created when not exists, and bridge / management interface bound to it when not the default zone.
+// Group and API ranges opened only for sources in allowlist when specified
+type FirewalldFirewall struct {
+	session *SessionInfo
+}
+
+var firewalldLegacyMulticastRule = []string{"ipv4", "filter", "INPUT", "0", "-m", "pkttype", "--pkt-type", "multicast", "-j", "ACCEPT"}
+
+func (firewall *FirewalldFirewall) Name() string {
+	return FirewallFirewalld
+}
+
+func (firewall *FirewalldFirewall) zone() string {
+	if "" == firewall.session.FirewallZone {
+		return DefaultFirewallZone
+	}
+	return firewall.session.FirewallZone
+}
+
+func (firewall *FirewalldFirewall) Ready() (err error) {
+	var active bool
+	if active, err = services.IsActive(FirewallFirewalld); err != nil {
+		return
+	} else if !active {
+		return errors.New("firewalld service is stopped")
+	}
+	var enabled bool
+	if enabled, err = services.IsEnabled(FirewallFirewalld); err != nil {
+		return
+	} else if !enabled {
+		return errors.New("firewalld service disabled")
+	}
+	return nil
+}
+
+// richRule builds rule accepts multicast when ports is nil, any source when source is empty
+func (firewall *FirewalldFirewall) richRule(source string, ports *PortRange) string {
+	var rule = `rule family="ipv4"`
+	if "" != source {
+		rule += fmt.Sprintf(` source address="%s"`, source)
+	}
+	if nil == ports {
+		rule += ` destination address="224.0.0.0/4"`
+	} else {
+		var portValue = fmt.Sprintf("%d-%d", ports.Begin, ports.End)
+		if ports.Begin == ports.End {
+			portValue = fmt.Sprintf("%d", ports.Begin)
+		}
+		rule += fmt.Sprintf(` port port="%s" protocol="%s"`, portValue, ports.Protocol)
+	}
+	return rule + " accept"
+}
+
+// ruleOptions returns firewall-cmd options of rules for ranges, keyed by option adding rule
+func (firewall *FirewalldFirewall) ruleOptions(ranges []PortRange) (options []string, descriptions map[string]string) {
+	var sources = firewall.session.FirewallSources
+	var restricted = map[PortRange]bool{}
+	for _, ports := range restrictedPortRanges() {
+		restricted[ports] = true
+	}
+	descriptions = map[string]string{}
+	if 0 == len(sources) {
+		var option = "rich-rule=" + firewall.richRule("", nil)
+		options = append(options, option)
+		descriptions[option] = "allow multicast in firewall"
+	} else {
+		for _, source := range sources {
+			var option = "rich-rule=" + firewall.richRule(source, nil)
+			options = append(options, option)
+			descriptions[option] = fmt.Sprintf("allow multicast from %s in firewall", source)
+		}
+	}
+	for index, config := range ranges {
+		if 0 == len(sources) || !restricted[config] {
+			var option = "port=" + config.String()
+			options = append(options, option)
+			descriptions[option] = fmt.Sprintf("open ports %s", config.String())
+			continue
+		}
+		for _, source := range sources {
+			var option = "rich-rule=" + firewall.richRule(source, &ranges[index])
+			options = append(options, option)
+			descriptions[option] = fmt.Sprintf("open ports %s for %s", config.String(), source)
+		}
+	}
+	return
+}
+
+func (firewall *FirewalldFirewall) Apply(ranges []PortRange) (err error) {
+	var zone = "--zone=" + firewall.zone()
+	var added = 0
+	registerUndo("reload firewall", func(operator HostOperator) error {
+		return operator.Execute("firewall-cmd", "--reload")
+	})
+	if err = firewall.ensureZone(); err != nil {
+		return
+	}
+	if err = firewall.bindInterfaces(); err != nil {
+		return
+	}
+	var options, descriptions = firewall.ruleOptions(ranges)
+	for _, option := range options {
+		var exists bool
+		if exists, err = queryCommand("firewall-cmd", "--permanent", zone, "--query-"+option); err != nil {
+			return
+		} else if exists {
+			continue
+		}
+		if err = host.Execute("firewall-cmd", "--permanent", zone, "--add-"+option); err != nil {
+			return
+		}
+		var removeOption = "--remove-" + option
+		registerUndo(descriptions[option], func(operator HostOperator) error {
+			return operator.Execute("firewall-cmd", "--permanent", zone, removeOption)
+		})
+		firewall.session.FirewallRules = append(firewall.session.FirewallRules, option)
+		added++
+	}
+	if err = host.Execute("firewall-cmd", "--reload"); err != nil {
+		return
+	}
+	fmt.Printf("%d rule(s) added to firewalld zone %s\n", added, firewall.zone())
+	return nil
+}
+
+// ensureZone creates zone when not exists
+func (firewall *FirewalldFirewall) ensureZone() (err error) {
+	var zoneName = firewall.zone()
+	var output []byte
+	if output, err = exec.Command("firewall-cmd", "--permanent", "--get-zones").Output(); err != nil {
+		err = fmt.Errorf("get zones fail: %s", err.Error())
+		return
+	}
+	for _, current := range strings.Fields(string(output)) {
+		if zoneName == current {
+			return nil
+		}
+	}
+	if err = host.Execute("firewall-cmd", "--permanent", "--new-zone="+zoneName); err != nil {
+		return
+	}
+	registerUndo(fmt.Sprintf("create firewall zone %s", zoneName), func(operator HostOperator) error {
+		return operator.Execute("firewall-cmd", "--permanent", "--delete-zone="+zoneName)
+	})
+	firewall.session.FirewallZoneCreated = true
+	fmt.Printf("firewall zone %s created\n", zoneName)
+	return nil
+}
+
+// bindInterfaces binds bridge and management interface to zone, interfaces stay in default zone when using public
+func (firewall *FirewalldFirewall) bindInterfaces() (err error) {
+	var zoneName = firewall.zone()
+	if DefaultFirewallZone == zoneName {
+		return nil
+	}
+	var candidates []string
+	var bound = map[string]bool{}
+	for _, bridge := range installedBridges(firewall.session) {
+		candidates = append(candidates, bridge.Name)
+		bound[bridge.Name] = true
+	}
+	if name, interfaceError := managementInterface(firewall.session.LocalAddress); interfaceError != nil {
+		fmt.Printf("warning: find management interface fail: %s\n", interfaceError.Error())
+	} else if !bound[name] {
+		candidates = append(candidates, name)
+	}
+	for _, name := range candidates {
+		var output []byte
+		output, _ = exec.Command("firewall-cmd", "--permanent", "--get-zone-of-interface="+name).Output()
+		var previous = strings.TrimSpace(string(output))
+		if zoneName == previous {
+			fmt.Printf("interface %s already in zone %s\n", name, zoneName)
+			continue
+		}
+		if strings.Contains(previous, " ") {
+			//message like 'no zone'
+			previous = ""
+		}
+		if err = host.Execute("firewall-cmd", "--permanent", "--zone="+zoneName, "--change-interface="+name); err != nil {
+			err = fmt.Errorf("bind interface %s to zone %s fail: %s", name, zoneName, err.Error())
+			return
+		}
+		var interfaceName = name
+		registerUndo(fmt.Sprintf("bind interface %s to zone %s", name, zoneName), func(operator HostOperator) error {
+			return firewall.unbindInterface(operator, interfaceName, previous)
+		})
+		if nil == firewall.session.FirewallInterfaces {
+			firewall.session.FirewallInterfaces = map[string]string{}
+		}
+		firewall.session.FirewallInterfaces[name] = previous
+		fmt.Printf("interface %s bound to zone %s\n", name, zoneName)
+	}
+	return nil
+}
+
+// unbindInterface moves interface back to previous zone, or removes it from zone when not in any zone before
+func (firewall *FirewalldFirewall) unbindInterface(operator HostOperator, name, previous string) error {
+	if "" == previous {
+		return operator.Execute("firewall-cmd", "--permanent", "--zone="+firewall.zone(), "--remove-interface="+name)
+	}
+	return operator.Execute("firewall-cmd", "--permanent", "--zone="+previous, "--change-interface="+name)
+}
+
+// Remove deletes rules recorded in session when added, rules of ranges removed only for installation without manifest
+func (firewall *FirewalldFirewall) Remove(ranges []PortRange) (err error) {
+	var zoneName = firewall.zone()
+	var zone = "--zone=" + zoneName
+	var removed = 0
+	var options = firewall.session.FirewallRules
+	var legacy = "" == firewall.session.Firewall
+	if legacy {
+		//installed by earlier version, which never records rules
+		fmt.Println("warning: rules added by installer not recorded, remove all rules of port ranges")
+		options, _ = firewall.ruleOptions(ranges)
+		//multicast allowed for any source before sources specified
+		options = append(options, "rich-rule="+firewall.richRule("", nil))
+	}
+	for _, option := range options {
+		if exists, _ := queryCommand("firewall-cmd", "--permanent", zone, "--query-"+option); !exists {
+			continue
+		}
+		if err = host.Execute("firewall-cmd", "--permanent", zone, "--remove-"+option); err != nil {
+			return
+		}
+		removed++
+	}
+	//added by direct interface before
+	var legacyRule = append([]string{"--permanent", "--direct", "--query-rule"}, firewalldLegacyMulticastRule...)
+	if exists, _ := queryCommand("firewall-cmd", legacyRule...); legacy && exists {
+		if err = host.Execute("firewall-cmd", append([]string{"--permanent", "--direct", "--remove-rule"}, firewalldLegacyMulticastRule...)...); err != nil {
+			return
+		}
+		removed++
+	}
+	var interfaces []string
+	for name := range firewall.session.FirewallInterfaces {
+		interfaces = append(interfaces, name)
+	}
+	sort.Strings(interfaces)
+	for _, name := range interfaces {
+		var previous = firewall.session.FirewallInterfaces[name]
+		if err = firewall.unbindInterface(host, name, previous); err != nil {
+			fmt.Printf("warning: unbind interface %s from zone %s fail: %s\n", name, zoneName, err.Error())
+			continue
+		}
+		if "" == previous {
+			fmt.Printf("interface %s unbound from zone %s\n", name, zoneName)
+		} else {
+			fmt.Printf("interface %s restored to zone %s\n", name, previous)
+		}
+	}
+	if firewall.session.FirewallZoneCreated {
+		if err = host.Execute("firewall-cmd", "--permanent", "--delete-zone="+zoneName); err != nil {
+			return
+		}
+		fmt.Printf("firewall zone %s deleted\n", zoneName)
+	}
+	if err = host.Execute("firewall-cmd", "--reload"); err != nil {
+		return
+	}
+	fmt.Printf("%d rule(s) removed from firewalld zone %s\n", removed, zoneName)
+	return nil
+}
+
+// OpenedPorts returns ports opened in zone, including those opened only for sources by rich rule
+func (firewall *FirewalldFirewall) OpenedPorts() (opened map[string]bool, err error) {
+	var zone = "--zone=" + firewall.zone()
+	var output []byte
+	if output, err = exec.Command("firewall-cmd", zone, "--list-ports").Output(); err != nil {
+		return
+	}
+	opened = map[string]bool{}
+	for _, ports := range strings.Fields(string(output)) {
+		opened[ports] = true
+	}
+	if output, err = exec.Command("firewall-cmd", zone, "--list-rich-rules").Output(); err != nil {
+		return
+	}
+	var pattern = regexp.MustCompile(`port port="([^"]+)" protocol="([^"]+)"`)
+	for _, matched := range pattern.FindAllStringSubmatch(string(output), -1) {
+		opened[matched[1]+"/"+matched[2]] = true
+	}
+	return opened, nil
+}
+
+// managementInterface returns interface holding address, or interface of default route when address not specified
+func managementInterface(address string) (name string, err error) {
+	if "" == address {
+		return defaultRouteInterface()
+	}
+	var interfaces []net.Interface
+	if interfaces, err = net.Interfaces(); err != nil {
+		return
+	}
+	for _, current := range interfaces {
+		var addresses []net.Addr
+		if addresses, err = current.Addrs(); err != nil {
+			return
+		}
+		for _, value := range addresses {
+			if ipNet, ok := value.(*net.IPNet); ok && ipNet.IP.String() == address {
+				return current.Name, nil
+			}
+		}
+	}
+	err = fmt.Errorf("no interface holds address %s", address)
+	return
+}
+
+// NftablesFirewall inserts rules into an existing input chain, identified by comment.
+// Table and chain created when not exist, recorded in session so that deleted when removing.
+// Rules also saved in a separated file, which included by nftables service configure for persistence
+type NftablesFirewall struct {
+	Family   string
+	Table    string
+	Chain    string
+	RuleFile string
+	session  *SessionInfo
+}
+
+var nftablesServiceConfigs = []string{"/etc/sysconfig/nftables.conf", "/etc/nftables.conf"}
+
+func newNftablesFirewall(session *SessionInfo) *NftablesFirewall {
+	return &NftablesFirewall{"inet", "filter", "input", "/etc/nftables/nano.nft", session}
+}
+
+func (firewall *NftablesFirewall) Name() string {
+	return FirewallNftables
+}
+
+func (firewall *NftablesFirewall) Ready() (err error) {
+	if _, err = exec.LookPath("nft"); err != nil {
+		return
+	}
+	if enabled, _ := services.IsEnabled(FirewallNftables); !enabled {
+		fmt.Println("warning: nftables service disabled, rules lost after reboot")
+	}
+	return nil
+}
+
+func (firewall *NftablesFirewall) chainDeclaration() []string {
+	return []string{"chain", firewall.Family, firewall.Table, firewall.Chain, "{ type filter hook input priority 0 ; policy accept ; }"}
+}
+
+// ruleStatements returns statements of rule keyed by name
+func (firewall *NftablesFirewall) ruleStatements(ranges []PortRange) (names []string, statements map[string]string) {
+	names = []string{MulticastRuleName}
+	statements = map[string]string{MulticastRuleName: "meta pkttype multicast accept"}
+	for _, config := range ranges {
+		var name = config.String()
+		var ports = fmt.Sprintf("%d-%d", config.Begin, config.End)
+		if config.Begin == config.End {
+			ports = fmt.Sprintf("%d", config.Begin)
+		}
+		names = append(names, name)
+		statements[name] = fmt.Sprintf("%s dport %s accept", config.Protocol, ports)
+	}
+	return
+}
+
+// listRules returns handles of rules added by installer, keyed by name in comment
+func (firewall *NftablesFirewall) listRules() (handles map[string]string, chainExists bool, err error) {
+	var pattern = regexp.MustCompile(`comment "` + FirewallCommentPrefix + `([^"]+)".*# handle (\d+)`)
+	handles = map[string]string{}
+	output, listError := exec.Command("nft", "-a", "list", "chain", firewall.Family, firewall.Table, firewall.Chain).Output()
+	if listError != nil {
+		//chain not exists
+		return handles, false, nil
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if matched := pattern.FindStringSubmatch(line); nil != matched {
+			handles[matched[1]] = matched[2]
+		}
+	}
+	return handles, true, nil
+}
+
+func (firewall *NftablesFirewall) Apply(ranges []PortRange) (err error) {
+	var handles map[string]string
+	var chainExists bool
+	if handles, chainExists, err = firewall.listRules(); err != nil {
+		return
+	}
+	var createChain = !chainExists
+	if !chainExists {
+		if err = firewall.createChain(); err != nil {
+			return
+		}
+	} else if data, readError := host.ReadFile(firewall.RuleFile); nil == readError {
+		//keep chain created by previous installation
+		createChain = strings.Contains(string(data), "add chain")
+		firewall.session.NftablesChainAdded = firewall.session.NftablesChainAdded || createChain
+	}
+	var names, statements = firewall.ruleStatements(ranges)
+	var added = 0
+	for _, name := range names {
+		if _, exists := handles[name]; exists {
+			continue
+		}
+		var args = []string{"insert", "rule", firewall.Family, firewall.Table, firewall.Chain}
+		args = append(args, strings.Fields(statements[name])...)
+		args = append(args, "comment", fmt.Sprintf(`"%s"`, firewallRuleComment(name)))
+		if err = host.Execute("nft", args...); err != nil {
+			return
+		}
+		var ruleName = name
+		registerUndo(fmt.Sprintf("add nftables rule %s", name), func(operator HostOperator) error {
+			return firewall.deleteRule(operator, ruleName)
+		})
+		added++
+	}
+	if err = firewall.saveRules(names, statements, createChain); err != nil {
+		return
+	}
+	fmt.Printf("%d rule(s) added to nftables chain %s\n", added, firewall.Chain)
+	return nil
+}
+
+// createChain creates chain, and table when not exists
+func (firewall *NftablesFirewall) createChain() (err error) {
+	var family, table, chain = firewall.Family, firewall.Table, firewall.Chain
+	if exists, _ := queryCommand("nft", "list", "table", family, table); !exists {
+		if err = host.Execute("nft", "add", "table", family, table); err != nil {
+			return
+		}
+		registerUndo(fmt.Sprintf("create nftables table %s", table), func(operator HostOperator) error {
+			return operator.Execute("nft", "delete", "table", family, table)
+		})
+		firewall.session.NftablesTableAdded = true
+		fmt.Printf("nftables table %s %s created\n", family, table)
+	}
+	if err = host.Execute("nft", append([]string{"add"}, firewall.chainDeclaration()...)...); err != nil {
+		return
+	}
+	registerUndo(fmt.Sprintf("create nftables chain %s", chain), func(operator HostOperator) error {
+		return operator.Execute("nft", "delete", "chain", family, table, chain)
+	})
+	firewall.session.NftablesChainAdded = true
+	fmt.Printf("nftables chain %s created\n", chain)
+	return nil
+}
+
+func (firewall *NftablesFirewall) deleteRule(operator HostOperator, name string) (err error) {
+	var handles map[string]string
+	if handles, _, err = firewall.listRules(); err != nil {
+		return
+	}
+	handle, exists := handles[name]
+	if !exists {
+		return nil
+	}
+	return operator.Execute("nft", "delete", "rule", firewall.Family, firewall.Table, firewall.Chain, "handle", handle)
+}
+
+// saveRules writes rules into rule file, and includes it in service configure
+func (firewall *NftablesFirewall) saveRules(names []string, statements map[string]string, createChain bool) (err error) {
+	var lines = []string{"#generated by nano installer, removed when uninstall"}
+	if createChain {
+		lines = append(lines, fmt.Sprintf("add table %s %s", firewall.Family, firewall.Table))
+		lines = append(lines, "add "+strings.Join(firewall.chainDeclaration(), " "))
+	}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf(`insert rule %s %s %s %s comment "%s"`, firewall.Family, firewall.Table, firewall.Chain,
+			statements[name], firewallRuleComment(name)))
+	}
+	if err = host.WriteFile(firewall.RuleFile, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return
+	}
+	var includeLine = fmt.Sprintf(`include "%s"`, firewall.RuleFile)
+	for _, config := range nftablesServiceConfigs {
+		if !host.Exists(config) {
+			continue
+		}
+		var data []byte
+		if data, err = host.ReadFile(config); err != nil {
+			return
+		}
+		if strings.Contains(string(data), includeLine) {
+			return nil
+		}
+		if err = host.AppendFile(config, []byte(includeLine+"\n"), 0600); err != nil {
+			return
+		}
+		fmt.Printf("rule file '%s' included in '%s'\n", firewall.RuleFile, config)
+		return nil
+	}
+	fmt.Printf("warning: no nftables configure available, include '%s' manually for persistence\n", firewall.RuleFile)
+	return nil
+}
+
+func (firewall *NftablesFirewall) Remove(ranges []PortRange) (err error) {
+	var names, _ = firewall.ruleStatements(ranges)
+	var removed = 0
+	var handles map[string]string
+	if handles, _, err = firewall.listRules(); err != nil {
+		return
+	}
+	for _, name := range names {
+		handle, exists := handles[name]
+		if !exists {
+			continue
+		}
+		if err = host.Execute("nft", "delete", "rule", firewall.Family, firewall.Table, firewall.Chain, "handle", handle); err != nil {
+			return
+		}
+		removed++
+	}
+	var chainCreated = firewall.session.NftablesChainAdded
+	if data, readError := host.ReadFile(firewall.RuleFile); nil == readError {
+		//chain created by installation without manifest
+		chainCreated = chainCreated || strings.Contains(string(data), "add chain")
+		if err = host.Remove(firewall.RuleFile); err != nil {
+			return
+		}
+	}
+	if firewall.session.NftablesTableAdded {
+		if err = host.Execute("nft", "delete", "table", firewall.Family, firewall.Table); err != nil {
+			fmt.Printf("warning: delete nftables table %s fail: %s\n", firewall.Table, err.Error())
+		} else {
+			fmt.Printf("nftables table %s %s deleted\n", firewall.Family, firewall.Table)
+		}
+	} else if chainCreated {
+		//fail when rules added by others
+		if err = host.Execute("nft", "delete", "chain", firewall.Family, firewall.Table, firewall.Chain); err != nil {
+			fmt.Printf("warning: delete nftables chain %s fail: %s\n", firewall.Chain, err.Error())
+		} else {
+			fmt.Printf("nftables chain %s deleted\n", firewall.Chain)
+		}
+	}
+	var includeLine = fmt.Sprintf(`include "%s"`, firewall.RuleFile)
+	for _, config := range nftablesServiceConfigs {
+		if !host.Exists(config) {
+			continue
+		}
+		var data []byte
+		if data, err = host.ReadFile(config); err != nil {
+			return
+		}
+		var content = strings.Replace(string(data), includeLine+"\n", "", -1)
+		if content != string(data) {
+			if err = host.WriteFile(config, []byte(content), 0600); err != nil {
+				return
+			}
+		}
+	}
+	fmt.Printf("%d rule(s) removed from nftables chain %s\n", removed, firewall.Chain)
+	return nil
+}
+
+func (firewall *NftablesFirewall) OpenedPorts() (opened map[string]bool, err error) {
+	var handles map[string]string
+	if handles, _, err = firewall.listRules(); err != nil {
+		return
+	}
+	opened = map[string]bool{}
+	for name := range handles {
+		opened[name] = true
+	}
+	return opened, nil
+}
+
+// IptablesFirewall inserts rules identified by comment into a chain, only those rules merged into iptables service configure for persistence
+type IptablesFirewall struct {
+	Chain string
+}
+
+var iptablesServiceConfigs = []string{"/etc/sysconfig/iptables", "/etc/iptables/rules.v4"}
+
+func (firewall *IptablesFirewall) Name() string {
+	return FirewallIptables
+}
+
+func (firewall *IptablesFirewall) Ready() (err error) {
+	_, err = exec.LookPath("iptables")
+	return
+}
+
+// ruleSpecs returns specification of rule keyed by name
+func (firewall *IptablesFirewall) ruleSpecs(ranges []PortRange) (names []string, specs map[string][]string) {
+	names = []string{MulticastRuleName}
+	specs = map[string][]string{MulticastRuleName: {"-m", "pkttype", "--pkt-type", "multicast"}}
+	for _, config := range ranges {
+		var name = config.String()
+		var ports = fmt.Sprintf("%d:%d", config.Begin, config.End)
+		if config.Begin == config.End {
+			ports = fmt.Sprintf("%d", config.Begin)
+		}
+		names = append(names, name)
+		specs[name] = []string{"-p", config.Protocol, "-m", config.Protocol, "--dport", ports}
+	}
+	for name, spec := range specs {
+		specs[name] = append(spec, "-m", "comment", "--comment", firewallRuleComment(name), "-j", "ACCEPT")
+	}
+	return
+}
+
+func (firewall *IptablesFirewall) Apply(ranges []PortRange) (err error) {
+	var names, specs = firewall.ruleSpecs(ranges)
+	var added = 0
+	for _, name := range names {
+		var spec = specs[name]
+		var exists bool
+		if exists, err = queryCommand("iptables", append([]string{"-C", firewall.Chain}, spec...)...); err != nil {
+			return
+		} else if exists {
+			continue
+		}
+		if err = host.Execute("iptables", append([]string{"-I", firewall.Chain}, spec...)...); err != nil {
+			return
+		}
+		var chain = firewall.Chain
+		registerUndo(fmt.Sprintf("add iptables rule %s", name), func(operator HostOperator) error {
+			return operator.Execute("iptables", append([]string{"-D", chain}, spec...)...)
+		})
+		added++
+	}
+	if err = firewall.saveRules(); err != nil {
+		return
+	}
+	fmt.Printf("%d rule(s) added to iptables chain %s\n", added, firewall.Chain)
+	return nil
+}
+
+func (firewall *IptablesFirewall) Remove(ranges []PortRange) (err error) {
+	var names, specs = firewall.ruleSpecs(ranges)
+	var removed = 0
+	for _, name := range names {
+		var spec = specs[name]
+		if exists, _ := queryCommand("iptables", append([]string{"-C", firewall.Chain}, spec...)...); !exists {
+			continue
+		}
+		if err = host.Execute("iptables", append([]string{"-D", firewall.Chain}, spec...)...); err != nil {
+			return
+		}
+		removed++
+	}
+	if err = firewall.saveRules(); err != nil {
+		return
+	}
+	fmt.Printf("%d rule(s) removed from iptables chain %s\n", removed, firewall.Chain)
+	return nil
+}
+
+// iptablesRulePattern matches rules added by installer, in output of iptables -S or configure of iptables service
+var iptablesRulePattern = regexp.MustCompile(`--comment "?` + FirewallCommentPrefix)
+
+// saveRules writes rules added by installer in chain to configure of iptables service, other rules in configure kept untouched
+func (firewall *IptablesFirewall) saveRules() (err error) {
+	for _, config := range iptablesServiceConfigs {
+		if !host.Exists(config) {
+			continue
+		}
+		var output []byte
+		if output, err = exec.Command("iptables", "-S", firewall.Chain).Output(); err != nil {
+			return
+		}
+		var rules []string
+		for _, line := range strings.Split(string(output), "\n") {
+			if iptablesRulePattern.MatchString(line) {
+				rules = append(rules, line)
+			}
+		}
+		var data []byte
+		if data, err = host.ReadFile(config); err != nil {
+			return
+		}
+		var content = mergeIptablesRules(string(data), rules)
+		if content == string(data) {
+			return nil
+		}
+		if err = host.WriteFile(config, []byte(content), 0600); err != nil {
+			return
+		}
+		fmt.Printf("%d iptables rule(s) saved to '%s'\n", len(rules), config)
+		return nil
+	}
+	fmt.Println("warning: no iptables service configure available, rules lost after reboot")
+	return nil
+}
+
+// mergeIptablesRules replaces rules added by installer in saved configure with rules specified,
+// which placed ahead of other rules in filter table, and filter table appended when absent
+func mergeIptablesRules(content string, rules []string) string {
+	var lines []string
+	if "" != content {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	var merged []string
+	var inFilter, inserted = false, 0 == len(rules)
+	for _, line := range lines {
+		if iptablesRulePattern.MatchString(line) {
+			continue
+		}
+		var trimmed = strings.TrimSpace(line)
+		if "*filter" == trimmed {
+			inFilter = true
+		} else if inFilter && !inserted && !strings.HasPrefix(trimmed, ":") && !strings.HasPrefix(trimmed, "#") {
+			//first rule or COMMIT after chain declarations
+			merged = append(merged, rules...)
+			inserted = true
+		}
+		merged = append(merged, line)
+	}
+	if !inserted {
+		merged = append(merged, "*filter")
+		merged = append(merged, rules...)
+		merged = append(merged, "COMMIT")
+	}
+	if 0 == len(merged) {
+		return ""
+	}
+	return strings.Join(merged, "\n") + "\n"
+}
+
+func (firewall *IptablesFirewall) OpenedPorts() (opened map[string]bool, err error) {
+	var pattern = regexp.MustCompile(`--comment "?` + FirewallCommentPrefix + `([^"\s]+)`)
+	var output []byte
+	if output, err = exec.Command("iptables", "-S", firewall.Chain).Output(); err != nil {
+		return
+	}
+	opened = map[string]bool{}
+	for _, matched := range pattern.FindAllStringSubmatch(string(output), -1) {
+		opened[matched[1]] = true
+	}
+	return opened, nil
+}
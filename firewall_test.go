@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestMergeIptablesRules(t *testing.T) {
+	const (
+		multicastRule = `-A INPUT -m pkttype --pkt-type multicast -m comment --comment nano:multicast -j ACCEPT`
+		portRule      = `-A INPUT -p tcp -m tcp --dport 5870 -m comment --comment "nano:5870/tcp" -j ACCEPT`
+	)
+	var cases = []struct {
+		name     string
+		content  string
+		rules    []string
+		expected string
+	}{
+		{"insert ahead of existing rules",
+			"# saved\n*nat\n:PREROUTING ACCEPT [0:0]\n-A PREROUTING -p tcp --dport 80 -j REDIRECT --to-ports 8080\nCOMMIT\n" +
+				"*filter\n:INPUT DROP [0:0]\n:FORWARD ACCEPT [0:0]\n-A INPUT -i lo -j ACCEPT\nCOMMIT\n",
+			[]string{multicastRule, portRule},
+			"# saved\n*nat\n:PREROUTING ACCEPT [0:0]\n-A PREROUTING -p tcp --dport 80 -j REDIRECT --to-ports 8080\nCOMMIT\n" +
+				"*filter\n:INPUT DROP [0:0]\n:FORWARD ACCEPT [0:0]\n" + multicastRule + "\n" + portRule + "\n-A INPUT -i lo -j ACCEPT\nCOMMIT\n"},
+		{"replace previous rules",
+			"*filter\n:INPUT ACCEPT [0:0]\n" + portRule + "\n-A INPUT -i lo -j ACCEPT\nCOMMIT\n",
+			[]string{multicastRule},
+			"*filter\n:INPUT ACCEPT [0:0]\n" + multicastRule + "\n-A INPUT -i lo -j ACCEPT\nCOMMIT\n"},
+		{"remove all rules",
+			"*filter\n:INPUT ACCEPT [0:0]\n" + multicastRule + "\n" + portRule + "\nCOMMIT\n",
+			nil,
+			"*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n"},
+		{"empty filter table",
+			"*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n",
+			[]string{portRule},
+			"*filter\n:INPUT ACCEPT [0:0]\n" + portRule + "\nCOMMIT\n"},
+		{"append filter table",
+			"*nat\n:PREROUTING ACCEPT [0:0]\nCOMMIT\n",
+			[]string{portRule},
+			"*nat\n:PREROUTING ACCEPT [0:0]\nCOMMIT\n*filter\n" + portRule + "\nCOMMIT\n"},
+		{"empty configure", "", []string{portRule}, "*filter\n" + portRule + "\nCOMMIT\n"},
+		{"nothing to save", "", nil, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if merged := mergeIptablesRules(c.content, c.rules); c.expected != merged {
+				t.Fatalf("expect:\n%s\nbut got:\n%s", c.expected, merged)
+			}
+		})
+	}
+}
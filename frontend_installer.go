@@ -4,9 +4,7 @@ import (
 	"path/filepath"
 	"os"
 	"fmt"
-	"github.com/project-nano/framework"
 	"encoding/json"
-	"io/ioutil"
 )
 
 const (
@@ -50,9 +48,15 @@ func FrontendInstaller(session *SessionInfo) (ranges []PortRange, err error){
 	if err = writeFrontEndConfig(session, configPath);err != nil{
 		return
 	}
-	ranges = []PortRange{{PortalPortBegin, PortalPortEnd, "tcp"}}
+	if _, _, err = issueModuleCertificate(session, PortalCertificate);err != nil{
+		return
+	}
 	fmt.Println("frontend module installed")
-	return ranges, nil
+	return frontendPortRanges(), nil
+}
+
+func frontendPortRanges() []PortRange {
+	return []PortRange{{PortalPortBegin, PortalPortEnd, "tcp"}}
 }
 
 func copyResources(session *SessionInfo, workingPath string) (err error){
@@ -79,7 +83,7 @@ func writeFrontEndConfig(session *SessionInfo, configPath string) (err error){
 	}
 
 	var configFile = filepath.Join(configPath, ConfigFileName)
-	if _, err = os.Stat(configFile); os.IsNotExist(err) {
+	if !host.Exists(configFile) {
 		fmt.Println("No configures available, following instructions to generate a new one.")
 
 		var config = FrontEndConfig{}
@@ -87,22 +91,23 @@ func writeFrontEndConfig(session *SessionInfo, configPath string) (err error){
 			config.ListenAddress = session.LocalAddress
 			fmt.Printf("using %s as portal listen address\n", session.LocalAddress)
 		}else{
-			config.ListenAddress, err = framework.ChooseIPV4Address("Portal listen address")
+			config.ListenAddress, err = prompter.ChooseIPV4Address(AnswerListenAddress, "Portal listen address")
 			if err != nil{
 				return
 			}
 			session.LocalAddress = config.ListenAddress
 		}
-		if config.ListenPort, err = framework.InputNetworkPort(fmt.Sprintf("Portal listen port (%d ~ %d)", PortalPortBegin, PortalPortEnd),
+		if config.ListenPort, err = prompter.InputNetworkPort(AnswerPortalPort, fmt.Sprintf("Portal listen port (%d ~ %d)", PortalPortBegin, PortalPortEnd),
 			DefaultFrontEndPort); err !=nil{
 			return
 		}
+		session.PortalPort = config.ListenPort
 		if session.APIAddress != ""{
 			//same host
 			config.ServiceHost = session.APIAddress
 			fmt.Printf("using %s as api address\n", session.APIAddress)
 		}else{
-			if config.ServiceHost, err = framework.InputIPAddress("Backend API Host Address", config.ListenAddress); err !=nil{
+			if config.ServiceHost, err = prompter.InputIPAddress(AnswerAPIAddress, "Backend API Host Address", config.ListenAddress); err !=nil{
 				return
 			}
 			session.APIAddress = config.ServiceHost
@@ -112,7 +117,7 @@ func writeFrontEndConfig(session *SessionInfo, configPath string) (err error){
 			config.ServicePort = session.APIPort
 			fmt.Printf("using %d as backend api port\n", session.APIPort)
 		}else{
-			if config.ServicePort, err = framework.InputNetworkPort("Backend API port", DefaultBackEndPort); err != nil{
+			if config.ServicePort, err = prompter.InputNetworkPort(AnswerAPIPort, "Backend API port", DefaultBackEndPort); err != nil{
 				return
 			}
 			session.APIPort = config.ServicePort
@@ -123,10 +128,39 @@ func writeFrontEndConfig(session *SessionInfo, configPath string) (err error){
 		if err != nil {
 			return err
 		}
-		if err = ioutil.WriteFile(configFile, data, DefaultFilePerm); err != nil {
+		if err = host.WriteFile(configFile, data, DefaultFilePerm); err != nil {
 			return err
 		}
 		fmt.Printf("default configure '%s' generated\n", configFile)
+	}else if "" == session.LocalAddress{
+		//listen address required by certificates
+		if session.LocalAddress, err = readFrontEndListenAddress(configPath); err != nil{
+			return
+		}
+		fmt.Printf("using listen address %s in '%s'\n", session.LocalAddress, configFile)
 	}
 	return
 }
+
+// readFrontEndListenAddress returns listen address of portal in configure, empty when configure not exists
+func readFrontEndListenAddress(configPath string) (address string, err error){
+	const (
+		ConfigFileName = "frontend.cfg"
+	)
+	var config struct {
+		ListenAddress string `json:"address"`
+	}
+	var configFile = filepath.Join(configPath, ConfigFileName)
+	if !host.Exists(configFile) {
+		return "", nil
+	}
+	var data []byte
+	if data, err = host.ReadFile(configFile); err != nil{
+		return
+	}
+	if err = json.Unmarshal(data, &config); err != nil{
+		err = fmt.Errorf("invalid frontend configure '%s': %s", configFile, err.Error())
+		return
+	}
+	return config.ListenAddress, nil
+}
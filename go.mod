@@ -7,6 +7,9 @@ require (
 	github.com/project-nano/framework v1.0.9
 	github.com/project-nano/sonar v0.0.0-20190628085230-df7942628d6f
 	github.com/vishvananda/netlink v1.1.0
+	golang.org/x/crypto v0.15.0
+	golang.org/x/sys v0.14.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -20,7 +23,5 @@ require (
 	github.com/vishvananda/netns v0.0.4 // indirect
 	github.com/xtaci/kcp-go v5.4.20+incompatible // indirect
 	github.com/xtaci/lossyconn v0.0.0-20200209145036-adba10fffc37 // indirect
-	golang.org/x/crypto v0.15.0 // indirect
 	golang.org/x/net v0.18.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
 )
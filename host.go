@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// HostOperator applies every modification on the host, including files, commands and links.
+// Querying the host is not a modification, so it is not required to go through the operator
+type HostOperator interface {
+	ReadFile(filename string) ([]byte, error)
+	Exists(path string) bool
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	AppendFile(filename string, data []byte, perm os.FileMode) error
+	CopyFile(source, target string) error
+	MakeDirectory(path string, perm os.FileMode) error
+	Remove(path string) error
+	Chown(path string, uid, gid int) error
+	Chmod(path string, perm os.FileMode) error
+	Execute(name string, args ...string) error
+	ExecuteOutput(name string, args ...string) ([]byte, error)
+	AddBridge(name string) error
+	SetLinkMaster(link, master string) error
+	SetLinkNoMaster(link string) error
+	SetLinkUp(link string) error
+	SetLinkDown(link string) error
+	DeleteLink(link string) error
+}
+
+var host HostOperator = &LocalHost{}
+
+// LocalHost applies modifications to local host directly
+type LocalHost struct {
+}
+
+func (operator *LocalHost) ReadFile(filename string) ([]byte, error) {
+	return ioutil.ReadFile(filename)
+}
+
+func (operator *LocalHost) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// WriteFile replaces file atomically, original file outside project path saved as backup
+func (operator *LocalHost) WriteFile(filename string, data []byte, perm os.FileMode) (err error) {
+	if err = backups.Save(filename); err != nil {
+		return
+	}
+	return replaceFile(filename, data, perm, true)
+}
+
+func (operator *LocalHost) AppendFile(filename string, data []byte, perm os.FileMode) (err error) {
+	var current []byte
+	if current, err = ioutil.ReadFile(filename); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	return operator.WriteFile(filename, append(current, data...), perm)
+}
+
+func (operator *LocalHost) CopyFile(source, target string) (err error) {
+	if err = backups.Save(target); err != nil {
+		return
+	}
+	return copyLocalFile(source, target)
+}
+
+func (operator *LocalHost) MakeDirectory(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (operator *LocalHost) Remove(path string) (err error) {
+	if err = backups.Save(path); err != nil {
+		return
+	}
+	return os.RemoveAll(path)
+}
+
+func (operator *LocalHost) Chown(path string, uid, gid int) error {
+	return os.Chown(path, uid, gid)
+}
+
+func (operator *LocalHost) Chmod(path string, perm os.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+func (operator *LocalHost) Execute(name string, args ...string) error {
+	return executeWithOutput(exec.Command(name, args...))
+}
+
+func (operator *LocalHost) ExecuteOutput(name string, args ...string) (output []byte, err error) {
+	var cmd = exec.Command(name, args...)
+	var buffer bytes.Buffer
+	cmd.Stdout = &buffer
+	if err = cmd.Run(); err != nil {
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+func (operator *LocalHost) AddBridge(name string) error {
+	var attrs = netlink.NewLinkAttrs()
+	attrs.Name = name
+	return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: attrs})
+}
+
+func (operator *LocalHost) SetLinkMaster(link, master string) (err error) {
+	var slave, bridge netlink.Link
+	if slave, err = netlink.LinkByName(link); err != nil {
+		return
+	}
+	if bridge, err = netlink.LinkByName(master); err != nil {
+		return
+	}
+	return netlink.LinkSetMaster(slave, bridge)
+}
+
+func (operator *LocalHost) SetLinkNoMaster(link string) (err error) {
+	var target netlink.Link
+	if target, err = netlink.LinkByName(link); err != nil {
+		return
+	}
+	return netlink.LinkSetNoMaster(target)
+}
+
+func (operator *LocalHost) SetLinkUp(link string) (err error) {
+	var target netlink.Link
+	if target, err = netlink.LinkByName(link); err != nil {
+		return
+	}
+	return netlink.LinkSetUp(target)
+}
+
+func (operator *LocalHost) SetLinkDown(link string) (err error) {
+	var target netlink.Link
+	if target, err = netlink.LinkByName(link); err != nil {
+		return
+	}
+	return netlink.LinkSetDown(target)
+}
+
+func (operator *LocalHost) DeleteLink(link string) (err error) {
+	var target netlink.Link
+	if target, err = netlink.LinkByName(link); err != nil {
+		return
+	}
+	return netlink.LinkDel(target)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// InterfaceConfig is a shell variable file like ifcfg script. Params holds unquoted values,
+// and lines of original file kept, so that only params changed are rewritten
+type InterfaceConfig struct {
+	Params map[string]string
+	lines  []interfaceConfigLine
+}
+
+// interfaceConfigLine is a line of original file, key is empty for blank line, comment or line could not parse.
+// Prefix like indent or "export " and comment after value kept when value changed
+type interfaceConfigLine struct {
+	Text    string
+	Key     string
+	Value   string
+	Quoted  bool
+	Prefix  string
+	Comment string
+}
+
+func readInterfaceConfig(filepath string) (config InterfaceConfig, err error) {
+	var data []byte
+	if data, err = host.ReadFile(filepath); err != nil {
+		return
+	}
+	config = parseInterfaceConfig(data, filepath)
+	fmt.Printf("%d params loaded from '%s'\n", len(config.Params), filepath)
+	return config, nil
+}
+
+func parseInterfaceConfig(data []byte, source string) (config InterfaceConfig) {
+	config.Params = map[string]string{}
+	var content = strings.TrimSuffix(string(data), "\n")
+	if "" == content {
+		return config
+	}
+	for index, text := range strings.Split(content, "\n") {
+		var line = interfaceConfigLine{Text: text}
+		var trimmed = strings.TrimSpace(text)
+		if "" != trimmed && !strings.HasPrefix(trimmed, "#") {
+			var err error
+			if line.Key, line.Value, line.Quoted, line.Comment, err = parseShellAssignment(trimmed); err != nil {
+				fmt.Printf("ignore line %d of '%s': %s\n", index+1, source, err.Error())
+				line.Key = ""
+			} else {
+				line.Prefix = text[:strings.Index(text, line.Key+"=")]
+				config.Params[line.Key] = line.Value
+			}
+		}
+		config.lines = append(config.lines, line)
+	}
+	return config
+}
+
+// parseShellAssignment parses line like KEY=value, KEY="quoted value" or KEY='literal', and comment after value
+// returned with spaces before it
+func parseShellAssignment(line string) (key, value string, quoted bool, comment string, err error) {
+	line = strings.TrimPrefix(line, "export ")
+	var index = strings.Index(line, "=")
+	if index <= 0 {
+		err = errors.New("not an assignment")
+		return
+	}
+	key = line[:index]
+	for position, char := range key {
+		var letter = '_' == char || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z')
+		if !letter && (0 == position || char < '0' || char > '9') {
+			err = fmt.Errorf("invalid name '%s'", key)
+			return
+		}
+	}
+	var buffer bytes.Buffer
+	var input = line[index+1:]
+	for offset := 0; offset < len(input); offset++ {
+		var char = input[offset]
+		switch char {
+		case '\'':
+			var end = strings.IndexByte(input[offset+1:], '\'')
+			if -1 == end {
+				err = errors.New("unterminated single quote")
+				return
+			}
+			buffer.WriteString(input[offset+1 : offset+1+end])
+			offset += end + 1
+			quoted = true
+		case '"':
+			quoted = true
+			var closed = false
+			for offset++; offset < len(input); offset++ {
+				char = input[offset]
+				if '"' == char {
+					closed = true
+					break
+				}
+				if '\\' == char && offset+1 < len(input) && strings.IndexByte("\\\"$`", input[offset+1]) >= 0 {
+					offset++
+					char = input[offset]
+				}
+				buffer.WriteByte(char)
+			}
+			if !closed {
+				err = errors.New("unterminated double quote")
+				return
+			}
+		case '\\':
+			if offset+1 < len(input) {
+				offset++
+				buffer.WriteByte(input[offset])
+			}
+		case ' ', '\t':
+			//only comment allowed after value
+			if rest := strings.TrimSpace(input[offset:]); "" != rest && !strings.HasPrefix(rest, "#") {
+				err = fmt.Errorf("unexpected '%s' after value", rest)
+				return
+			}
+			return key, buffer.String(), quoted, strings.TrimRight(input[offset:], " \t"), nil
+		default:
+			buffer.WriteByte(char)
+		}
+	}
+	return key, buffer.String(), quoted, "", nil
+}
+
+// formatShellValue quotes value when containing characters special to shell, or when quoted originally
+func formatShellValue(value string, quoted bool) string {
+	const (
+		PlainCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.:/,@%+="
+	)
+	if !quoted && "" != value && "" == strings.Trim(value, PlainCharacters) {
+		return value
+	}
+	var replacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// formatInterfaceConfig keeps original lines of params unchanged, rewrites value of params changed with prefix and comment kept,
+// removes lines of params deleted, and appends params added in order of name
+func formatInterfaceConfig(config InterfaceConfig) []byte {
+	var lastLine = map[string]int{}
+	for index, line := range config.lines {
+		if "" != line.Key {
+			lastLine[line.Key] = index
+		}
+	}
+	var content bytes.Buffer
+	for index, line := range config.lines {
+		if "" == line.Key {
+			content.WriteString(line.Text + "\n")
+			continue
+		}
+		value, exists := config.Params[line.Key]
+		if !exists {
+			continue
+		}
+		if lastLine[line.Key] != index || value == line.Value {
+			//earlier assignments overridden by the last one
+			content.WriteString(line.Text + "\n")
+			continue
+		}
+		fmt.Fprintf(&content, "%s%s=%s%s\n", line.Prefix, line.Key, formatShellValue(value, line.Quoted), line.Comment)
+	}
+	var added []string
+	for name := range config.Params {
+		if _, exists := lastLine[name]; !exists {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		fmt.Fprintf(&content, "%s=%s\n", name, formatShellValue(config.Params[name], false))
+	}
+	return content.Bytes()
+}
+
+func writeInterfaceConfig(config InterfaceConfig, filepath string) (err error) {
+	return host.WriteFile(filepath, formatInterfaceConfig(config), 0644)
+}
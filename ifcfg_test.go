@@ -0,0 +1,242 @@
+package main
+
+import "testing"
+
+func TestFormatInterfaceConfig(t *testing.T) {
+	var cases = []struct {
+		name   string
+		input  string
+		params map[string]string
+		change map[string]string
+		remove []string
+		output string
+	}{
+		{"quoted value with space",
+			"TYPE=Ethernet\nNAME=\"System eth0\"\nDEVICE=eth0\n",
+			map[string]string{"TYPE": "Ethernet", "NAME": "System eth0", "DEVICE": "eth0"},
+			map[string]string{"NAME": "System br0"}, nil,
+			"TYPE=Ethernet\nNAME=\"System br0\"\nDEVICE=eth0\n"},
+		{"quoted value with equal sign",
+			"ETHTOOL_OPTS=\"-K eth0 gro=off\"\n",
+			map[string]string{"ETHTOOL_OPTS": "-K eth0 gro=off"},
+			map[string]string{"ETHTOOL_OPTS": "-K eth0 gro=on"}, nil,
+			"ETHTOOL_OPTS=\"-K eth0 gro=on\"\n"},
+		{"single quote and escapes",
+			"DESCRIPTION='uplink $1'\nSCRIPT=\"echo \\\"a\\\\b\\\" \\$HOME\"\nLABEL=my\\ nic\n",
+			map[string]string{"DESCRIPTION": "uplink $1", "SCRIPT": `echo "a\b" $HOME`, "LABEL": "my nic"},
+			map[string]string{"DESCRIPTION": "uplink $2", "LABEL": "new nic"}, nil,
+			"DESCRIPTION=\"uplink \\$2\"\nSCRIPT=\"echo \\\"a\\\\b\\\" \\$HOME\"\nLABEL=\"new nic\"\n"},
+		{"trailing comment kept",
+			"BOOTPROTO=dhcp # managed by dhclient\nONBOOT=yes\t# boot\n",
+			map[string]string{"BOOTPROTO": "dhcp", "ONBOOT": "yes"},
+			map[string]string{"BOOTPROTO": "none"}, nil,
+			"BOOTPROTO=none # managed by dhclient\nONBOOT=yes\t# boot\n"},
+		{"last duplicate key rewritten",
+			"ONBOOT=no\n# override\nONBOOT=yes\n",
+			map[string]string{"ONBOOT": "yes"},
+			map[string]string{"ONBOOT": "no"}, nil,
+			"ONBOOT=no\n# override\nONBOOT=no\n"},
+		{"export prefix kept",
+			"export MTU=1500\n  export ZONE=public # firewall\n",
+			map[string]string{"MTU": "1500", "ZONE": "public"},
+			map[string]string{"MTU": "9000", "ZONE": "trusted"}, nil,
+			"export MTU=9000\n  export ZONE=trusted # firewall\n"},
+		{"unterminated quote kept",
+			"NAME=\"eth0\nDEVICE=eth0\n",
+			map[string]string{"DEVICE": "eth0"},
+			map[string]string{"DEVICE": "eth1"}, nil,
+			"NAME=\"eth0\nDEVICE=eth1\n"},
+		{"params removed and added",
+			"# Generated\nIPADDR=192.168.1.2\nPREFIX=24\nDEVICE=eth0\n",
+			map[string]string{"IPADDR": "192.168.1.2", "PREFIX": "24", "DEVICE": "eth0"},
+			map[string]string{"BRIDGE": "br0", "BOOTPROTO": "none"}, []string{"IPADDR", "PREFIX"},
+			"# Generated\nDEVICE=eth0\nBOOTPROTO=none\nBRIDGE=br0\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var config = parseInterfaceConfig([]byte(c.input), c.name)
+			if len(c.params) != len(config.Params) {
+				t.Fatalf("expect params %v, but got %v", c.params, config.Params)
+			}
+			for key, value := range c.params {
+				if current, exists := config.Params[key]; !exists || value != current {
+					t.Fatalf("expect %s='%s', but got '%s'", key, value, current)
+				}
+			}
+			//unchanged config written byte for byte
+			if output := string(formatInterfaceConfig(config)); c.input != output {
+				t.Fatalf("unchanged config expect:\n%s\nbut got:\n%s", c.input, output)
+			}
+			for key, value := range c.change {
+				config.Params[key] = value
+			}
+			for _, key := range c.remove {
+				delete(config.Params, key)
+			}
+			if output := string(formatInterfaceConfig(config)); c.output != output {
+				t.Fatalf("expect:\n%s\nbut got:\n%s", c.output, output)
+			}
+		})
+	}
+}
+
+// real scripts generated by anaconda and NetworkManager, unchanged lines must be written byte for byte
+func TestInterfaceConfigSamples(t *testing.T) {
+	const (
+		anaconda = "# Generated by parse-kickstart\n" +
+			"TYPE=\"Ethernet\"\n" +
+			"PROXY_METHOD=\"none\"\n" +
+			"BROWSER_ONLY=\"no\"\n" +
+			"BOOTPROTO=\"none\"\n" +
+			"DEFROUTE=\"yes\"\n" +
+			"IPV4_FAILURE_FATAL=\"no\"\n" +
+			"IPV6INIT=\"yes\"\n" +
+			"IPV6_AUTOCONF=\"yes\"\n" +
+			"IPV6_DEFROUTE=\"yes\"\n" +
+			"IPV6_FAILURE_FATAL=\"no\"\n" +
+			"IPV6_ADDR_GEN_MODE=\"stable-privacy\"\n" +
+			"NAME=\"eno1\"\n" +
+			"UUID=\"5fb06bd0-0bb0-7ffb-45f1-d6edd65f3e03\"\n" +
+			"DEVICE=\"eno1\"\n" +
+			"ONBOOT=\"yes\"\n" +
+			"IPADDR=\"192.168.10.21\"\n" +
+			"PREFIX=\"24\"\n" +
+			"GATEWAY=\"192.168.10.1\"\n" +
+			"DNS1=\"192.168.10.1\"\n" +
+			"DOMAIN=\"lab.example.com\"\n" +
+			"IPV6_PRIVACY=\"no\"\n"
+		bond = "# bond of two uplinks, LACP required on switch\n" +
+			"DEVICE=bond0\n" +
+			"NAME=bond0\n" +
+			"TYPE=Bond\n" +
+			"BONDING_MASTER=yes\n" +
+			"BONDING_OPTS=\"mode=802.3ad miimon=100 lacp_rate=fast xmit_hash_policy=layer3+4\"\n" +
+			"BOOTPROTO=none\n" +
+			"IPADDR=10.0.0.5\n" +
+			"NETMASK=255.255.255.0\n" +
+			"GATEWAY=10.0.0.1 # core switch\n" +
+			"IPV6INIT=no\n" +
+			"ONBOOT=yes\n" +
+			"UUID=ad33d8b0-1f7b-cab9-9447-ba07f855b143\n"
+		vlan = "VLAN=yes\n" +
+			"TYPE=Vlan\n" +
+			"PHYSDEV=bond0\n" +
+			"VLAN_ID=100\n" +
+			"REORDER_HDR=yes\n" +
+			"GVRP=no\n" +
+			"MVRP=no\n" +
+			"HWADDR=\n" +
+			"PROXY_METHOD=none\n" +
+			"BROWSER_ONLY=no\n" +
+			"BOOTPROTO=dhcp\n" +
+			"DEFROUTE=yes\n" +
+			"IPV4_FAILURE_FATAL=no\n" +
+			"IPV6INIT=yes\n" +
+			"IPV6_AUTOCONF=yes\n" +
+			"IPV6_DEFROUTE=yes\n" +
+			"IPV6_FAILURE_FATAL=no\n" +
+			"NAME=\"Vlan bond0.100\"\n" +
+			"UUID=3b8e5b7a-2c6f-4a1e-9d0c-8f5e2a7d9b41\n" +
+			"DEVICE=bond0.100\n" +
+			"ONBOOT=yes\n" +
+			"ZONE=internal\n"
+	)
+	var cases = []struct {
+		name     string
+		input    string
+		params   map[string]string
+		remove   []string
+		change   map[string]string
+		expected string
+	}{
+		{"anaconda ethernet", anaconda,
+			map[string]string{"TYPE": "Ethernet", "UUID": "5fb06bd0-0bb0-7ffb-45f1-d6edd65f3e03", "IPV6INIT": "yes",
+				"IPADDR": "192.168.10.21", "DOMAIN": "lab.example.com"},
+			[]string{"IPADDR", "PREFIX", "GATEWAY", "DNS1", "DOMAIN"},
+			map[string]string{"BOOTPROTO": "none", "BRIDGE": "br0"},
+			"# Generated by parse-kickstart\n" +
+				"TYPE=\"Ethernet\"\n" +
+				"PROXY_METHOD=\"none\"\n" +
+				"BROWSER_ONLY=\"no\"\n" +
+				"BOOTPROTO=\"none\"\n" +
+				"DEFROUTE=\"yes\"\n" +
+				"IPV4_FAILURE_FATAL=\"no\"\n" +
+				"IPV6INIT=\"yes\"\n" +
+				"IPV6_AUTOCONF=\"yes\"\n" +
+				"IPV6_DEFROUTE=\"yes\"\n" +
+				"IPV6_FAILURE_FATAL=\"no\"\n" +
+				"IPV6_ADDR_GEN_MODE=\"stable-privacy\"\n" +
+				"NAME=\"eno1\"\n" +
+				"UUID=\"5fb06bd0-0bb0-7ffb-45f1-d6edd65f3e03\"\n" +
+				"DEVICE=\"eno1\"\n" +
+				"ONBOOT=\"yes\"\n" +
+				"IPV6_PRIVACY=\"no\"\n" +
+				"BRIDGE=br0\n"},
+		{"bond with options", bond,
+			map[string]string{"BONDING_OPTS": "mode=802.3ad miimon=100 lacp_rate=fast xmit_hash_policy=layer3+4",
+				"GATEWAY": "10.0.0.1", "IPV6INIT": "no"},
+			[]string{"IPADDR", "NETMASK"},
+			map[string]string{"GATEWAY": "10.0.0.254", "BRIDGE": "br0"},
+			"# bond of two uplinks, LACP required on switch\n" +
+				"DEVICE=bond0\n" +
+				"NAME=bond0\n" +
+				"TYPE=Bond\n" +
+				"BONDING_MASTER=yes\n" +
+				"BONDING_OPTS=\"mode=802.3ad miimon=100 lacp_rate=fast xmit_hash_policy=layer3+4\"\n" +
+				"BOOTPROTO=none\n" +
+				"GATEWAY=10.0.0.254 # core switch\n" +
+				"IPV6INIT=no\n" +
+				"ONBOOT=yes\n" +
+				"UUID=ad33d8b0-1f7b-cab9-9447-ba07f855b143\n" +
+				"BRIDGE=br0\n"},
+		{"NetworkManager VLAN", vlan,
+			map[string]string{"VLAN": "yes", "HWADDR": "", "NAME": "Vlan bond0.100", "VLAN_ID": "100",
+				"UUID": "3b8e5b7a-2c6f-4a1e-9d0c-8f5e2a7d9b41"},
+			[]string{"ZONE"},
+			map[string]string{"BOOTPROTO": "none", "BRIDGE": "br0"},
+			"VLAN=yes\n" +
+				"TYPE=Vlan\n" +
+				"PHYSDEV=bond0\n" +
+				"VLAN_ID=100\n" +
+				"REORDER_HDR=yes\n" +
+				"GVRP=no\n" +
+				"MVRP=no\n" +
+				"HWADDR=\n" +
+				"PROXY_METHOD=none\n" +
+				"BROWSER_ONLY=no\n" +
+				"BOOTPROTO=none\n" +
+				"DEFROUTE=yes\n" +
+				"IPV4_FAILURE_FATAL=no\n" +
+				"IPV6INIT=yes\n" +
+				"IPV6_AUTOCONF=yes\n" +
+				"IPV6_DEFROUTE=yes\n" +
+				"IPV6_FAILURE_FATAL=no\n" +
+				"NAME=\"Vlan bond0.100\"\n" +
+				"UUID=3b8e5b7a-2c6f-4a1e-9d0c-8f5e2a7d9b41\n" +
+				"DEVICE=bond0.100\n" +
+				"ONBOOT=yes\n" +
+				"BRIDGE=br0\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var config = parseInterfaceConfig([]byte(c.input), c.name)
+			for key, value := range c.params {
+				if current, exists := config.Params[key]; !exists || value != current {
+					t.Fatalf("expect %s='%s', but got '%s'", key, value, current)
+				}
+			}
+			if output := string(formatInterfaceConfig(config)); c.input != output {
+				t.Fatalf("unchanged config expect:\n%s\nbut got:\n%s", c.input, output)
+			}
+			for _, key := range c.remove {
+				delete(config.Params, key)
+			}
+			for key, value := range c.change {
+				config.Params[key] = value
+			}
+			if output := string(formatInterfaceConfig(config)); c.expected != output {
+				t.Fatalf("expect:\n%s\nbut got:\n%s", c.expected, output)
+			}
+		})
+	}
+}
@@ -1,20 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
-	"github.com/project-nano/framework"
 	"github.com/project-nano/sonar"
 	"github.com/vishvananda/netlink"
-	"io"
 	"io/ioutil"
-	"math/big"
 	"os"
 	"os/exec"
 	"os/user"
@@ -22,33 +15,64 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type SessionInfo struct {
-	Local        bool
-	Host         string
-	User         string
-	Password     string
-	ProjectPath  string
-	BinaryPath   string
-	CACertPath   string
-	CAKeyPath    string
-	Domain       string
-	GroupAddress string
-	GroupPort    int
-	LocalAddress string
-	APIAddress   string
-	APIPort      int
-	UserGroup    string
-	UID          int
-	GID          int
+	Local               bool               `json:"local"`
+	Host                string             `json:"host,omitempty"`
+	User                string             `json:"user"`
+	Password            string             `json:"-"`
+	ProjectPath         string             `json:"project_path"`
+	BinaryPath          string             `json:"binary_path"`
+	CACertPath          string             `json:"ca_cert_path"`
+	CAKeyPath           string             `json:"ca_key_path"`
+	//key of CA specified by answer when signing, never stored on host
+	CAKeyOffline        bool               `json:"ca_key_offline,omitempty"`
+	CertProfile         CertificateProfile `json:"cert_profile"`
+	Domain              string             `json:"domain"`
+	GroupAddress        string             `json:"group_address"`
+	GroupPort           int                `json:"group_port"`
+	LocalAddress        string             `json:"local_address,omitempty"`
+	APIAddress          string             `json:"api_address,omitempty"`
+	APIPort             int                `json:"api_port,omitempty"`
+	PortalPort          int                `json:"portal_port,omitempty"`
+	//bridge of cell recorded before multiple bridges supported
+	BridgeName          string             `json:"bridge_name,omitempty"`
+	BridgeInterface     string             `json:"bridge_interface,omitempty"`
+	Bridges             []CellBridge       `json:"bridges,omitempty"`
+	NetworkBackend      string             `json:"network_backend,omitempty"`
+	//values in qemu.conf before installation, empty for not assigned
+	QEMUConfigOrigin    map[string]string  `json:"qemu_config_origin,omitempty"`
+	//user added to libvirt group by installer, empty when already a member
+	LibvirtGroupMember  string             `json:"libvirt_group_member,omitempty"`
+	PolkitAccessCreated bool               `json:"polkit_access_created,omitempty"`
+	Firewall            string             `json:"firewall,omitempty"`
+	FirewallZone        string             `json:"firewall_zone,omitempty"`
+	FirewallSources     []string           `json:"firewall_sources,omitempty"`
+	FirewallZoneCreated bool               `json:"firewall_zone_created,omitempty"`
+	//interfaces bound to zone by installer, mapped to previous zone, empty for none
+	FirewallInterfaces  map[string]string  `json:"firewall_interfaces,omitempty"`
+	//options of firewall-cmd added by installer, like 'port=5870/tcp'
+	FirewallRules       []string           `json:"firewall_rules,omitempty"`
+	NftablesTableAdded  bool               `json:"nftables_table_added,omitempty"`
+	NftablesChainAdded  bool               `json:"nftables_chain_added,omitempty"`
+	UserGroup           string             `json:"user_group"`
+	UID                 int                `json:"uid"`
+	GID                 int                `json:"gid"`
 }
 
 type PortRange struct {
-	Begin    int
-	End      int
-	Protocol string
+	Begin    int    `json:"begin"`
+	End      int    `json:"end"`
+	Protocol string `json:"protocol"`
+}
+
+// String formats range like '5500-5599/udp' or '67/udp'
+func (ports PortRange) String() string {
+	if ports.Begin != ports.End{
+		return fmt.Sprintf("%d-%d/%s", ports.Begin, ports.End, ports.Protocol)
+	}
+	return fmt.Sprintf("%d/%s", ports.Begin, ports.Protocol)
 }
 
 //todo: add dependency params
@@ -74,27 +98,55 @@ const (
 	NanoVersion       = "1.4.0"
 )
 
-func main() {
-	var optionNames = map[int]string{
-		ModuleCore:     "Core",
-		ModuleFrontEnd: "FrontEnd",
-		ModuleCell:     "Cell",
-		ModuleAll:      "All",
-		ModuleUpdate:   "Update",
-		ModuleForciblyUpdate: "Forcibly Update",
-		ModuleExit:     "Exit",
-	}
+const (
+	TrustedCAPath = "/etc/pki/ca-trust/source/anchors"
+)
+
+var moduleNames = map[int]string{
+	ModuleCore:     "Core",
+	ModuleFrontEnd: "FrontEnd",
+	ModuleCell:     "Cell",
+	ModuleAll:      "All",
+	ModuleUpdate:   "Update",
+	ModuleForciblyUpdate: "Forcibly Update",
+	ModuleExit:     "Exit",
+}
+
+var moduleInstallers = map[int]ModuleInstaller{
+	ModuleCore:     CoreInstaller,
+	ModuleFrontEnd: FrontendInstaller,
+	ModuleCell:     CellInstaller,
+}
 
-	var optionFunctions = map[int]ModuleInstaller{
-		ModuleCore:     CoreInstaller,
-		ModuleFrontEnd: FrontendInstaller,
-		ModuleCell:     CellInstaller,
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := executeCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
+	var answerFile = flag.String("config", "", "answer file (.json/.yaml/.yml) for non-interactive installation")
+	var dryRun = flag.Bool("dry-run", false, "print every file write, command and link operation instead of applying them")
+	flag.Parse()
+	defer enableDryRun(*dryRun)()
 	fmt.Printf("Installer v%s started\n\nReady to install Project-Nano v%s ...\n", CurrentVersion, NanoVersion)
 	var selected = map[int]bool{}
-	for {
+	if "" != *answerFile {
+		answers, err := LoadAnswerFile(*answerFile, nil)
+		if err != nil {
+			fmt.Printf("load answer file fail: %s\n", err.Error())
+			return
+		}
+		prompter = answers
+		if selected, err = selectModulesByAnswer(answers); err != nil {
+			fmt.Printf("select modules fail: %s\n", err.Error())
+			return
+		}
+	}
+	for 0 == len(selected) {
 		for index := ModuleCore; index <= ModuleExit; index++ {
-			name, _ := optionNames[index]
+			name, _ := moduleNames[index]
 			fmt.Printf("%d : %s\n", index, name)
 		}
 		fmt.Println("Input index to select module to install, multi-modules split by ',' (like 2,3):")
@@ -121,64 +173,73 @@ func main() {
 			selected = map[int]bool{ModuleCore: true, ModuleFrontEnd:true, ModuleCell:true}
 		}
 		if _, exists = selected[ModuleUpdate]; exists{
-			UpdateAllModules(false)
+			if err := UpdateAllModules(false); err != nil{
+				fmt.Println(err.Error())
+			}
 			return
 		}else if _, exists = selected[ModuleForciblyUpdate]; exists{
-			UpdateAllModules(true)
+			if err := UpdateAllModules(true); err != nil{
+				fmt.Println(err.Error())
+			}
 			return
 		}
 		break
 	}
-	var err error
-	if err = checkDefaultRoute(); err != nil{
-		fmt.Printf("check default route fail: %s\n", err.Error())
+	if err := installModules(selected); err != nil{
+		fmt.Println(err.Error())
+		return
+	}
+}
+
+func installModules(selected map[int]bool) (err error){
+	if err = runPreflight(selected); err != nil{
 		return
 	}
-	if err = checkFirewalld(); err != nil{
-		fmt.Printf("check firewalld fail: %s\n", err.Error())
+	if err = checkDefaultRoute(); err != nil{
+		err = fmt.Errorf("check default route fail: %s", err.Error())
 		return
 	}
 	var session = SessionInfo{Local: true}
 	session.BinaryPath = BinaryPathName
+	var firewall Firewall
+	if firewall, err = chooseFirewall(&session); err != nil{
+		err = fmt.Errorf("check firewall fail: %s", err.Error())
+		return
+	}
+	var transaction = beginTransaction()
+	defer func() {
+		finishTransaction(transaction, err != nil)
+	}()
 	var username string
-	if username, err = framework.InputString("Service Owner Name", "root"); err != nil{
+	if username, err = prompter.InputString(AnswerUser, "Service Owner Name", "root"); err != nil{
 		return
 	}
 	if err = setUserInfo(&session, username);err != nil{
-		fmt.Printf("set user info fail: %s\n", err.Error())
+		err = fmt.Errorf("set user info fail: %s", err.Error())
 		return
 	}
 	if err = installBasicComponents(&session); err != nil {
-		fmt.Printf("install basic components fail: %s\n", err.Error())
+		err = fmt.Errorf("install basic components fail: %s", err.Error())
 		return
 	}
 	updateAllAccess(session)
 	fmt.Printf("%d modules will install...\n", len(selected))
 
-	var allRange []PortRange
 	//default ranges
-	{
-		const (
-			GroupPortBegin = 5500
-			GroupPortEnd = 5599
-			ModulePortBegin = 5600
-			ModulePortEnd = 5800
-		)
-		allRange = append(allRange, PortRange{GroupPortBegin, GroupPortEnd, "udp"})
-		allRange = append(allRange, PortRange{ModulePortBegin, ModulePortEnd, "udp"})
-	}
+	var allRange = defaultPortRanges()
 	if _, exists := selected[ModuleCell];exists{
 		if err = installCellDependencyPackages();err != nil{
 			fmt.Printf("install cell dependency package fail: %s\n", err.Error())
-			answer, err := framework.InputString("Do you want to continue? (y/N)", "no")
-			answer = strings.ToLower(answer)
-			if err != nil || ("y" != answer && "yes" != answer){
-				fmt.Println("installing interupted by user")
+			confirmed, confirmError := prompter.Confirm(AnswerIgnoreDependencyFailed, "Do you want to continue")
+			if confirmError != nil{
+				return confirmError
+			}else if !confirmed{
+				err = errors.New("installing interupted by user")
 				return
 			}
 		}
-		if err = configureNetworkForCell();err != nil{
-			fmt.Printf("configure default network bridge fail: %s\n", err.Error())
+		if err = configureNetworkForCell(&session);err != nil{
+			err = fmt.Errorf("configure default network bridge fail: %s", err.Error())
 			return
 		}
 	}
@@ -186,10 +247,9 @@ func main() {
 	for index := ModuleCore; index < ModuleExit; index++ {
 		if _, exists := selected[index]; exists {
 			//selected
-			ranges, err := optionFunctions[index](&session)
+			ranges, err := moduleInstallers[index](&session)
 			if err != nil {
-				fmt.Printf("install module %s fail: %s\n", optionNames[index], err.Error())
-				return
+				return fmt.Errorf("install module %s fail: %s", moduleNames[index], err.Error())
 			}
 			for _, ports := range ranges {
 				allRange = append(allRange, ports)
@@ -199,15 +259,137 @@ func main() {
 	}
 	updateAllAccess(session)
 	if 0 != len(allRange) {
-		if err = enabledPortRanges(session, allRange); err != nil {
+		if err = firewall.Apply(allRange); err != nil {
 			fmt.Printf("enabled port ranges fail: %s\n", err.Error())
 		}
 	}
-	if err = enableIPForward(); err != nil{
-		fmt.Printf("enable ip forward fail: %s", err.Error())
+	if err = configureKernelParameters(); err != nil{
+		err = fmt.Errorf("configure kernel parameters fail: %s", err.Error())
+		return
+	}
+	if err = saveInstallManifest(&session, selected, allRange, transaction.ModifiedFiles(session.ProjectPath)); err != nil{
+		err = fmt.Errorf("save install manifest fail: %s", err.Error())
 		return
 	}
 	fmt.Println("all modules installed")
+	return nil
+}
+
+func saveInstallManifest(session *SessionInfo, selected map[int]bool, ranges []PortRange, modifiedFiles []string) (err error){
+	var manifest *InstallManifest
+	if manifest, err = loadInstallManifest(session.ProjectPath); err != nil{
+		return
+	}else if nil == manifest{
+		manifest = &InstallManifest{}
+	}
+	var previous = manifest.Session
+	if previous.FirewallZone == session.FirewallZone{
+		//keep zone, interfaces and rules changed by previous installation
+		session.FirewallZoneCreated = session.FirewallZoneCreated || previous.FirewallZoneCreated
+		for name, zone := range previous.FirewallInterfaces{
+			if nil == session.FirewallInterfaces{
+				session.FirewallInterfaces = map[string]string{}
+			}
+			//zone before the first installation
+			session.FirewallInterfaces[name] = zone
+		}
+		//rules added by previous installation, not found by current one
+		for _, option := range previous.FirewallRules{
+			var exists = false
+			for _, current := range session.FirewallRules{
+				if current == option{
+					exists = true
+					break
+				}
+			}
+			if !exists{
+				session.FirewallRules = append(session.FirewallRules, option)
+			}
+		}
+	}
+	session.NftablesTableAdded = session.NftablesTableAdded || previous.NftablesTableAdded
+	session.NftablesChainAdded = session.NftablesChainAdded || previous.NftablesChainAdded
+	for index, bridge := range session.Bridges{
+		if nil != bridge.Uplink{
+			continue
+		}
+		//keep uplink of bridge created by previous installation, so that removed when uninstall
+		for _, created := range installedBridges(&previous){
+			if created.Name == bridge.Name{
+				session.Bridges[index].Uplink = created.Uplink
+				break
+			}
+		}
+	}
+	if "" == session.LibvirtGroupMember{
+		session.LibvirtGroupMember = previous.LibvirtGroupMember
+	}
+	session.PolkitAccessCreated = session.PolkitAccessCreated || previous.PolkitAccessCreated
+	if nil == session.QEMUConfigOrigin{
+		session.QEMUConfigOrigin = previous.QEMUConfigOrigin
+	}else{
+		for key, origin := range previous.QEMUConfigOrigin{
+			//keep value before the first installation, instead of value set by previous installation
+			session.QEMUConfigOrigin[key] = origin
+		}
+	}
+	manifest.Session = *session
+	for index := ModuleCore; index < ModuleExit; index++ {
+		if _, exists := selected[index]; exists {
+			var moduleName = strings.ToLower(moduleNames[index])
+			if err = manifest.RecordModule(session.ProjectPath, moduleName, moduleName); err != nil{
+				return
+			}
+		}
+	}
+	manifest.RecordPorts(ranges)
+	manifest.RecordFiles(modifiedFiles)
+	return manifest.Save(session.ProjectPath, session)
+}
+
+func defaultPortRanges() []PortRange {
+	const (
+		GroupPortBegin = 5500
+		GroupPortEnd = 5599
+		ModulePortBegin = 5600
+		ModulePortEnd = 5800
+	)
+	return []PortRange{{GroupPortBegin, GroupPortEnd, "udp"}, {ModulePortBegin, ModulePortEnd, "udp"}}
+}
+
+func selectModulesByAnswer(answers *AnswerPrompter) (selected map[int]bool, err error) {
+	var names []string
+	if names, err = answers.StringList(AnswerModules); err != nil {
+		return
+	}
+	return parseModuleNames(names)
+}
+
+func parseModuleNames(names []string) (selected map[int]bool, err error) {
+	var moduleIndexes = map[string]int{
+		"core":     ModuleCore,
+		"frontend": ModuleFrontEnd,
+		"cell":     ModuleCell,
+	}
+	selected = map[int]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if "all" == name {
+			for _, index := range moduleIndexes {
+				selected[index] = true
+			}
+		} else if index, exists := moduleIndexes[name]; exists {
+			selected[index] = true
+		} else {
+			err = fmt.Errorf("invalid module '%s'", name)
+			return
+		}
+	}
+	if 0 == len(selected) {
+		err = errors.New("no module selected")
+		return
+	}
+	return selected, nil
 }
 
 func checkDefaultRoute() (err error){
@@ -233,94 +415,6 @@ func checkDefaultRoute() (err error){
 	return nil
 }
 
-func checkFirewalld() (err error) {
-	var ready = false
-	//inactive (dead)
-	var cmd = exec.Command("systemctl", "status", "firewalld")
-	var output []byte
-	if output, err = cmd.CombinedOutput(); err != nil{
-		fmt.Println("warning: firewalld service maybe stopped")
-	}else {
-		var content = string(output)
-		if -1 != strings.Index(content, "; disabled;"){
-			//disabled
-			fmt.Println("warning: firewalld service disabled")
-		}else if -1 != strings.Index(content, "dead") || -1 != strings.Index(content, "inactive"){
-			fmt.Println("warning: firewalld service is stopped")
-		}else{
-			ready = true
-		}
-	}
-	if !ready {
-		fmt.Println("Nano requires a running firewalld service to work properly.\nenter to exit installation, or input 'yes' to continue")
-		var input string
-		_, err = fmt.Scanln(&input)
-		if "yes" != input{
-			err = errors.New("quit installation")
-			return
-		}
-		fmt.Println("warning: choose to continue with risk, your installation may not work")
-		return nil
-	}else{
-		fmt.Println("firewalld service ready")
-		return nil
-	}
-	//disabled
-
-}
-
-func enableIPForward() (err error){
-	const (
-		CheckPath = "/proc/sys/net/ipv4/ip_forward"
-		ConfigFile = "/usr/lib/sysctl.d/50-default.conf"
-		EnableLine = "net.ipv4.ip_forward = 1"
-	)
-	{
-		var file *os.File
-		if file, err = os.Open(CheckPath);err != nil{
-			return
-		}
-		var scanner = bufio.NewScanner(file)
-		if !scanner.Scan(){
-			err = errors.New("no content available")
-			return
-		}
-		var data = scanner.Text()
-		var current int
-		current, err = strconv.Atoi(data)
-		if err != nil{
-			return
-		}
-		if current == 1{
-			fmt.Println("ip_forward already enabled")
-			return nil
-		}else{
-			fmt.Println("try enable ip_forward")
-		}
-	}
-	{
-		//write config
-		var file *os.File
-		file, err = os.OpenFile(ConfigFile, os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil{
-			return
-		}
-		fmt.Fprintln(file, EnableLine)
-		file.Close()
-		fmt.Printf("ip_forward enabled in config %s ", ConfigFile)
-	}
-	{
-		var cmd = exec.Command("/sbin/sysctl", "-w", "net.ipv4.ip_forward=1")
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("enable ip_forward fail: %s", err.Error())
-			return
-		}else{
-			fmt.Println("ip_forward enabled")
-		}
-	}
-	return
-}
-
 func setUserInfo(session *SessionInfo, userName string) (err error) {
 	u, err := user.Lookup(userName)
 	if err != nil{
@@ -351,9 +445,8 @@ func setUserInfo(session *SessionInfo, userName string) (err error) {
 }
 
 func updateAllAccess(session SessionInfo){
-	var cmd = exec.Command("chown", "-R", fmt.Sprintf("%s:%s", session.User, session.UserGroup),
-		session.ProjectPath)
-	if err := executeWithOutput(cmd);err != nil{
+	if err := host.Execute("chown", "-R", fmt.Sprintf("%s:%s", session.User, session.UserGroup),
+		session.ProjectPath);err != nil{
 		fmt.Printf("update access fail: %s\n", err.Error())
 	}else{
 		fmt.Println("all access modified")
@@ -370,6 +463,8 @@ func installBasicComponents(session *SessionInfo) (err error) {
 		return
 	}
 	session.ProjectPath = projectPath
+	//files under project path managed by installer, never backed up
+	backups.Exclude(projectPath)
 	if err = inputDomainConfigure(session); err != nil{
 		return
 	}
@@ -380,13 +475,13 @@ func installBasicComponents(session *SessionInfo) (err error) {
 }
 
 func inputDomainConfigure(session *SessionInfo) (err error){
-	if session.Domain, err = framework.InputString("Group Domain Name", sonar.DefaultDomain); err != nil{
+	if session.Domain, err = prompter.InputString(AnswerDomain, "Group Domain Name", sonar.DefaultDomain); err != nil{
 		return
 	}
-	if session.GroupAddress, err = framework.InputMultiCastAddress("Group MultiCast Address", sonar.DefaultMulticastAddress); err != nil{
+	if session.GroupAddress, err = prompter.InputMultiCastAddress(AnswerGroupAddress, "Group MultiCast Address", sonar.DefaultMulticastAddress); err != nil{
 		return
 	}
-	if session.GroupPort, err = framework.InputNetworkPort("Group MultiCast Port", sonar.DefaultMulticastPort);err !=nil{
+	if session.GroupPort, err = prompter.InputNetworkPort(AnswerGroupPort, "Group MultiCast Port", sonar.DefaultMulticastPort);err !=nil{
 		return
 	}
 	return nil
@@ -394,167 +489,76 @@ func inputDomainConfigure(session *SessionInfo) (err error){
 
 func installRootCA(session *SessionInfo) (err error) {
 	const (
-		CertPathName         = "cert"
-		TrustedPath          = "/etc/pki/ca-trust/source/anchors"
-		DefaultDurationYears = 99
-		RSAKeyBits           = 2048
+		LegacyCertPathName = "cert"
 	)
-	var serialNumber = big.NewInt(1699)
-
-	var certFileName = fmt.Sprintf("%s_ca.crt.pem", ProjectName)
-	var keyFileName = fmt.Sprintf("%s_ca.key.pem", ProjectName)
-	if err = ensurePath(CertPathName, "cert", session.UID, session.GID); err != nil{
+	if session.CertProfile, err = inputCertificateProfile(CertificateProfile{}); err != nil{
 		return
 	}
-	var generatedCertFile = filepath.Join(CertPathName, certFileName)
-	var generatedKeyFile = filepath.Join(CertPathName, keyFileName)
-	if _, err = os.Stat(generatedCertFile); os.IsNotExist(err) {
-		//generate cert file
-		var certificate = x509.Certificate{
-			SerialNumber: serialNumber,
-			Subject: pkix.Name{
-				CommonName:   fmt.Sprintf("%s Root CA", ProjectName),
-				Organization: []string{ProjectName},
-			},
-			NotBefore:             time.Now(),
-			NotAfter:              time.Now().AddDate(DefaultDurationYears, 0, 0),
-			IsCA:                  true,
-			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-			BasicConstraintsValid: true,
-		}
-		var privateKey *rsa.PrivateKey
-		privateKey, err = rsa.GenerateKey(rand.Reader, RSAKeyBits)
-		if err != nil {
-			return
-		}
-		fmt.Printf("private key with %d bits generated\n", RSAKeyBits)
-		var publicKey = privateKey.PublicKey
-		var certContent []byte
-		certContent, err = x509.CreateCertificate(rand.Reader, &certificate, &certificate, &publicKey, privateKey)
-		if err != nil {
-			return
-		}
-		// Public key
-		var certFile *os.File
-		certFile, err = os.Create(generatedCertFile)
-		if err != nil {
+	session.CAKeyOffline = isPositiveAnswer(optionalAnswer(AnswerCAKeyOffline, ""))
+	var certFileName = fmt.Sprintf("%s_ca%s", ProjectName, CertFileSuffix)
+	var keyFileName = fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix)
+	var installedCertFile = filepath.Join(session.ProjectPath, CertificatePathName, certFileName)
+	var installedKeyFile = filepath.Join(session.ProjectPath, CertificatePathName, keyFileName)
+	//generated in working directory by earlier version
+	var legacyCertFile = filepath.Join(LegacyCertPathName, certFileName)
+	var legacyKeyFile = filepath.Join(LegacyCertPathName, keyFileName)
+	if host.Exists(legacyKeyFile){
+		fmt.Printf("warning: key of CA '%s' left in working directory by earlier version, remove it after installation\n", legacyKeyFile)
+	}
+	var authority *CertificateAuthority
+	var keyPEM []byte
+	if importFile := optionalAnswer(AnswerCACertFile, ""); "" != importFile{
+		//CA of organization instead of generated
+		if authority, keyPEM, err = importCertificateAuthority(importFile, optionalAnswer(AnswerCAKeyFile, "")); err != nil{
+			err = fmt.Errorf("import CA fail: %s", err.Error())
 			return
 		}
-		if err = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: certContent}); err != nil {
+		if err = saveCertificateAuthority(session, authority, keyPEM, false); err != nil{
 			return
 		}
-		if err = certFile.Close(); err != nil {
+	}else if host.Exists(installedCertFile){
+		fmt.Printf("CA '%s' already installed\n", installedCertFile)
+		session.CACertPath = installedCertFile
+		session.CAKeyPath = ""
+		if !session.CAKeyOffline && host.Exists(installedKeyFile){
+			session.CAKeyPath = installedKeyFile
+		}
+	}else if host.Exists(legacyCertFile) && host.Exists(legacyKeyFile){
+		//keep CA shared by modules installed with earlier version
+		if authority, keyPEM, err = importCertificateAuthority(legacyCertFile, legacyKeyFile); err != nil{
+			err = fmt.Errorf("load CA generated by earlier version fail: %s", err.Error())
 			return
 		}
-		fmt.Printf("cert file '%s' generated\n", generatedCertFile)
-
-		// Private key
-		var keyFile *os.File
-		keyFile, err = os.OpenFile(generatedKeyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, DefaultFilePerm)
-		if err != nil {
-			os.Remove(generatedCertFile)
+		if err = saveCertificateAuthority(session, authority, keyPEM, false); err != nil{
 			return
 		}
-		if err = pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}); err != nil {
-			os.Remove(generatedCertFile)
-			return
-		}
-		if err = keyFile.Close(); err != nil {
-			os.Remove(generatedCertFile)
-			return
-		}
-		fmt.Printf("key file '%s' generated\n", generatedKeyFile)
-		if err = updateAccess(session, generatedCertFile);err != nil{
+	}else{
+		if authority, keyPEM, err = newCertificateAuthority(session.CertProfile); err != nil{
 			return
 		}
-		if err = updateAccess(session, generatedKeyFile);err != nil{
+		if err = saveCertificateAuthority(session, authority, keyPEM, true); err != nil{
 			return
 		}
-	} else {
-		fmt.Printf("cert '%s', key '%s' already generated\n", generatedCertFile, generatedKeyFile)
 	}
-
-	//install path
-	var installedPath = filepath.Join(session.ProjectPath, CertPathName)
-	if err = ensurePath(installedPath, "cert install", session.UID, session.GID); err != nil{
+	if err = protectCAKey(session); err != nil{
 		return
 	}
-	var installedCertFile = filepath.Join(installedPath, certFileName)
-	var installedKeyFile = filepath.Join(installedPath, keyFileName)
-	if _, err = os.Stat(installedCertFile); os.IsNotExist(err) {
-		if err = copyFile(generatedCertFile, installedCertFile); err != nil {
-			return
-		} else {
-			fmt.Printf("'%s' copied to '%s'\n", generatedCertFile, installedCertFile)
-		}
-		updateAccess(session, installedCertFile)
-	} else {
-		fmt.Printf("cert file '%s' already installed\n", installedCertFile)
-	}
-	if _, err = os.Stat(installedKeyFile); os.IsNotExist(err) {
-		if err = copyFile(generatedKeyFile, installedKeyFile); err != nil {
-			return
-		} else {
-			fmt.Printf("'%s' copied to '%s'\n", generatedKeyFile, installedKeyFile)
-		}
-		updateAccess(session, installedKeyFile)
-	} else {
-		fmt.Printf("key file '%s' already installed\n", installedKeyFile)
-	}
-	var trustedCertFile = filepath.Join(TrustedPath, certFileName)
-	if _, err = os.Stat(trustedCertFile); os.IsNotExist(err) {
-		if err = copyFile(installedCertFile, trustedCertFile); err != nil {
-			return
-		} else {
-			fmt.Printf("'%s' copied to '%s'\n", installedCertFile, trustedCertFile)
-		}
-		updateAccess(session, trustedCertFile)
-		var cmd = exec.Command("update-ca-trust")
-		if err = executeWithOutput(cmd); err != nil {
-			return
-		} else {
-			fmt.Printf("'%s' updated\n", trustedCertFile)
-		}
-	} else {
-		fmt.Printf("'%s' already installed\n", trustedCertFile)
+	if authority, err = loadCertificateAuthority(installedCertFile, ""); err != nil{
+		return
 	}
-	session.CACertPath = installedCertFile
-	session.CAKeyPath = installedKeyFile
-	return
-}
-
-func enabledPortRanges(session SessionInfo, ranges []PortRange) (err error) {
-	//#firewall-cmd --permanent --direct --add-rule ipv4 filter INPUT 0 -m pkttype --pkt-type multicast -j ACCEPT
-	//#firewall-cmd --zone=public --add-port=5599-5800/udp --permanent
-	//#firewall-cmd --zone=public --add-port=5801-6000/tcp --permanent
-	//#firewall-cmd --zone=public --add-port=15900-16000/tcp --permanent
-	//#firewall-cmd --reload
-
-	//enable multicast
-	var cmd = exec.Command("firewall-cmd", "--permanent","--direct","--add-rule","ipv4","filter","INPUT","0","-m","pkttype","--pkt-type","multicast","-j","ACCEPT")
-	if err = executeWithOutput(cmd);err != nil{
-		fmt.Printf("enable multicast warning: %s", err.Error())
-	}
-	for _, config := range ranges{
-		if config.Begin != config.End{
-			cmd = exec.Command("firewall-cmd","--zone=public", "--permanent", fmt.Sprintf("--add-port=%d-%d/%s", config.Begin, config.End, config.Protocol))
-		}else{
-			cmd = exec.Command("firewall-cmd","--zone=public", "--permanent", fmt.Sprintf("--add-port=%d/%s", config.Begin, config.Protocol))
-		}
-		if err = executeWithOutput(cmd);err != nil{
-			fmt.Printf("add ports warning: %s", err.Error())
-		}
+	//root not included when issuer of imported CA trusted by system already
+	var rootPEM []byte
+	if nil != authority.Root{
+		rootPEM = encodeCertificates([]*x509.Certificate{authority.Root})
 	}
-	cmd = exec.Command("firewall-cmd","--reload")
-	return executeWithOutput(cmd)
+	return trustCertificateAuthority(session, rootPEM)
 }
 
 func ensurePath(path, name string, uid, gid int) (err error) {
-	if _, err = os.Stat(path);os.IsNotExist(err){
-		if err = os.MkdirAll(path, DefaultPathPerm);err != nil{
+	if !host.Exists(path){
+		if err = host.MakeDirectory(path, DefaultPathPerm);err != nil{
 			return
-		}else if err = os.Chown(path, uid, gid);err != nil{
+		}else if err = host.Chown(path, uid, gid);err != nil{
 			return
 		}else{
 			fmt.Printf("%s path '%s' created\n", name, path)
@@ -564,43 +568,35 @@ func ensurePath(path, name string, uid, gid int) (err error) {
 }
 
 func updateAccess(session *SessionInfo, path string) (err error){
-	return os.Chown(path, session.UID, session.GID)
+	return host.Chown(path, session.UID, session.GID)
 }
 
 func enableExecuteAccess(session *SessionInfo, path string) (err error){
-	if err = os.Chown(path, session.UID, session.GID); err != nil{
+	if err = host.Chown(path, session.UID, session.GID); err != nil{
 		return err
 	}
 	const (
 		ExecutePerm = 0740
 	)
-	err = os.Chmod(path, ExecutePerm)
+	err = host.Chmod(path, ExecutePerm)
 	return
 }
 
 func copyFile(src, dst string) error {
-	var err error
-	var srcfd *os.File
-	var dstfd *os.File
-	var srcinfo os.FileInfo
-
-	if srcfd, err = os.Open(src); err != nil {
-		return err
-	}
-	defer srcfd.Close()
-
-	if dstfd, err = os.Create(dst); err != nil {
-		return err
-	}
-	defer dstfd.Close()
+	return host.CopyFile(src, dst)
+}
 
-	if _, err = io.Copy(dstfd, srcfd); err != nil {
-		return err
+// copyLocalFile replaces dst atomically with content and mode of src
+func copyLocalFile(src, dst string) (err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(src); err != nil {
+		return
 	}
-	if srcinfo, err = os.Stat(src); err != nil {
-		return err
+	var data []byte
+	if data, err = ioutil.ReadFile(src); err != nil {
+		return
 	}
-	return os.Chmod(dst, srcinfo.Mode())
+	return replaceFile(dst, data, info.Mode(), false)
 }
 
 func copyDir(src string, dst string) error {
@@ -612,7 +608,7 @@ func copyDir(src string, dst string) error {
 		return err
 	}
 
-	if err = os.MkdirAll(dst, srcinfo.Mode()); err != nil {
+	if err = host.MakeDirectory(dst, srcinfo.Mode()); err != nil {
 		return err
 	}
 
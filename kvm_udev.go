@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	KVMUdevRuleFile = "/etc/udev/rules.d/65-nano-kvm.rules"
+	KVMDeviceMode   = 0660
+)
+
+// kvmDeviceNames are misc devices accessed by QEMU, vhost devices only exist when module loaded
+var kvmDeviceNames = []string{"kvm", "vhost-net", "vhost-vsock"}
+
+func kvmUdevRule(user, group string) []byte {
+	var content bytes.Buffer
+	fmt.Fprintln(&content, "# generated by Nano installer, access of KVM and vhost devices for QEMU")
+	for _, name := range kvmDeviceNames {
+		fmt.Fprintf(&content, "SUBSYSTEM==\"misc\", KERNEL==\"%s\", OWNER=\"%s\", GROUP=\"%s\", MODE=\"%04o\"\n",
+			name, user, group, KVMDeviceMode)
+	}
+	return content.Bytes()
+}
+
+// installKVMUdevRule grants service user access to KVM devices by udev rule, so that kept after reboot or module reloaded
+func installKVMUdevRule(session *SessionInfo) (err error) {
+	var rule = kvmUdevRule(session.User, session.UserGroup)
+	if current, readError := host.ReadFile(KVMUdevRuleFile); nil == readError && bytes.Equal(current, rule) {
+		fmt.Printf("udev rule '%s' already installed\n", KVMUdevRuleFile)
+	} else {
+		//rule file restored before this action when rollback, so that devices reset by rules of system
+		registerUndo("reload udev rules", reloadUdevRules)
+		if err = host.WriteFile(KVMUdevRuleFile, rule, 0644); err != nil {
+			return
+		}
+		fmt.Printf("udev rule '%s' installed\n", KVMUdevRuleFile)
+	}
+	if err = reloadUdevRules(host); err != nil {
+		err = fmt.Errorf("reload udev rules fail: %s", err.Error())
+		return
+	}
+	fmt.Println("udev rules reloaded")
+	return verifyKVMDevices(session.UID, session.GID)
+}
+
+// removeKVMUdevRule removes rule installed, then owner of devices reset by rules of system
+func removeKVMUdevRule() (err error) {
+	if host.Exists(KVMUdevRuleFile) {
+		if err = host.Remove(KVMUdevRuleFile); err != nil {
+			return
+		}
+		fmt.Printf("udev rule '%s' removed\n", KVMUdevRuleFile)
+	}
+	if err = reloadUdevRules(host); err != nil {
+		err = fmt.Errorf("reload udev rules fail: %s", err.Error())
+		return
+	}
+	fmt.Println("udev rules reloaded")
+	return nil
+}
+
+// reloadUdevRules applies rules to existing misc devices and waits until events handled
+func reloadUdevRules(operator HostOperator) (err error) {
+	if err = operator.Execute("udevadm", "control", "--reload-rules"); err != nil {
+		return
+	}
+	if err = operator.Execute("udevadm", "trigger", "--action=change", "--subsystem-match=misc"); err != nil {
+		return
+	}
+	return operator.Execute("udevadm", "settle")
+}
+
+// verifyKVMDevices checks owner, group and mode of devices present after rule applied
+func verifyKVMDevices(uid, gid int) (err error) {
+	if dryRunEnabled() {
+		return nil
+	}
+	for _, name := range kvmDeviceNames {
+		var device = filepath.Join("/dev", name)
+		var info os.FileInfo
+		if info, err = os.Stat(device); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("owner of %s unknown", device)
+		}
+		if uid != int(stat.Uid) || gid != int(stat.Gid) {
+			return fmt.Errorf("%s owned by %d:%d instead of %d:%d after udev rule applied", device, stat.Uid, stat.Gid, uid, gid)
+		}
+		if KVMDeviceMode != info.Mode().Perm()&KVMDeviceMode {
+			return fmt.Errorf("mode of %s is %04o, %04o required", device, info.Mode().Perm(), KVMDeviceMode)
+		}
+		fmt.Printf("%s accessible by %d:%d\n", device, uid, gid)
+	}
+	return nil
+}
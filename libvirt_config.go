@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LibvirtConfig edits configure of libvirt like qemu.conf, assignments in form of 'key = value',
+// value is a string, integer or list which may span lines. Lines not changed kept as is
+type LibvirtConfig struct {
+	lines []string
+}
+
+// libvirtAssignment locates an active assignment in lines, end is exclusive
+type libvirtAssignment struct {
+	Key   string
+	Value string
+	Begin int
+	End   int
+}
+
+var libvirtAssignmentPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+var libvirtCommentedPattern = regexp.MustCompile(`^\s*#\s*([A-Za-z_][A-Za-z0-9_]*)\s*=`)
+
+func parseLibvirtConfig(data []byte) *LibvirtConfig {
+	var content = strings.TrimSuffix(string(data), "\n")
+	if "" == content {
+		return &LibvirtConfig{}
+	}
+	return &LibvirtConfig{lines: strings.Split(content, "\n")}
+}
+
+func (config *LibvirtConfig) Bytes() []byte {
+	if 0 == len(config.lines) {
+		return nil
+	}
+	return []byte(strings.Join(config.lines, "\n") + "\n")
+}
+
+// assignments returns active assignments in order, the last one takes effect when key assigned repeatedly
+func (config *LibvirtConfig) assignments() (result []libvirtAssignment) {
+	for index := 0; index < len(config.lines); index++ {
+		var matched = libvirtAssignmentPattern.FindStringSubmatch(config.lines[index])
+		if nil == matched {
+			continue
+		}
+		var assignment = libvirtAssignment{Key: matched[1], Begin: index}
+		var value = stripLibvirtComment(matched[2])
+		//list continues until brackets closed
+		for depth := libvirtListDepth(value); depth > 0 && index+1 < len(config.lines); {
+			index++
+			var next = stripLibvirtComment(config.lines[index])
+			value += " " + next
+			depth += libvirtListDepth(next)
+		}
+		assignment.Value = strings.TrimSpace(value)
+		assignment.End = index + 1
+		result = append(result, assignment)
+	}
+	return result
+}
+
+// Get returns value of key as written, like "qemu" with quotes
+func (config *LibvirtConfig) Get(key string) (value string, exists bool) {
+	for _, assignment := range config.assignments() {
+		if key == assignment.Key {
+			value, exists = assignment.Value, true
+		}
+	}
+	return
+}
+
+// Set assigns value formatted by formatLibvirtString or integer, returns false when value not changed.
+// New assignment placed after the commented default of key, or appended
+func (config *LibvirtConfig) Set(key, value string) (changed bool) {
+	var line = fmt.Sprintf("%s = %s", key, value)
+	var found = false
+	var target libvirtAssignment
+	for _, assignment := range config.assignments() {
+		if key == assignment.Key {
+			target, found = assignment, true
+		}
+	}
+	if found {
+		if value == target.Value {
+			return false
+		}
+		if 1 == target.End-target.Begin {
+			//keep comment after value
+			var original = config.lines[target.Begin]
+			if index := libvirtCommentIndex(original); index < len(original) {
+				line += " " + original[index:]
+			}
+		}
+		config.replace(target.Begin, target.End, []string{line})
+		return true
+	}
+	for index, current := range config.lines {
+		if matched := libvirtCommentedPattern.FindStringSubmatch(current); nil != matched && key == matched[1] {
+			config.replace(index+1, index+1, []string{line})
+			return true
+		}
+	}
+	config.lines = append(config.lines, line)
+	return true
+}
+
+// Unset comments out all assignments of key, so that libvirt uses default value
+func (config *LibvirtConfig) Unset(key string) (changed bool) {
+	var assignments = config.assignments()
+	for index := len(assignments) - 1; index >= 0; index-- {
+		var assignment = assignments[index]
+		if key != assignment.Key {
+			continue
+		}
+		for line := assignment.Begin; line < assignment.End; line++ {
+			config.lines[line] = "#" + config.lines[line]
+		}
+		changed = true
+	}
+	return changed
+}
+
+func (config *LibvirtConfig) replace(begin, end int, lines []string) {
+	var updated = append([]string{}, config.lines[:begin]...)
+	updated = append(updated, lines...)
+	config.lines = append(updated, config.lines[end:]...)
+}
+
+func formatLibvirtString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// parseLibvirtString returns content of quoted value, or value as is when not quoted
+func parseLibvirtString(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(value[1 : len(value)-1])
+	}
+	return value
+}
+
+// stripLibvirtComment removes comment outside quotes
+func stripLibvirtComment(value string) string {
+	return strings.TrimSpace(value[:libvirtCommentIndex(value)])
+}
+
+// libvirtCommentIndex returns position of comment outside quotes, or length of value when no comment
+func libvirtCommentIndex(value string) int {
+	var quoted = false
+	for index := 0; index < len(value); index++ {
+		switch value[index] {
+		case '\\':
+			index++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return index
+			}
+		}
+	}
+	return len(value)
+}
+
+func libvirtListDepth(value string) (depth int) {
+	var quoted = false
+	for index := 0; index < len(value); index++ {
+		switch value[index] {
+		case '\\':
+			index++
+		case '"':
+			quoted = !quoted
+		case '[':
+			if !quoted {
+				depth++
+			}
+		case ']':
+			if !quoted {
+				depth--
+			}
+		}
+	}
+	return depth
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultProjectPath    = "/opt/nano"
+	InstallerDataPathName = ".installer"
+	ManifestFileName      = "manifest.json"
+	ManifestVersion       = 1
+)
+
+type InstalledModule struct {
+	Name   string `json:"name"`
+	Binary string `json:"binary"`
+	SHA256 string `json:"sha256"`
+}
+
+// InstallManifest records what installed and how, saved under project path
+type InstallManifest struct {
+	Version          int               `json:"version"`
+	InstallerVersion string            `json:"installer_version"`
+	NanoVersion      string            `json:"nano_version"`
+	InstalledTime    string            `json:"installed_time"`
+	UpdatedTime      string            `json:"updated_time"`
+	Session          SessionInfo       `json:"session"`
+	Modules          []InstalledModule `json:"modules"`
+	Ports            []PortRange       `json:"ports"`
+	ModifiedFiles    []string          `json:"modified_files"`
+}
+
+func manifestPath(projectPath string) string {
+	return filepath.Join(projectPath, InstallerDataPathName, ManifestFileName)
+}
+
+// loadInstallManifest returns nil when no manifest available
+func loadInstallManifest(projectPath string) (manifest *InstallManifest, err error) {
+	var filename = manifestPath(projectPath)
+	if !host.Exists(filename) {
+		return nil, nil
+	}
+	var data []byte
+	if data, err = host.ReadFile(filename); err != nil {
+		return
+	}
+	manifest = &InstallManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		err = fmt.Errorf("invalid manifest '%s': %s", filename, err.Error())
+		return
+	}
+	if manifest.Version > ManifestVersion {
+		err = fmt.Errorf("manifest '%s' version %d not supported, upgrade installer first", filename, manifest.Version)
+		return
+	}
+	return manifest, nil
+}
+
+func (manifest *InstallManifest) Save(projectPath string, session *SessionInfo) (err error) {
+	var dataPath = filepath.Join(projectPath, InstallerDataPathName)
+	if err = ensurePath(dataPath, "installer data", session.UID, session.GID); err != nil {
+		return
+	}
+	manifest.Version = ManifestVersion
+	manifest.InstallerVersion = CurrentVersion
+	manifest.NanoVersion = NanoVersion
+	manifest.UpdatedTime = time.Now().Format(time.RFC3339)
+	if "" == manifest.InstalledTime {
+		manifest.InstalledTime = manifest.UpdatedTime
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(manifest, "", " "); err != nil {
+		return
+	}
+	var filename = manifestPath(projectPath)
+	if err = host.WriteFile(filename, data, DefaultFilePerm); err != nil {
+		return
+	}
+	fmt.Printf("install manifest '%s' saved\n", filename)
+	return nil
+}
+
+// HasModule checks if module recorded
+func (manifest *InstallManifest) HasModule(name string) bool {
+	for _, module := range manifest.Modules {
+		if name == module.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordModule adds or updates module with hash of installed binary
+func (manifest *InstallManifest) RecordModule(projectPath, name, binary string) (err error) {
+	var hash string
+	if hash, err = fileSHA256(filepath.Join(projectPath, name, binary)); err != nil {
+		return
+	}
+	var module = InstalledModule{name, binary, hash}
+	for index, current := range manifest.Modules {
+		if name == current.Name {
+			manifest.Modules[index] = module
+			return nil
+		}
+	}
+	manifest.Modules = append(manifest.Modules, module)
+	return nil
+}
+
+// RecordPorts adds ranges not recorded
+func (manifest *InstallManifest) RecordPorts(ranges []PortRange) {
+	for _, ports := range ranges {
+		var exists = false
+		for _, current := range manifest.Ports {
+			if current == ports {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			manifest.Ports = append(manifest.Ports, ports)
+		}
+	}
+}
+
+// RecordFiles adds modified system files not recorded
+func (manifest *InstallManifest) RecordFiles(files []string) {
+	var recorded = map[string]bool{}
+	for _, filename := range manifest.ModifiedFiles {
+		recorded[filename] = true
+	}
+	for _, filename := range files {
+		if !recorded[filename] {
+			recorded[filename] = true
+			manifest.ModifiedFiles = append(manifest.ModifiedFiles, filename)
+		}
+	}
+	sort.Strings(manifest.ModifiedFiles)
+}
+
+// resolveProjectPath uses path preset or recorded in default manifest before asking operator
+func resolveProjectPath() (projectPath string, manifest *InstallManifest, err error) {
+	var answers, preset = prompter.(*AnswerPrompter)
+	if (!preset || !answers.Has(AnswerProjectPath)) && host.Exists(manifestPath(DefaultProjectPath)) {
+		projectPath = DefaultProjectPath
+		fmt.Printf("using installed path '%s' recorded in manifest\n", projectPath)
+	} else if projectPath, err = prompter.InputString(AnswerProjectPath, "Project Installed Path", DefaultProjectPath); err != nil {
+		err = fmt.Errorf("get installed path fail: %s", err.Error())
+		return
+	}
+	if !host.Exists(projectPath) {
+		err = fmt.Errorf("project path '%s' not exists", projectPath)
+		return
+	}
+	backups.Exclude(projectPath)
+	if manifest, err = loadInstallManifest(projectPath); err != nil {
+		return
+	}
+	return projectPath, manifest, nil
+}
+
+func fileSHA256(filename string) (hash string, err error) {
+	var data []byte
+	if data, err = host.ReadFile(filename); err != nil {
+		return
+	}
+	var sum = sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"github.com/project-nano/framework"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -25,7 +24,7 @@ type ModuleBinary struct {
 	Resources []ResourcePath
 }
 
-func UpdateAllModules(forcibly bool) {
+func UpdateAllModules(forcibly bool) (err error) {
 	var modules = map[string]ModuleBinary{
 		"core": ModuleBinary{"core", "core", nil},
 		"cell": ModuleBinary{"cell", "cell", nil},
@@ -34,47 +33,54 @@ func UpdateAllModules(forcibly bool) {
 
 	var moduleOrder = []string{"core", "cell", "frontend"}
 
-	const (
-		DefaultProjectPath = "/opt/nano"
-	)
 	var projectPath string
-	var err error
-	projectPath, err = framework.InputString("Project Installed Path", DefaultProjectPath)
-	if err != nil{
-		fmt.Printf("get installed path fail: %s\n", err.Error())
-		return
-	}
-
-	if _, err = os.Stat(projectPath); os.IsNotExist(err){
-		fmt.Printf("project path '%s' not exists\n", projectPath)
+	var manifest *InstallManifest
+	if projectPath, manifest, err = resolveProjectPath(); err != nil{
 		return
 	}
 
 	var binaries []ModuleBinary
 
 	for _, moduleName := range moduleOrder{
-		if _, err = os.Stat(filepath.Join(projectPath, moduleName)); os.IsNotExist(err){
+		if nil != manifest{
+			if !manifest.HasModule(moduleName){
+				continue
+			}
+		}else if _, err = os.Stat(filepath.Join(projectPath, moduleName)); os.IsNotExist(err){
 			continue
-		}else if binary, exists := modules[moduleName]; exists{
+		}
+		if binary, exists := modules[moduleName]; exists{
 			binaries = append(binaries, binary)
 		}else{
-			fmt.Printf("invalid module '%s' in path '%s'\n", moduleName, projectPath)
+			err = fmt.Errorf("invalid module '%s' in path '%s'", moduleName, projectPath)
 			return
 		}
 	}
 
 	if 0 == len(binaries){
-		fmt.Println("no module binary available")
+		err = errors.New("no module binary available")
 		return
 	}
 	for _, binary := range binaries {
 		err = updateModule(projectPath, binary, forcibly)
 		if err != nil{
-			fmt.Printf("update module '%s' fail: %s\n", binary.Module, err.Error())
+			err = fmt.Errorf("update module '%s' fail: %s", binary.Module, err.Error())
+			return
+		}
+		if nil != manifest{
+			if err = manifest.RecordModule(projectPath, binary.Module, binary.Binary); err != nil{
+				return
+			}
+		}
+	}
+	if nil != manifest{
+		if err = manifest.Save(projectPath, &manifest.Session); err != nil{
+			err = fmt.Errorf("save install manifest fail: %s", err.Error())
 			return
 		}
 	}
 	fmt.Printf("%d module(s) updated success\n", len(binaries))
+	return nil
 }
 
 func updateModule(projectPath string, binary ModuleBinary, forcibly bool) (err error) {
@@ -180,16 +186,14 @@ func isModuleRunning(binaryPath string) (running bool, err error) {
 }
 
 func startModule(binaryPath string) (err error){
-	var cmd = exec.Command(binaryPath, "start")
-	var output bytes.Buffer
-	cmd.Stdout = &output
-	if err = cmd.Run(); err != nil{
+	output, err := host.ExecuteOutput(binaryPath, "start")
+	if err != nil{
 		return
 	}
 	const (
 		Keyword = "fail"
 	)
-	var content = output.String()
+	var content = string(output)
 	if strings.Contains(content, Keyword){
 		//fail
 		return errors.New(content)
@@ -198,16 +202,14 @@ func startModule(binaryPath string) (err error){
 }
 
 func stopModule(binaryPath string) (err error){
-	var cmd = exec.Command(binaryPath, "stop")
-	var output bytes.Buffer
-	cmd.Stdout = &output
-	if err = cmd.Run(); err != nil{
+	output, err := host.ExecuteOutput(binaryPath, "stop")
+	if err != nil{
 		return
 	}
 	const (
 		Keyword = "fail"
 	)
-	var content = output.String()
+	var content = string(output)
 	if strings.Contains(content, Keyword){
 		//fail
 		return errors.New(content)
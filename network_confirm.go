@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// NetworkSnapshot keeps addresses and default route before network changed, restored when connectivity lost
+type NetworkSnapshot struct {
+	Interface string
+	Addresses []netlink.Addr
+	Gateway   net.IP
+}
+
+func snapshotNetwork(interfaceName string) (snapshot NetworkSnapshot, err error) {
+	snapshot.Interface = interfaceName
+	var link netlink.Link
+	if link, err = netlink.LinkByName(interfaceName); err != nil {
+		return
+	}
+	var addresses []netlink.Addr
+	if addresses, err = netlink.AddrList(link, netlink.FAMILY_ALL); err != nil {
+		return
+	}
+	for _, address := range addresses {
+		if address.IP.IsGlobalUnicast() {
+			snapshot.Addresses = append(snapshot.Addresses, address)
+		}
+	}
+	var routes []netlink.Route
+	if routes, err = netlink.RouteList(nil, netlink.FAMILY_V4); err != nil {
+		return
+	}
+	for _, route := range routes {
+		if nil == route.Dst && nil != route.Gw {
+			snapshot.Gateway = route.Gw
+			break
+		}
+	}
+	return snapshot, nil
+}
+
+// restore adds addresses and default route back to interface when missing after configure reverted
+func (snapshot *NetworkSnapshot) restore() (err error) {
+	var link netlink.Link
+	if link, err = netlink.LinkByName(snapshot.Interface); err != nil {
+		return
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		return
+	}
+	for index := range snapshot.Addresses {
+		var address = snapshot.Addresses[index]
+		address.Label = ""
+		if err = netlink.AddrReplace(link, &address); err != nil {
+			return fmt.Errorf("restore address %s fail: %s", address.IPNet, err.Error())
+		}
+	}
+	if nil == snapshot.Gateway {
+		return nil
+	}
+	if current, _ := defaultRouteInterface(); "" != current {
+		return nil
+	}
+	var route = netlink.Route{LinkIndex: link.Attrs().Index, Gw: snapshot.Gateway}
+	if err = netlink.RouteReplace(&route); err != nil {
+		return fmt.Errorf("restore default route via %s fail: %s", snapshot.Gateway, err.Error())
+	}
+	fmt.Printf("default route via %s restored on %s\n", snapshot.Gateway, snapshot.Interface)
+	return nil
+}
+
+// networkConfirmTimeout returns zero when commit-confirm disabled
+func networkConfirmTimeout() (timeout time.Duration, err error) {
+	var value = optionalAnswer(AnswerNetworkConfirmTimeout, "0")
+	var seconds int
+	if seconds, err = strconv.Atoi(value); err != nil || seconds < 0 {
+		err = fmt.Errorf("invalid %s '%s', must be seconds", AnswerNetworkConfirmTimeout, value)
+		return
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// commitNetworkChange applies network change in a nested transaction, and keeps it only when
+// default gateway answers and operator confirms within timeout. Otherwise original configure restored.
+// Hangup ignored until finished, so that configure still restored when SSH session lost by the change
+func commitNetworkChange(interfaceName string, timeout time.Duration, apply func() error) (err error) {
+	if dryRunEnabled() {
+		fmt.Println("[dry-run] network change confirmation skipped")
+		return apply()
+	}
+	var snapshot NetworkSnapshot
+	if snapshot, err = snapshotNetwork(interfaceName); err != nil {
+		err = fmt.Errorf("snapshot network of %s fail: %s", interfaceName, err.Error())
+		return
+	}
+	//caught and dropped, broken pipe also caught for output piped to a process of lost session
+	var hangup = make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP, syscall.SIGPIPE)
+	defer signal.Stop(hangup)
+	var step = beginNestedTransaction()
+	if err = apply(); nil == err {
+		err = verifyNetworkChange(snapshot.Gateway, timeout)
+	}
+	if nil == err {
+		finishNestedTransaction(step, false)
+		fmt.Println("network change committed")
+		return nil
+	}
+	fmt.Printf("network change not committed: %s, restoring original configure...\n", err.Error())
+	finishNestedTransaction(step, true)
+	if restoreError := snapshot.restore(); restoreError != nil {
+		fmt.Printf("warning: restore network state fail: %s\n", restoreError.Error())
+	}
+	return fmt.Errorf("network change reverted: %s", err.Error())
+}
+
+func verifyNetworkChange(gateway net.IP, timeout time.Duration) (err error) {
+	if _, err = defaultRouteInterface(); err != nil {
+		return
+	}
+	if nil != gateway {
+		if err = pingGateway(gateway); err != nil {
+			return
+		}
+		fmt.Printf("default gateway %s answered\n", gateway)
+	}
+	if answers, ok := prompter.(*AnswerPrompter); ok && answers.Has(AnswerConfirmNetwork) {
+		var confirmed bool
+		if confirmed, err = answers.Confirm(AnswerConfirmNetwork, "keep network change"); err != nil {
+			return
+		} else if !confirmed {
+			return errors.New("network change declined")
+		}
+		return nil
+	}
+	return waitConsoleConfirm(fmt.Sprintf("network changed, enter 'yes' within %s to keep it", timeout), timeout)
+}
+
+func pingGateway(gateway net.IP) (err error) {
+	const (
+		Count   = "3"
+		Timeout = "2"
+	)
+	if _, err = exec.LookPath("ping"); err != nil {
+		fmt.Println("warning: ping not available, gateway check skipped")
+		return nil
+	}
+	if err = exec.Command("ping", "-c", Count, "-W", Timeout, gateway.String()).Run(); err != nil {
+		return fmt.Errorf("default gateway %s not answer", gateway)
+	}
+	return nil
+}
+
+// waitConsoleConfirm fails when operator not confirm in time, like when session lost
+func waitConsoleConfirm(description string, timeout time.Duration) error {
+	fmt.Printf("%s: ", description)
+	line, err := readConsoleLine(timeout)
+	if errors.Is(err, errInputTimeout) {
+		fmt.Println()
+		return fmt.Errorf("no confirmation in %s", timeout)
+	} else if err != nil {
+		return fmt.Errorf("read confirmation fail: %s", err.Error())
+	}
+	if "yes" != strings.ToLower(line) {
+		return errors.New("network change declined")
+	}
+	return nil
+}
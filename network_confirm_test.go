@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommitNetworkChangeIgnoreHangup(t *testing.T) {
+	var reverted = false
+	var err = commitNetworkChange("lo", time.Second, func() error {
+		registerUndo("change network", func(operator HostOperator) error {
+			reverted = true
+			return nil
+		})
+		//terminated here when hangup not ignored
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatalf("send hangup fail: %s", err.Error())
+		}
+		time.Sleep(100 * time.Millisecond)
+		return errors.New("connection lost")
+	})
+	if nil == err {
+		t.Fatal("network change committed")
+	}
+	if !reverted {
+		t.Fatal("network change not reverted after hangup")
+	}
+}
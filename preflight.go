@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runPreflight checks host before any change, returns error when any check failed
+func runPreflight(selected map[int]bool) (err error) {
+	var results = preflightCheck(selected)
+	fmt.Println("preflight check:")
+	printCheckResults(results)
+	var failed = 0
+	for _, result := range results {
+		if CheckFail == result.Status {
+			failed++
+		}
+	}
+	if 0 != failed {
+		err = fmt.Errorf("%d preflight check(s) failed", failed)
+		return
+	}
+	fmt.Println("preflight check passed")
+	return nil
+}
+
+func preflightCheck(selected map[int]bool) (results []CheckResult) {
+	var cellSelected = selected[ModuleCell]
+	results = append(results, checkOSRelease())
+	var binaries = []string{"update-ca-trust", "systemctl", "chown", "sysctl"}
+	if cellSelected {
+		binaries = append(binaries, "usermod", "groupadd", "udevadm")
+	}
+	results = append(results, checkRequiredBinaries(binaries))
+	results = append(results, checkFirewallBackend())
+	results = append(results, checkCertificateImports()...)
+	var ranges = defaultPortRanges()
+	for index := ModuleCore; index < ModuleExit; index++ {
+		if !selected[index] {
+			continue
+		}
+		var moduleName = strings.ToLower(moduleNames[index])
+		results = append(results, checkModulePayload(moduleName))
+		if ModuleCell == index {
+			results = append(results, checkDependencyPackages(moduleName))
+		}
+		switch index {
+		case ModuleCore:
+			ranges = append(ranges, corePortRanges()...)
+		case ModuleFrontEnd:
+			ranges = append(ranges, frontendPortRanges()...)
+		case ModuleCell:
+			ranges = append(ranges, cellPortRanges()...)
+		}
+	}
+	if cellSelected {
+		results = append(results, checkVirtualization())
+		results = append(results, checkBridges()...)
+	}
+	results = append(results, checkFreeDisk("/opt"))
+	results = append(results, checkPortConflicts(ranges))
+	return results
+}
+
+func checkOSRelease() CheckResult {
+	const (
+		Name        = "os release"
+		ReleaseFile = "/etc/os-release"
+	)
+	var supported = map[string]bool{"centos": true, "rhel": true, "rocky": true, "almalinux": true}
+	data, err := ioutil.ReadFile(ReleaseFile)
+	if err != nil {
+		return CheckResult{Name, CheckWarning, fmt.Sprintf("read %s fail: %s", ReleaseFile, err.Error())}
+	}
+	var values = map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if index := strings.Index(line, "="); index > 0 {
+			values[line[:index]] = strings.Trim(line[index+1:], `"'`)
+		}
+	}
+	var release = values["PRETTY_NAME"]
+	if "" == release {
+		release = values["ID"] + " " + values["VERSION_ID"]
+	}
+	if !supported[values["ID"]] {
+		return CheckResult{Name, CheckWarning, fmt.Sprintf("%s not tested", release)}
+	}
+	return CheckResult{Name, CheckPass, release}
+}
+
+func checkRequiredBinaries(binaries []string) CheckResult {
+	const (
+		Name = "required binaries"
+	)
+	var missing []string
+	for _, binary := range binaries {
+		if _, err := exec.LookPath(binary); err != nil {
+			missing = append(missing, binary)
+		}
+	}
+	if 0 != len(missing) {
+		return CheckResult{Name, CheckFail, fmt.Sprintf("%s not found", strings.Join(missing, ", "))}
+	}
+	return CheckResult{Name, CheckPass, strings.Join(binaries, ", ")}
+}
+
+func checkFirewallBackend() CheckResult {
+	const (
+		Name = "firewall"
+	)
+	firewall, err := newFirewall(optionalAnswer(AnswerFirewall, FirewallAuto), &SessionInfo{})
+	if err != nil {
+		return CheckResult{Name, CheckFail, err.Error()}
+	}
+	if err = firewall.Ready(); err != nil {
+		return CheckResult{Name, CheckWarning, fmt.Sprintf("%s not ready: %s", firewall.Name(), err.Error())}
+	}
+	return CheckResult{Name, CheckPass, fmt.Sprintf("%s ready", firewall.Name())}
+}
+
+// checkDependencyPackages validates rpm packages shipped with payload, installed from online repository when packages fail
+func checkDependencyPackages(moduleName string) CheckResult {
+	var name = "dependency packages " + moduleName
+	var packagePath = dependencyPackagePath(moduleName)
+	if info, err := os.Stat(packagePath); err != nil || !info.IsDir() {
+		return CheckResult{name, CheckFail, fmt.Sprintf("'%s' not available", packagePath)}
+	}
+	var packages, _ = filepath.Glob(filepath.Join(packagePath, "*.rpm"))
+	if 0 == len(packages) {
+		return CheckResult{name, CheckWarning, fmt.Sprintf("no package in '%s', online repository required", packagePath)}
+	}
+	return CheckResult{name, CheckPass, fmt.Sprintf("%d package(s) in '%s'", len(packages), packagePath)}
+}
+
+func checkModulePayload(moduleName string) CheckResult {
+	var name = "payload " + moduleName
+	var required = []string{filepath.Join(BinaryPathName, moduleName)}
+	if "frontend" == moduleName {
+		required = append(required, filepath.Join(BinaryPathName, FrontEndFilesPath, FrontEndWebPath))
+	}
+	for _, path := range required {
+		if _, err := os.Stat(path); err != nil {
+			return CheckResult{name, CheckFail, fmt.Sprintf("'%s' not available", path)}
+		}
+	}
+	return CheckResult{name, CheckPass, strings.Join(required, ", ")}
+}
+
+func checkVirtualization() CheckResult {
+	const (
+		Name    = "virtualization"
+		CPUInfo = "/proc/cpuinfo"
+	)
+	file, err := os.Open(CPUInfo)
+	if err != nil {
+		return CheckResult{Name, CheckFail, fmt.Sprintf("read %s fail: %s", CPUInfo, err.Error())}
+	}
+	defer file.Close()
+	var flag = ""
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() && "" == flag {
+		var line = scanner.Text()
+		if !strings.HasPrefix(line, "flags") {
+			continue
+		}
+		for _, value := range strings.Fields(line) {
+			if "vmx" == value || "svm" == value {
+				flag = value
+				break
+			}
+		}
+	}
+	if "" == flag {
+		return CheckResult{Name, CheckFail, "no vmx/svm flag in CPU, enable Intel VT-x/AMD-v in BIOS"}
+	}
+	if _, err = os.Stat(KVMDevice); err != nil {
+		return CheckResult{Name, CheckFail, fmt.Sprintf("CPU supports %s, but %s not available", flag, KVMDevice)}
+	}
+	return CheckResult{Name, CheckPass, fmt.Sprintf("CPU supports %s, %s available", flag, KVMDevice)}
+}
+
+// checkBridges validates bridges already exist, and checks network configure of uplink preset for others,
+// or interface of default route for the first bridge
+func checkBridges() (results []CheckResult) {
+	names, err := cellBridgeNames()
+	if err != nil {
+		return []CheckResult{{"bridge", CheckFail, err.Error()}}
+	}
+	for index, bridgeName := range names {
+		results = append(results, checkBridge(bridgeName, 0 == index))
+	}
+	return results
+}
+
+func checkBridge(bridgeName string, first bool) CheckResult {
+	var name = "bridge " + bridgeName
+	if _, err := net.InterfaceByName(bridgeName); nil == err {
+		if err = validateBridge(bridgeName, expectedBridgePort(bridgeName, first), first); err != nil {
+			return CheckResult{name, CheckFail, err.Error()}
+		}
+		return CheckResult{name, CheckPass, "already exists"}
+	}
+	var interfaceName = optionalAnswer(bridgeAnswerKey(AnswerBridgeInterface, bridgeName, first), "")
+	if "" == interfaceName && "" == optionalAnswer(bridgeAnswerKey(AnswerBondSlaves, bridgeName, first), "") {
+		if !first {
+			return CheckResult{name, CheckWarning, fmt.Sprintf("no uplink specified by '%s'", bridgeAnswerKey(AnswerBridgeInterface, bridgeName, first))}
+		}
+		var err error
+		if interfaceName, err = defaultRouteInterface(); err != nil {
+			return CheckResult{name, CheckWarning, err.Error()}
+		}
+	}
+	uplink, err := newUplink(bridgeName, first, interfaceName)
+	if err != nil {
+		return CheckResult{name, CheckFail, err.Error()}
+	}
+	configurator, err := newBridgeConfigurator(optionalAnswer(AnswerNetworkBackend, BridgeBackendAuto))
+	if err != nil {
+		return CheckResult{name, CheckFail, err.Error()}
+	}
+	if err = configurator.Check(uplink); err != nil {
+		return CheckResult{name, CheckFail, fmt.Sprintf("%s not available for %s: %s", uplink.String(), configurator.Name(), err.Error())}
+	}
+	return CheckResult{name, CheckPass, fmt.Sprintf("%s configured by %s", uplink.String(), configurator.Name())}
+}
+
+func defaultRouteInterface() (name string, err error) {
+	var routes []netlink.Route
+	if routes, err = netlink.RouteList(nil, netlink.FAMILY_V4); err != nil {
+		return
+	}
+	for _, route := range routes {
+		if nil == route.Dst {
+			var link netlink.Link
+			if link, err = netlink.LinkByIndex(route.LinkIndex); err != nil {
+				return
+			}
+			return link.Attrs().Name, nil
+		}
+	}
+	err = errors.New("no default route available")
+	return
+}
+
+func checkFreeDisk(path string) CheckResult {
+	const (
+		GiB          = 1 << 30
+		RequiredSize = 1 * GiB
+		WarningSize  = 5 * GiB
+	)
+	var name = "free disk " + path
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return CheckResult{name, CheckFail, fmt.Sprintf("stat fail: %s", err.Error())}
+	}
+	var available = stat.Bavail * uint64(stat.Bsize)
+	var detail = fmt.Sprintf("%.1f GiB available", float64(available)/GiB)
+	if available < RequiredSize {
+		return CheckResult{name, CheckFail, detail}
+	} else if available < WarningSize {
+		return CheckResult{name, CheckWarning, detail}
+	}
+	return CheckResult{name, CheckPass, detail}
+}
+
+// checkPortConflicts finds listening sockets in planned ranges
+func checkPortConflicts(ranges []PortRange) CheckResult {
+	const (
+		Name = "port conflicts"
+	)
+	var conflicts []string
+	for _, protocol := range []string{"tcp", "udp"} {
+		var listening = listeningPorts(protocol)
+		for _, ports := range ranges {
+			if protocol != ports.Protocol {
+				continue
+			}
+			for port := ports.Begin; port <= ports.End; port++ {
+				if listening[port] {
+					conflicts = append(conflicts, fmt.Sprintf("%d/%s", port, protocol))
+				}
+			}
+		}
+	}
+	if 0 != len(conflicts) {
+		return CheckResult{Name, CheckWarning, fmt.Sprintf("%s already in use", strings.Join(conflicts, ", "))}
+	}
+	return CheckResult{Name, CheckPass, fmt.Sprintf("%d range(s) available", len(ranges))}
+}
+
+// listeningPorts parses /proc/net, returns local ports of listening tcp or bound udp sockets
+func listeningPorts(protocol string) (ports map[int]bool) {
+	const (
+		TCPListenState = "0A"
+		LocalAddress   = 1
+		SocketState    = 3
+	)
+	ports = map[int]bool{}
+	for _, filename := range []string{"/proc/net/" + protocol, "/proc/net/" + protocol + "6"} {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		var lines = strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			var fields = strings.Fields(line)
+			if len(fields) <= SocketState {
+				continue
+			}
+			if "tcp" == protocol && TCPListenState != fields[SocketState] {
+				continue
+			}
+			var address = fields[LocalAddress]
+			var index = strings.LastIndex(address, ":")
+			if -1 == index {
+				continue
+			}
+			if port, err := strconv.ParseInt(address[index+1:], 16, 32); nil == err {
+				ports[int(port)] = true
+			}
+		}
+	}
+	return ports
+}
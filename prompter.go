@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/project-nano/framework"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//keys of answer file
+const (
+	AnswerModules                = "modules"
+	AnswerProjectPath            = "project_path"
+	AnswerUser                   = "user"
+	AnswerDomain                 = "domain"
+	AnswerGroupAddress           = "group_address"
+	AnswerGroupPort              = "group_port"
+	AnswerListenAddress          = "listen_address"
+	AnswerAPIAddress             = "api_address"
+	AnswerAPIPort                = "api_port"
+	AnswerPortalPort             = "portal_port"
+	AnswerBridgeInterface        = "bridge_interface"
+	AnswerUplinkType             = "uplink_type"
+	AnswerConfirmBridge          = "confirm_bridge"
+	AnswerIgnoreFirewalld        = "continue_without_firewalld"
+	AnswerIgnoreDependencyFailed = "continue_on_dependency_failure"
+	AnswerConfirmUninstall       = "confirm_uninstall"
+	AnswerFirewall               = "firewall"
+	AnswerFirewallZone           = "firewall_zone"
+	AnswerFirewallSources        = "firewall_sources"
+	AnswerNetworkBackend         = "network_backend"
+	AnswerNetworkConfirmTimeout  = "network_confirm_timeout"
+	AnswerConfirmNetwork         = "confirm_network"
+	AnswerBondSlaves             = "bond_slaves"
+	AnswerBondName               = "bond_name"
+	AnswerBondMode               = "bond_mode"
+	AnswerBridgeVLAN             = "bridge_vlan"
+	AnswerBridgeName             = "bridge_name"
+	AnswerBridges                = "bridges"
+	AnswerCertKeyType            = "cert_key_type"
+	AnswerCALifetimeDays         = "ca_lifetime_days"
+	AnswerCertLifetimeDays       = "cert_lifetime_days"
+	AnswerCertNames              = "cert_names"
+	AnswerCACertFile             = "ca_cert_file"
+	AnswerCAKeyFile              = "ca_key_file"
+	AnswerImageCertFile          = "image_cert_file"
+	AnswerImageKeyFile           = "image_key_file"
+	AnswerAPICertFile            = "api_cert_file"
+	AnswerAPIKeyFile             = "api_key_file"
+	AnswerPortalCertFile         = "portal_cert_file"
+	AnswerPortalKeyFile          = "portal_key_file"
+	AnswerCAKeyPassphraseFile    = "ca_key_passphrase_file"
+	AnswerCAKeyOffline           = "ca_key_offline"
+)
+
+// Prompter supplies every value that the installer requires from operator,
+// key identifies the value in an answer file
+type Prompter interface {
+	InputString(key, description, defaultValue string) (string, error)
+	InputIPAddress(key, description, defaultValue string) (string, error)
+	InputMultiCastAddress(key, description, defaultValue string) (string, error)
+	InputNetworkPort(key, description string, defaultValue int) (int, error)
+	ChooseIPV4Address(key, description string) (string, error)
+	SelectEthernetInterface(key, description string, requireUpLink bool) (string, error)
+	// ChooseOption selects one of options, default value is selected when operator inputs nothing
+	ChooseOption(key, description string, options []string, defaultValue string) (string, error)
+	// InputPassword reads secret never echoed or kept in answers, answer of key is a file holding it.
+	// A new password is optional and confirmed by inputting twice
+	InputPassword(key, description string, newPassword bool) (string, error)
+	Confirm(key, description string) (bool, error)
+}
+
+var prompter Prompter = &ConsolePrompter{}
+
+// ConsolePrompter asks operator on console
+type ConsolePrompter struct {
+}
+
+func (prompter *ConsolePrompter) InputString(key, description, defaultValue string) (string, error) {
+	return framework.InputString(description, defaultValue)
+}
+
+func (prompter *ConsolePrompter) InputIPAddress(key, description, defaultValue string) (string, error) {
+	return framework.InputIPAddress(description, defaultValue)
+}
+
+func (prompter *ConsolePrompter) InputMultiCastAddress(key, description, defaultValue string) (string, error) {
+	return framework.InputMultiCastAddress(description, defaultValue)
+}
+
+func (prompter *ConsolePrompter) InputNetworkPort(key, description string, defaultValue int) (int, error) {
+	return framework.InputNetworkPort(description, defaultValue)
+}
+
+func (prompter *ConsolePrompter) ChooseIPV4Address(key, description string) (string, error) {
+	return framework.ChooseIPV4Address(description)
+}
+
+func (prompter *ConsolePrompter) SelectEthernetInterface(key, description string, requireUpLink bool) (string, error) {
+	return framework.SelectEthernetInterface(description, requireUpLink)
+}
+
+func (prompter *ConsolePrompter) ChooseOption(key, description string, options []string, defaultValue string) (string, error) {
+	if 0 == len(options) {
+		return "", fmt.Errorf("no option available for %s", description)
+	}
+	for {
+		for index, option := range options {
+			fmt.Printf("%d> %s\n", index, option)
+		}
+		fmt.Printf("enter index or name to select %s (press enter to accept '%s'): ", description, defaultValue)
+		var input string
+		fmt.Scanln(&input)
+		if "" == input {
+			if "" == defaultValue {
+				return "", fmt.Errorf("no %s selected", description)
+			}
+			return defaultValue, nil
+		}
+		if index, err := strconv.Atoi(input); nil == err && index >= 0 && index < len(options) {
+			return options[index], nil
+		}
+		for _, option := range options {
+			if input == option {
+				return option, nil
+			}
+		}
+		fmt.Printf("invalid selection: %s\n", input)
+	}
+}
+
+func (prompter *ConsolePrompter) InputPassword(key, description string, newPassword bool) (password string, err error) {
+	if newPassword {
+		fmt.Printf("%s (press enter to skip): ", description)
+	} else {
+		fmt.Printf("%s: ", description)
+	}
+	if password, err = readConsolePassword(); err != nil {
+		return
+	}
+	if "" == password {
+		if newPassword {
+			return "", nil
+		}
+		return "", fmt.Errorf("no %s input", description)
+	}
+	if newPassword {
+		fmt.Printf("input %s again to confirm: ", description)
+		var confirmed string
+		if confirmed, err = readConsolePassword(); err != nil {
+			return
+		}
+		if confirmed != password {
+			return "", fmt.Errorf("%s not match", description)
+		}
+	}
+	return password, nil
+}
+
+func (prompter *ConsolePrompter) Confirm(key, description string) (confirmed bool, err error) {
+	fmt.Printf("%s, input 'yes' to confirm: ", description)
+	var input string
+	fmt.Scanln(&input)
+	return isPositiveAnswer(input), nil
+}
+
+// AnswerPrompter answers with preset values, which loaded from an answer file or command line flags.
+// A missing key is an error unless a fallback prompter available
+type AnswerPrompter struct {
+	source   string
+	values   map[string]string
+	fallback Prompter
+}
+
+func NewAnswerPrompter(source string, fallback Prompter) *AnswerPrompter {
+	return &AnswerPrompter{source: source, values: map[string]string{}, fallback: fallback}
+}
+
+func LoadAnswerFile(filename string, fallback Prompter) (prompter *AnswerPrompter, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var content = map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &content)
+	case ".json":
+		err = json.Unmarshal(data, &content)
+	default:
+		err = fmt.Errorf("unsupported answer file '%s', must be .json/.yaml/.yml", filename)
+	}
+	if err != nil {
+		return
+	}
+	prompter = NewAnswerPrompter(fmt.Sprintf("answer file '%s'", filename), fallback)
+	for key, value := range content {
+		var text string
+		if text, err = answerToString(value); err != nil {
+			err = fmt.Errorf("invalid value of key '%s' in '%s': %s", key, filename, err.Error())
+			return
+		}
+		prompter.values[key] = text
+	}
+	fmt.Printf("%d answers loaded from '%s'\n", len(prompter.values), filename)
+	return prompter, nil
+}
+
+func (prompter *AnswerPrompter) InputString(key, description, defaultValue string) (value string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.InputString(key, description, defaultValue)
+	}
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	if "" == value {
+		err = fmt.Errorf("empty value of key '%s' not allowed", key)
+		return
+	}
+	fmt.Printf("%s = '%s'\n", description, value)
+	return value, nil
+}
+
+func (prompter *AnswerPrompter) InputIPAddress(key, description, defaultValue string) (value string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.InputIPAddress(key, description, defaultValue)
+	}
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	if nil == net.ParseIP(value) {
+		err = fmt.Errorf("invalid address '%s' of key '%s'", value, key)
+		return
+	}
+	fmt.Printf("%s = '%s'\n", description, value)
+	return value, nil
+}
+
+func (prompter *AnswerPrompter) InputMultiCastAddress(key, description, defaultValue string) (value string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.InputMultiCastAddress(key, description, defaultValue)
+	}
+	if value, err = prompter.InputIPAddress(key, description, defaultValue); err != nil {
+		return
+	}
+	if !net.ParseIP(value).IsMulticast() {
+		err = fmt.Errorf("'%s' of key '%s' not a multicast address", value, key)
+		return
+	}
+	return value, nil
+}
+
+func (prompter *AnswerPrompter) InputNetworkPort(key, description string, defaultValue int) (port int, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.InputNetworkPort(key, description, defaultValue)
+	}
+	const (
+		MaxPort = 0xFFFF
+	)
+	var value string
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	if port, err = strconv.Atoi(value); err != nil {
+		err = fmt.Errorf("invalid port '%s' of key '%s'", value, key)
+		return
+	}
+	if port <= 0 || port > MaxPort {
+		err = fmt.Errorf("invalid network port %d of key '%s'", port, key)
+		return
+	}
+	fmt.Printf("%s = %d\n", description, port)
+	return port, nil
+}
+
+func (prompter *AnswerPrompter) ChooseIPV4Address(key, description string) (value string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.ChooseIPV4Address(key, description)
+	}
+	if value, err = prompter.InputIPAddress(key, description, ""); err != nil {
+		return
+	}
+	if nil == net.ParseIP(value).To4() {
+		err = fmt.Errorf("'%s' of key '%s' not an IPv4 address", value, key)
+		return
+	}
+	return value, nil
+}
+
+func (prompter *AnswerPrompter) SelectEthernetInterface(key, description string, requireUpLink bool) (name string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.SelectEthernetInterface(key, description, requireUpLink)
+	}
+	if name, err = prompter.lookup(key); err != nil {
+		return
+	}
+	var link *net.Interface
+	if link, err = net.InterfaceByName(name); err != nil {
+		err = fmt.Errorf("invalid interface '%s' of key '%s': %s", name, key, err.Error())
+		return
+	}
+	if requireUpLink && 0 == link.Flags&net.FlagUp {
+		err = fmt.Errorf("interface '%s' of key '%s' is down", name, key)
+		return
+	}
+	fmt.Printf("%s = '%s'\n", description, name)
+	return name, nil
+}
+
+func (prompter *AnswerPrompter) ChooseOption(key, description string, options []string, defaultValue string) (value string, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.ChooseOption(key, description, options, defaultValue)
+	}
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	for _, option := range options {
+		if value == option {
+			fmt.Printf("%s = '%s'\n", description, value)
+			return value, nil
+		}
+	}
+	err = fmt.Errorf("invalid value '%s' of key '%s', must be %s", value, key, strings.Join(options, "/"))
+	return
+}
+
+func (prompter *AnswerPrompter) InputPassword(key, description string, newPassword bool) (password string, err error) {
+	if !prompter.Has(key) {
+		if nil != prompter.fallback {
+			return prompter.fallback.InputPassword(key, description, newPassword)
+		} else if newPassword {
+			//optional
+			return "", nil
+		}
+	}
+	var filename string
+	if filename, err = prompter.lookup(key); err != nil {
+		return
+	}
+	var info os.FileInfo
+	if info, err = os.Stat(filename); err != nil {
+		err = fmt.Errorf("invalid file '%s' of key '%s': %s", filename, key, err.Error())
+		return
+	}
+	if 0 != info.Mode().Perm()&0077 {
+		fmt.Printf("warning: '%s' accessible by other users, restrict it to mode 0600\n", filename)
+	}
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	if password = strings.TrimRight(string(data), "\r\n"); "" == password {
+		err = fmt.Errorf("empty %s in '%s'", description, filename)
+		return
+	}
+	fmt.Printf("%s loaded from '%s'\n", description, filename)
+	return password, nil
+}
+
+func (prompter *AnswerPrompter) Confirm(key, description string) (confirmed bool, err error) {
+	if !prompter.Has(key) && nil != prompter.fallback {
+		return prompter.fallback.Confirm(key, description)
+	}
+	var value string
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	confirmed = isPositiveAnswer(value)
+	fmt.Printf("%s: %t\n", description, confirmed)
+	return confirmed, nil
+}
+
+// StringList returns comma split value of key
+func (prompter *AnswerPrompter) StringList(key string) (list []string, err error) {
+	var value string
+	if value, err = prompter.lookup(key); err != nil {
+		return
+	}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); "" != item {
+			list = append(list, item)
+		}
+	}
+	if 0 == len(list) {
+		err = fmt.Errorf("empty value of key '%s' not allowed", key)
+		return
+	}
+	return list, nil
+}
+
+// Set overrides value of key
+func (prompter *AnswerPrompter) Set(key, value string) {
+	prompter.values[key] = value
+}
+
+// Has checks if key preset
+func (prompter *AnswerPrompter) Has(key string) bool {
+	_, exists := prompter.values[key]
+	return exists
+}
+
+// optionalAnswer returns value preset in answers, or default value without asking operator
+func optionalAnswer(key, defaultValue string) string {
+	if answers, ok := prompter.(*AnswerPrompter); ok && answers.Has(key) {
+		if value, err := answers.lookup(key); nil == err && "" != value {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func (prompter *AnswerPrompter) lookup(key string) (value string, err error) {
+	var exists bool
+	if value, exists = prompter.values[key]; !exists {
+		err = fmt.Errorf("key '%s' required in %s", key, prompter.source)
+		return
+	}
+	return value, nil
+}
+
+func answerToString(value interface{}) (text string, err error) {
+	switch data := value.(type) {
+	case string:
+		return data, nil
+	case bool:
+		if data {
+			return "yes", nil
+		}
+		return "no", nil
+	case int:
+		return strconv.Itoa(data), nil
+	case float64:
+		return strconv.FormatFloat(data, 'f', -1, 64), nil
+	case []interface{}:
+		var items []string
+		for _, item := range data {
+			var itemText string
+			if itemText, err = answerToString(item); err != nil {
+				return
+			}
+			items = append(items, itemText)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		err = fmt.Errorf("unsupported type %T", value)
+		return
+	}
+}
+
+func isPositiveAnswer(input string) bool {
+	var answer = strings.ToLower(strings.TrimSpace(input))
+	return "y" == answer || "yes" == answer || "true" == answer
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnswerInputPassword(t *testing.T) {
+	var passwordFile = filepath.Join(t.TempDir(), "passphrase")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret phrase\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var emptyFile = filepath.Join(t.TempDir(), "empty")
+	if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		name        string
+		values      map[string]string
+		newPassword bool
+		expected    string
+		expectFail  bool
+	}{
+		{"read from file", map[string]string{AnswerCAKeyPassphraseFile: passwordFile}, false, "secret phrase", false},
+		{"new password from file", map[string]string{AnswerCAKeyPassphraseFile: passwordFile}, true, "secret phrase", false},
+		{"new password optional", nil, true, "", false},
+		{"password required", nil, false, "", true},
+		{"empty file", map[string]string{AnswerCAKeyPassphraseFile: emptyFile}, false, "", true},
+		{"missing file", map[string]string{AnswerCAKeyPassphraseFile: passwordFile + ".missing"}, false, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var answers = NewAnswerPrompter("test answers", nil)
+			for key, value := range c.values {
+				answers.Set(key, value)
+			}
+			password, err := answers.InputPassword(AnswerCAKeyPassphraseFile, "passphrase", c.newPassword)
+			if c.expectFail {
+				if nil == err {
+					t.Fatal("expect error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if c.expected != password {
+				t.Fatalf("expect '%s', but got '%s'", c.expected, password)
+			}
+		})
+	}
+}
+
+func TestCAKeyPassphraseFromEnvironment(t *testing.T) {
+	var origin = loadedCAKeyPassphrase
+	loadedCAKeyPassphrase = nil
+	defer func() { loadedCAKeyPassphrase = origin }()
+	useAnswers(t, nil)
+	if err := os.Setenv(CAKeyPassphraseEnv, "from environment"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(CAKeyPassphraseEnv)
+	passphrase, err := caKeyPassphrase(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if "from environment" != passphrase {
+		t.Fatalf("unexpected passphrase '%s'", passphrase)
+	}
+}
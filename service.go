@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ServiceManager controls system services, modifications go through current host operator
+type ServiceManager interface {
+	Enable(name string) error
+	Disable(name string) error
+	Start(name string) error
+	Stop(name string) error
+	Restart(name string) error
+	IsActive(name string) (bool, error)
+	IsEnabled(name string) (bool, error)
+}
+
+var services ServiceManager = &SystemdManager{}
+
+// SystemdManager controls services by systemctl, states checked by exit code instead of human-oriented output,
+// so that it works across systemd versions and locales
+type SystemdManager struct {
+}
+
+func (manager *SystemdManager) Enable(name string) error {
+	return host.Execute("systemctl", "enable", name)
+}
+
+func (manager *SystemdManager) Disable(name string) error {
+	return host.Execute("systemctl", "disable", name)
+}
+
+func (manager *SystemdManager) Start(name string) error {
+	return host.Execute("systemctl", "start", name)
+}
+
+func (manager *SystemdManager) Stop(name string) error {
+	return host.Execute("systemctl", "stop", name)
+}
+
+func (manager *SystemdManager) Restart(name string) error {
+	return host.Execute("systemctl", "restart", name)
+}
+
+func (manager *SystemdManager) IsActive(name string) (bool, error) {
+	return manager.query("is-active", name)
+}
+
+func (manager *SystemdManager) IsEnabled(name string) (bool, error) {
+	return manager.query("is-enabled", name)
+}
+
+// query returns true when systemctl exits with 0, any other exit code means false
+func (manager *SystemdManager) query(verb, name string) (matched bool, err error) {
+	if matched, err = queryCommand("systemctl", verb, "--quiet", name); nil == err {
+		return
+	}
+	return false, fmt.Errorf("systemctl %s %s fail: %s", verb, name, err.Error())
+}
+
+// FakeServiceManager keeps service states in memory and records every operation, used for tests
+type FakeServiceManager struct {
+	Active     map[string]bool
+	Enabled    map[string]bool
+	Operations []string
+	Failures   map[string]error
+}
+
+func NewFakeServiceManager() *FakeServiceManager {
+	return &FakeServiceManager{Active: map[string]bool{}, Enabled: map[string]bool{}, Failures: map[string]error{}}
+}
+
+func (manager *FakeServiceManager) Enable(name string) error {
+	return manager.apply("enable", name, func() { manager.Enabled[name] = true })
+}
+
+func (manager *FakeServiceManager) Disable(name string) error {
+	return manager.apply("disable", name, func() { manager.Enabled[name] = false })
+}
+
+func (manager *FakeServiceManager) Start(name string) error {
+	return manager.apply("start", name, func() { manager.Active[name] = true })
+}
+
+func (manager *FakeServiceManager) Stop(name string) error {
+	return manager.apply("stop", name, func() { manager.Active[name] = false })
+}
+
+func (manager *FakeServiceManager) Restart(name string) error {
+	return manager.apply("restart", name, func() { manager.Active[name] = true })
+}
+
+func (manager *FakeServiceManager) IsActive(name string) (bool, error) {
+	return manager.Active[name], manager.Failures["is-active "+name]
+}
+
+func (manager *FakeServiceManager) IsEnabled(name string) (bool, error) {
+	return manager.Enabled[name], manager.Failures["is-enabled "+name]
+}
+
+// apply records operation, fails when an error preset for "<verb> <name>"
+func (manager *FakeServiceManager) apply(verb, name string, change func()) error {
+	var operation = verb + " " + name
+	manager.Operations = append(manager.Operations, operation)
+	if err, exists := manager.Failures[operation]; exists {
+		return err
+	}
+	change()
+	return nil
+}
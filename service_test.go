@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// useFakeServices replaces service manager by a fake one until test finished
+func useFakeServices(t *testing.T) *FakeServiceManager {
+	var fake = NewFakeServiceManager()
+	var origin = services
+	services = fake
+	t.Cleanup(func() { services = origin })
+	return fake
+}
+
+// useAnswers replaces prompter by answers specified until test finished
+func useAnswers(t *testing.T, values map[string]string) {
+	var answers = NewAnswerPrompter("test answers", &ConsolePrompter{})
+	for key, value := range values {
+		answers.Set(key, value)
+	}
+	var origin = prompter
+	prompter = answers
+	t.Cleanup(func() { prompter = origin })
+}
+
+func TestFirewalldReady(t *testing.T) {
+	var queryFailure = errors.New("dbus timeout")
+	var cases = []struct {
+		name     string
+		active   bool
+		enabled  bool
+		failures map[string]error
+		expected string
+	}{
+		{"stopped", false, true, nil, "firewalld service is stopped"},
+		{"disabled", true, false, nil, "firewalld service disabled"},
+		{"ready", true, true, nil, ""},
+		{"query active fail", true, true, map[string]error{"is-active firewalld": queryFailure}, queryFailure.Error()},
+		{"query enabled fail", true, true, map[string]error{"is-enabled firewalld": queryFailure}, queryFailure.Error()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fake = useFakeServices(t)
+			fake.Active[FirewallFirewalld] = c.active
+			fake.Enabled[FirewallFirewalld] = c.enabled
+			for operation, err := range c.failures {
+				fake.Failures[operation] = err
+			}
+			var err = (&FirewalldFirewall{&SessionInfo{}}).Ready()
+			if "" == c.expected {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+			} else if nil == err || c.expected != err.Error() {
+				t.Fatalf("expect error '%s', but got %v", c.expected, err)
+			}
+		})
+	}
+}
+
+func TestCheckFirewallBackend(t *testing.T) {
+	var cases = []struct {
+		name     string
+		firewall string
+		active   bool
+		enabled  bool
+		expected string
+	}{
+		{"firewalld ready", FirewallFirewalld, true, true, CheckPass},
+		{"firewalld stopped", FirewallFirewalld, false, true, CheckWarning},
+		{"firewalld disabled", FirewallFirewalld, true, false, CheckWarning},
+		{"detect running firewalld", FirewallAuto, true, true, CheckPass},
+		{"invalid backend", "ufw", true, true, CheckFail},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fake = useFakeServices(t)
+			fake.Active[FirewallFirewalld] = c.active
+			fake.Enabled[FirewallFirewalld] = c.enabled
+			useAnswers(t, map[string]string{AnswerFirewall: c.firewall})
+			var result = checkFirewallBackend()
+			if c.expected != result.Status {
+				t.Fatalf("expect status %s, but got %s: %s", c.expected, result.Status, result.Detail)
+			}
+			if CheckPass == c.expected && "firewalld ready" != result.Detail {
+				t.Fatalf("unexpected detail: %s", result.Detail)
+			}
+		})
+	}
+}
+
+func TestStartLibvirtService(t *testing.T) {
+	var cases = []struct {
+		name          string
+		active        bool
+		configChanged bool
+		failures      map[string]error
+		expectFail    bool
+		operations    []string
+	}{
+		{"start stopped", false, false, nil, false, []string{"enable libvirtd", "start libvirtd"}},
+		{"keep running", true, false, nil, false, []string{"enable libvirtd"}},
+		{"restart for configure changed", true, true, nil, false, []string{"enable libvirtd", "restart libvirtd"}},
+		{"enable fail", false, false, map[string]error{"enable libvirtd": errors.New("masked")}, true,
+			[]string{"enable libvirtd"}},
+		{"start fail", false, false, map[string]error{"start libvirtd": errors.New("timeout")}, true,
+			[]string{"enable libvirtd", "start libvirtd"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fake = useFakeServices(t)
+			fake.Active["libvirtd"] = c.active
+			for operation, err := range c.failures {
+				fake.Failures[operation] = err
+			}
+			var err = startLibvirtService(c.configChanged)
+			if c.expectFail != (err != nil) {
+				t.Fatalf("expect fail %t, but got %v", c.expectFail, err)
+			}
+			if !reflect.DeepEqual(c.operations, fake.Operations) {
+				t.Fatalf("expect operations %v, but got %v", c.operations, fake.Operations)
+			}
+			if !c.expectFail && !fake.Active["libvirtd"] {
+				t.Fatal("libvirtd not active")
+			}
+		})
+	}
+}
+
+func TestStopNetworkManager(t *testing.T) {
+	const service = "NetworkManager"
+	var cases = []struct {
+		name       string
+		active     bool
+		enabled    bool
+		operations []string
+		reverted   []string
+	}{
+		{"running and enabled", true, true, []string{"stop " + service, "disable " + service},
+			[]string{"enable " + service, "start " + service}},
+		{"stopped but enabled", false, true, []string{"disable " + service}, []string{"enable " + service}},
+		{"running but disabled", true, false, []string{"stop " + service}, []string{"start " + service}},
+		{"stopped and disabled", false, false, nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fake = useFakeServices(t)
+			fake.Active[service] = c.active
+			fake.Enabled[service] = c.enabled
+			var transaction = beginTransaction()
+			stopNetworkManager()
+			finishTransaction(transaction, false)
+			if !reflect.DeepEqual(c.operations, fake.Operations) {
+				t.Fatalf("expect operations %v, but got %v", c.operations, fake.Operations)
+			}
+			if fake.Active[service] || fake.Enabled[service] {
+				t.Fatal("network manager not stopped and disabled")
+			}
+			fake.Operations = nil
+			transaction.Rollback()
+			if !reflect.DeepEqual(c.reverted, fake.Operations) {
+				t.Fatalf("expect reverted %v, but got %v", c.reverted, fake.Operations)
+			}
+			if c.active != fake.Active[service] || c.enabled != fake.Enabled[service] {
+				t.Fatalf("expect active %t enabled %t, but got %t %t", c.active, c.enabled,
+					fake.Active[service], fake.Enabled[service])
+			}
+		})
+	}
+}
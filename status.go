@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	CheckPass    = "pass"
+	CheckWarning = "warn"
+	CheckFail    = "fail"
+)
+
+// CheckResult is the result of checking one item on host
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type ModuleStatus struct {
+	Name            string `json:"name"`
+	Binary          string `json:"binary"`
+	Running         bool   `json:"running"`
+	SHA256          string `json:"sha256"`
+	PayloadSHA256   string `json:"payload_sha256,omitempty"`
+	UpToDate        bool   `json:"up_to_date"`
+	ManifestMatched bool   `json:"manifest_matched"`
+}
+
+type CertificateStatus struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+type NodeStatus struct {
+	ProjectPath      string              `json:"project_path"`
+	InstallerVersion string              `json:"installer_version,omitempty"`
+	NanoVersion      string              `json:"nano_version,omitempty"`
+	Healthy          bool                `json:"healthy"`
+	Modules          []ModuleStatus      `json:"modules"`
+	Checks           []CheckResult       `json:"checks"`
+	Certificates     []CertificateStatus `json:"certificates"`
+}
+
+func (status *NodeStatus) addCheck(name, result, format string, args ...interface{}) {
+	status.Checks = append(status.Checks, CheckResult{name, result, fmt.Sprintf(format, args...)})
+	if CheckFail == result {
+		status.Healthy = false
+	}
+}
+
+// ReportNodeStatus checks install state of local node, print as table or JSON
+func ReportNodeStatus(outputJSON bool) (err error) {
+	var output = os.Stdout
+	if outputJSON {
+		//keep progress message out of JSON
+		os.Stdout = os.Stderr
+		defer func() {
+			os.Stdout = output
+		}()
+	}
+	var projectPath string
+	var manifest *InstallManifest
+	if projectPath, manifest, err = resolveProjectPath(); err != nil {
+		return
+	}
+	var status = NodeStatus{ProjectPath: projectPath, Healthy: true}
+	var expectedPorts = defaultPortRanges()
+	var modulePorts = map[string][]PortRange{
+		"core":     corePortRanges(),
+		"frontend": frontendPortRanges(),
+		"cell":     cellPortRanges(),
+	}
+	if nil != manifest {
+		status.InstallerVersion = manifest.InstallerVersion
+		status.NanoVersion = manifest.NanoVersion
+		expectedPorts = manifest.Ports
+	} else {
+		status.addCheck("manifest", CheckWarning, "no install manifest in '%s'", projectPath)
+	}
+	var cellInstalled = false
+	for _, moduleName := range []string{"core", "cell", "frontend"} {
+		var binaryPath = filepath.Join(projectPath, moduleName, moduleName)
+		if _, err = os.Stat(binaryPath); os.IsNotExist(err) {
+			if nil != manifest && manifest.HasModule(moduleName) {
+				status.addCheck("module "+moduleName, CheckFail, "binary '%s' missing", binaryPath)
+			}
+			continue
+		}
+		if nil == manifest {
+			expectedPorts = append(expectedPorts, modulePorts[moduleName]...)
+		}
+		if "cell" == moduleName {
+			cellInstalled = true
+		}
+		status.Modules = append(status.Modules, checkModuleStatus(&status, manifest, moduleName, binaryPath))
+	}
+	if cellInstalled {
+		var bridges = []CellBridge{{Name: DefaultBridgeName}}
+		if nil != manifest && 0 != len(installedBridges(&manifest.Session)) {
+			bridges = installedBridges(&manifest.Session)
+		}
+		checkBridgeRoute(&status, bridges[0].Name)
+		for _, bridge := range bridges[1:] {
+			checkBridgeMembers(&status, bridge)
+		}
+		checkServiceActive(&status, "libvirtd")
+		checkKVMOwner(&status, manifest)
+	}
+	checkFirewallPorts(&status, manifest, expectedPorts)
+	checkKernelParameters(&status)
+
+	for _, installed := range installedCertificateFiles(projectPath) {
+		var name, certFile = installed.Name, installed.File
+		if _, err = os.Stat(certFile); os.IsNotExist(err) {
+			continue
+		}
+		var certificate *x509.Certificate
+		if certificate, err = loadCertificate(certFile); err != nil {
+			status.addCheck("certificate "+name, CheckFail, "load '%s' fail: %s", certFile, err.Error())
+			continue
+		}
+		var daysLeft = int(time.Until(certificate.NotAfter).Hours() / 24)
+		status.Certificates = append(status.Certificates, CertificateStatus{name, certFile,
+			certificate.Subject.CommonName, certificate.NotAfter, daysLeft})
+		if daysLeft < 0 {
+			status.addCheck("certificate "+name, CheckFail, "expired at %s", certificate.NotAfter.Format(time.RFC3339))
+		} else if daysLeft < DefaultExpiryWarningDays {
+			status.addCheck("certificate "+name, CheckWarning, "expire in %d days", daysLeft)
+		} else {
+			status.addCheck("certificate "+name, CheckPass, "expire at %s", certificate.NotAfter.Format("2006-01-02"))
+		}
+	}
+	checkCAKey(&status, manifest, projectPath)
+
+	if outputJSON {
+		var data []byte
+		if data, err = json.MarshalIndent(status, "", " "); err != nil {
+			return
+		}
+		fmt.Fprintln(output, string(data))
+		return nil
+	}
+	fmt.Printf("project path: %s\n", status.ProjectPath)
+	if "" != status.InstallerVersion {
+		fmt.Printf("installed by installer v%s, nano v%s\n", status.InstallerVersion, status.NanoVersion)
+	}
+	fmt.Println("\nmodules:")
+	for _, module := range status.Modules {
+		var state = "stopped"
+		if module.Running {
+			state = "running"
+		}
+		var version = "up to date"
+		if !module.UpToDate {
+			version = "differs from payload"
+		}
+		fmt.Printf("  %-10s %-8s %s\n", module.Name, state, version)
+	}
+	if 0 != len(status.Certificates) {
+		fmt.Println("\ncertificates:")
+		for _, certificate := range status.Certificates {
+			fmt.Printf("  %-14s expire at %s (%d days left)\n", certificate.Name,
+				certificate.NotAfter.Format("2006-01-02"), certificate.DaysLeft)
+		}
+	}
+	fmt.Println("\nchecks:")
+	printCheckResults(status.Checks)
+	if status.Healthy {
+		fmt.Println("\nnode healthy")
+	} else {
+		fmt.Println("\nnode unhealthy")
+	}
+	return nil
+}
+
+func printCheckResults(results []CheckResult) {
+	var nameWidth = 0
+	for _, result := range results {
+		if len(result.Name) > nameWidth {
+			nameWidth = len(result.Name)
+		}
+	}
+	for _, result := range results {
+		fmt.Printf("  [%-4s] %-*s %s\n", result.Status, nameWidth, result.Name, result.Detail)
+	}
+}
+
+func checkModuleStatus(status *NodeStatus, manifest *InstallManifest, moduleName, binaryPath string) (module ModuleStatus) {
+	var err error
+	module.Name = moduleName
+	module.Binary = binaryPath
+	if module.Running, err = isModuleRunning(binaryPath); err != nil {
+		status.addCheck("module "+moduleName, CheckWarning, "check running fail: %s", err.Error())
+	}
+	if module.SHA256, err = fileSHA256(binaryPath); err != nil {
+		status.addCheck("module "+moduleName, CheckFail, "hash binary fail: %s", err.Error())
+		return
+	}
+	var payload = filepath.Join(BinaryPathName, moduleName)
+	if _, err = os.Stat(payload); nil == err {
+		if module.PayloadSHA256, err = fileSHA256(payload); err == nil {
+			module.UpToDate = module.PayloadSHA256 == module.SHA256
+		}
+	} else {
+		//no payload to compare
+		module.UpToDate = true
+	}
+	if nil != manifest {
+		for _, recorded := range manifest.Modules {
+			if recorded.Name == moduleName {
+				module.ManifestMatched = recorded.SHA256 == module.SHA256
+			}
+		}
+		if !module.ManifestMatched {
+			status.addCheck("module "+moduleName, CheckWarning, "binary differs from manifest record")
+		}
+	}
+	if !module.Running {
+		status.addCheck("module "+moduleName, CheckWarning, "not running")
+	} else {
+		status.addCheck("module "+moduleName, CheckPass, "running")
+	}
+	return module
+}
+
+func checkBridgeRoute(status *NodeStatus, bridgeName string) {
+	var name = "bridge " + bridgeName
+	link, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		status.addCheck(name, CheckFail, "not available: %s", err.Error())
+		return
+	}
+	if "bridge" != link.Type() {
+		status.addCheck(name, CheckFail, "link type is %s", link.Type())
+		return
+	}
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		status.addCheck(name, CheckFail, "list route fail: %s", err.Error())
+		return
+	}
+	for _, route := range routes {
+		if nil == route.Dst {
+			if route.LinkIndex == link.Attrs().Index {
+				status.addCheck(name, CheckPass, "default route via %s", route.Gw)
+			} else {
+				status.addCheck(name, CheckWarning, "default route not on bridge")
+			}
+			return
+		}
+	}
+	status.addCheck(name, CheckFail, "no default route")
+}
+
+// checkBridgeMembers checks bridge of guest network, which not carries default route
+func checkBridgeMembers(status *NodeStatus, bridge CellBridge) {
+	var expectedPort = ""
+	if nil != bridge.Uplink {
+		expectedPort = bridge.Uplink.Port()
+	}
+	if err := validateBridge(bridge.Name, expectedPort, false); err != nil {
+		status.addCheck("bridge "+bridge.Name, CheckFail, "%s", err.Error())
+		return
+	}
+	status.addCheck("bridge "+bridge.Name, CheckPass, "uplink attached")
+}
+
+func checkServiceActive(status *NodeStatus, service string) {
+	active, err := services.IsActive(service)
+	if err != nil {
+		status.addCheck("service "+service, CheckFail, "check state fail: %s", err.Error())
+		return
+	} else if !active {
+		status.addCheck("service "+service, CheckFail, "inactive")
+		return
+	}
+	status.addCheck("service "+service, CheckPass, "active")
+}
+
+func checkKVMOwner(status *NodeStatus, manifest *InstallManifest) {
+	const (
+		Name = "kvm device"
+	)
+	info, err := os.Stat(KVMDevice)
+	if err != nil {
+		status.addCheck(Name, CheckFail, "%s not available", KVMDevice)
+		return
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		status.addCheck(Name, CheckWarning, "owner of %s unknown", KVMDevice)
+		return
+	}
+	var owner = strconv.Itoa(int(stat.Uid))
+	if current, lookupError := user.LookupId(owner); nil == lookupError {
+		owner = current.Username
+	}
+	if nil == manifest {
+		status.addCheck(Name, CheckWarning, "owned by %s, service user unknown", owner)
+	} else if int(stat.Uid) != manifest.Session.UID {
+		status.addCheck(Name, CheckFail, "owned by %s instead of %s", owner, manifest.Session.User)
+	} else if !host.Exists(KVMUdevRuleFile) {
+		status.addCheck(Name, CheckWarning, "owned by %s, but lost after reboot without udev rule '%s'", owner, KVMUdevRuleFile)
+	} else {
+		status.addCheck(Name, CheckPass, "owned by %s", owner)
+	}
+}
+
+func checkFirewallPorts(status *NodeStatus, manifest *InstallManifest, expected []PortRange) {
+	const (
+		Name = "firewall ports"
+	)
+	firewall, err := installedFirewall(manifest)
+	if err != nil {
+		status.addCheck(Name, CheckFail, "choose firewall fail: %s", err.Error())
+		return
+	}
+	opened, err := firewall.OpenedPorts()
+	if err != nil {
+		status.addCheck(Name, CheckFail, "list ports of %s fail: %s", firewall.Name(), err.Error())
+		return
+	}
+	var missing []string
+	for _, ports := range expected {
+		if !opened[ports.String()] {
+			missing = append(missing, ports.String())
+		}
+	}
+	if 0 != len(missing) {
+		status.addCheck(Name, CheckFail, "missing %s", strings.Join(missing, ", "))
+		return
+	}
+	status.addCheck(Name, CheckPass, "%d range(s) opened", len(expected))
+}
+
+func checkKernelParameters(status *NodeStatus) {
+	for _, parameter := range kernelParameters {
+		var name = "sysctl " + parameter.Name
+		value, exists, err := parameter.runtimeValue()
+		if err != nil {
+			status.addCheck(name, CheckFail, "read %s fail: %s", parameter.procPath(), err.Error())
+		} else if !exists && parameter.Optional {
+			status.addCheck(name, CheckPass, "not available, applied when module loaded")
+		} else if !exists {
+			status.addCheck(name, CheckFail, "not available")
+		} else if value != parameter.Value {
+			status.addCheck(name, CheckFail, "%s instead of %s", value, parameter.Value)
+		} else {
+			status.addCheck(name, CheckPass, "%s", value)
+		}
+	}
+}
+
+// checkCAKey reports whether key of CA kept offline, or restricted to service user on host
+func checkCAKey(status *NodeStatus, manifest *InstallManifest, projectPath string) {
+	const (
+		Name = "CA key"
+	)
+	var offline = nil != manifest && manifest.Session.CAKeyOffline
+	var keyFile = filepath.Join(projectPath, CertificatePathName, fmt.Sprintf("%s_ca%s", ProjectName, KeyFileSuffix))
+	var info, err = os.Stat(keyFile)
+	if err != nil {
+		if offline {
+			status.addCheck(Name, CheckPass, "kept offline")
+		}
+		return
+	}
+	if offline {
+		status.addCheck(Name, CheckWarning, "'%s' left on host while kept offline", keyFile)
+		return
+	}
+	if KeyFilePerm != info.Mode().Perm() {
+		status.addCheck(Name, CheckWarning, "mode of '%s' is %04o instead of %04o", keyFile, info.Mode().Perm(), KeyFilePerm)
+		return
+	}
+	var state = "not encrypted"
+	if data, readError := host.ReadFile(keyFile); nil == readError && isEncryptedKey(data) {
+		state = "encrypted"
+	}
+	status.addCheck(Name, CheckPass, "'%s' %s, mode %04o", keyFile, state, KeyFilePerm)
+}
+
+func loadCertificate(filename string) (certificate *x509.Certificate, err error) {
+	var data []byte
+	if data, err = host.ReadFile(filename); err != nil {
+		return
+	}
+	var block, _ = pem.Decode(data)
+	if nil == block {
+		err = errors.New("no PEM data")
+		return
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SysctlConfigFile = "/etc/sysctl.d/90-nano.conf"
+	SysctlProcPath   = "/proc/sys"
+	//appended to vendor file by earlier version
+	LegacyIPForwardConfigFile = "/usr/lib/sysctl.d/50-default.conf"
+	LegacyIPForwardLine       = "net.ipv4.ip_forward = 1"
+)
+
+// KernelParameter is a sysctl required by Nano, optional one may not exist when module not loaded,
+// like bridge netfilter, which applied by systemd-sysctl when module loaded later
+type KernelParameter struct {
+	Name     string
+	Value    string
+	Optional bool
+}
+
+var kernelParameters = []KernelParameter{
+	{"net.ipv4.ip_forward", "1", false},
+	{"net.ipv6.conf.all.forwarding", "1", true},
+	//traffic of guests on bridge not filtered by firewall of host
+	{"net.bridge.bridge-nf-call-iptables", "0", true},
+	{"net.bridge.bridge-nf-call-ip6tables", "0", true},
+	{"net.bridge.bridge-nf-call-arptables", "0", true},
+	//loose mode, multicast of cluster not dropped when arrived on interface other than route
+	{"net.ipv4.conf.all.rp_filter", "2", false},
+	{"net.ipv4.conf.default.rp_filter", "2", false},
+}
+
+func (parameter KernelParameter) procPath() string {
+	return filepath.Join(SysctlProcPath, strings.Replace(parameter.Name, ".", "/", -1))
+}
+
+// runtimeValue returns current value, exists is false when parameter not available in kernel
+func (parameter KernelParameter) runtimeValue() (value string, exists bool, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(parameter.procPath()); os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return
+	}
+	//multiple values separated by tab, like net.ipv4.ip_local_port_range
+	return strings.Join(strings.Fields(string(data)), " "), true, nil
+}
+
+func sysctlConfig() []byte {
+	var content bytes.Buffer
+	fmt.Fprintln(&content, "# generated by Nano installer, removed when uninstall")
+	for _, parameter := range kernelParameters {
+		fmt.Fprintf(&content, "%s = %s\n", parameter.Name, parameter.Value)
+	}
+	return content.Bytes()
+}
+
+// configureKernelParameters writes drop-in file owned by Nano, applies when file or runtime value differs, then verifies
+func configureKernelParameters() (err error) {
+	if err = removeLegacyIPForward(); err != nil {
+		err = fmt.Errorf("remove legacy ip forward fail: %s", err.Error())
+		return
+	}
+	var config = sysctlConfig()
+	var modified = false
+	if current, readError := host.ReadFile(SysctlConfigFile); nil == readError && bytes.Equal(current, config) {
+		fmt.Printf("kernel parameters already configured in '%s'\n", SysctlConfigFile)
+	} else {
+		if err = host.WriteFile(SysctlConfigFile, config, 0644); err != nil {
+			return
+		}
+		fmt.Printf("kernel parameters configured in '%s'\n", SysctlConfigFile)
+		modified = true
+	}
+	for _, parameter := range kernelParameters {
+		value, exists, readError := parameter.runtimeValue()
+		if readError != nil {
+			err = fmt.Errorf("read %s fail: %s", parameter.Name, readError.Error())
+			return
+		}
+		if !exists || value == parameter.Value {
+			continue
+		}
+		modified = true
+		var name, origin = parameter.Name, value
+		registerUndo(fmt.Sprintf("set %s to %s", name, parameter.Value), func(operator HostOperator) error {
+			return operator.Execute("sysctl", "-w", fmt.Sprintf("%s=%s", name, origin))
+		})
+	}
+	if !modified {
+		fmt.Println("kernel parameters already applied")
+		return nil
+	}
+	if err = host.Execute("sysctl", "--system"); err != nil {
+		//unknown key reported for parameter not available, verified below
+		fmt.Printf("warning: apply kernel parameters: %s\n", err.Error())
+	}
+	if dryRunEnabled() {
+		return nil
+	}
+	return verifyKernelParameters()
+}
+
+// verifyKernelParameters checks runtime values, optional parameters not available skipped
+func verifyKernelParameters() (err error) {
+	for _, parameter := range kernelParameters {
+		value, exists, readError := parameter.runtimeValue()
+		if readError != nil {
+			return fmt.Errorf("read %s fail: %s", parameter.Name, readError.Error())
+		}
+		if !exists {
+			if parameter.Optional {
+				fmt.Printf("%s not available, skipped\n", parameter.Name)
+				continue
+			}
+			return fmt.Errorf("%s not available", parameter.Name)
+		}
+		if value != parameter.Value {
+			return fmt.Errorf("%s is %s after applied, %s required", parameter.Name, value, parameter.Value)
+		}
+		fmt.Printf("%s = %s\n", parameter.Name, value)
+	}
+	return nil
+}
+
+// removeKernelParameters removes drop-in file and reloads the rest, runtime value not restored
+// by other configure takes effect after reboot
+func removeKernelParameters() (err error) {
+	if err = removeLegacyIPForward(); err != nil {
+		return
+	}
+	if !host.Exists(SysctlConfigFile) {
+		return nil
+	}
+	if err = host.Remove(SysctlConfigFile); err != nil {
+		return
+	}
+	fmt.Printf("kernel parameters '%s' removed\n", SysctlConfigFile)
+	if err = host.Execute("sysctl", "--system"); err != nil {
+		fmt.Printf("warning: reload kernel parameters: %s\n", err.Error())
+	}
+	return nil
+}
+
+// removeLegacyIPForward removes the line appended to vendor file by earlier version
+func removeLegacyIPForward() (err error) {
+	var data []byte
+	if data, err = host.ReadFile(LegacyIPForwardConfigFile); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	var lines []string
+	var removed = 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if LegacyIPForwardLine == strings.TrimSpace(line) {
+			removed++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if 0 == removed {
+		return nil
+	}
+	if err = host.WriteFile(LegacyIPForwardConfigFile, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return
+	}
+	fmt.Printf("ip_forward removed from legacy config %s\n", LegacyIPForwardConfigFile)
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// UndoFunction reverts a change by the underlying operator, which records nothing
+type UndoFunction func(operator HostOperator) error
+
+type undoAction struct {
+	Description string
+	Undo        UndoFunction
+}
+
+// Transaction applies changes through the wrapped operator and records an undo action for every change,
+// so that everything changed in a run could be reverted when installation fails.
+// Commands are not revertible by itself, caller should register an undo action for them
+type Transaction struct {
+	origin  HostOperator
+	parent  *Transaction
+	actions []undoAction
+	saved   map[string]bool
+}
+
+func NewTransaction(origin HostOperator) *Transaction {
+	return &Transaction{origin: origin, saved: map[string]bool{}}
+}
+
+// Register appends an undo action, actions executed in reverse order when rolling back
+func (transaction *Transaction) Register(description string, undo UndoFunction) {
+	transaction.actions = append(transaction.actions, undoAction{description, undo})
+}
+
+// Rollback reverts all changes in reverse order, returns descriptions of reverted changes
+func (transaction *Transaction) Rollback() (reverted []string) {
+	for index := len(transaction.actions) - 1; index >= 0; index-- {
+		var action = transaction.actions[index]
+		if err := action.Undo(transaction.origin); err != nil {
+			fmt.Printf("warning: revert '%s' fail: %s\n", action.Description, err.Error())
+			continue
+		}
+		reverted = append(reverted, action.Description)
+	}
+	transaction.actions = nil
+	transaction.saved = map[string]bool{}
+	return reverted
+}
+
+// ModifiedFiles returns files created or modified in transaction, except those under excluded path
+func (transaction *Transaction) ModifiedFiles(excludedPath string) (files []string) {
+	var prefix = filepath.Clean(excludedPath) + string(filepath.Separator)
+	for filename := range transaction.saved {
+		if !filepath.IsAbs(filename) || strings.HasPrefix(filepath.Clean(filename), prefix) {
+			continue
+		}
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Origin returns the wrapped operator
+func (transaction *Transaction) Origin() HostOperator {
+	return transaction.origin
+}
+
+func (transaction *Transaction) ReadFile(filename string) ([]byte, error) {
+	return transaction.origin.ReadFile(filename)
+}
+
+func (transaction *Transaction) Exists(path string) bool {
+	return transaction.origin.Exists(path)
+}
+
+func (transaction *Transaction) WriteFile(filename string, data []byte, perm os.FileMode) (err error) {
+	if err = transaction.saveFile(filename); err != nil {
+		return
+	}
+	return transaction.origin.WriteFile(filename, data, perm)
+}
+
+func (transaction *Transaction) AppendFile(filename string, data []byte, perm os.FileMode) (err error) {
+	if err = transaction.saveFile(filename); err != nil {
+		return
+	}
+	return transaction.origin.AppendFile(filename, data, perm)
+}
+
+func (transaction *Transaction) CopyFile(source, target string) (err error) {
+	if err = transaction.saveFile(target); err != nil {
+		return
+	}
+	return transaction.origin.CopyFile(source, target)
+}
+
+func (transaction *Transaction) MakeDirectory(path string, perm os.FileMode) (err error) {
+	//remove the top-most directory created
+	var created = ""
+	for current := filepath.Clean(path); !transaction.origin.Exists(current); current = filepath.Dir(current) {
+		created = current
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if err = transaction.origin.MakeDirectory(path, perm); err != nil {
+		return
+	}
+	if "" != created {
+		transaction.Register(fmt.Sprintf("create directory '%s'", created), func(operator HostOperator) error {
+			return operator.Remove(created)
+		})
+	}
+	return nil
+}
+
+func (transaction *Transaction) Remove(path string) (err error) {
+	if info, statError := os.Stat(path); nil == statError && info.Mode().IsRegular() {
+		if err = transaction.saveFile(path); err != nil {
+			return
+		}
+	}
+	return transaction.origin.Remove(path)
+}
+
+func (transaction *Transaction) Chown(path string, uid, gid int) (err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(path); nil == err {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			var originUID, originGID = int(stat.Uid), int(stat.Gid)
+			if originUID != uid || originGID != gid {
+				transaction.Register(fmt.Sprintf("change owner of '%s'", path), func(operator HostOperator) error {
+					return operator.Chown(path, originUID, originGID)
+				})
+			}
+		}
+	}
+	return transaction.origin.Chown(path, uid, gid)
+}
+
+func (transaction *Transaction) Chmod(path string, perm os.FileMode) (err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(path); nil == err && info.Mode().Perm() != perm.Perm() {
+		var origin = info.Mode().Perm()
+		transaction.Register(fmt.Sprintf("change mode of '%s'", path), func(operator HostOperator) error {
+			return operator.Chmod(path, origin)
+		})
+	}
+	return transaction.origin.Chmod(path, perm)
+}
+
+func (transaction *Transaction) Execute(name string, args ...string) error {
+	return transaction.origin.Execute(name, args...)
+}
+
+func (transaction *Transaction) ExecuteOutput(name string, args ...string) ([]byte, error) {
+	return transaction.origin.ExecuteOutput(name, args...)
+}
+
+func (transaction *Transaction) AddBridge(name string) (err error) {
+	if err = transaction.origin.AddBridge(name); err != nil {
+		return
+	}
+	transaction.Register(fmt.Sprintf("create bridge %s", name), func(operator HostOperator) error {
+		return operator.DeleteLink(name)
+	})
+	return nil
+}
+
+func (transaction *Transaction) SetLinkMaster(link, master string) (err error) {
+	if err = transaction.origin.SetLinkMaster(link, master); err != nil {
+		return
+	}
+	transaction.Register(fmt.Sprintf("attach %s to %s", link, master), func(operator HostOperator) error {
+		return operator.SetLinkNoMaster(link)
+	})
+	return nil
+}
+
+func (transaction *Transaction) SetLinkNoMaster(link string) error {
+	return transaction.origin.SetLinkNoMaster(link)
+}
+
+func (transaction *Transaction) SetLinkUp(link string) error {
+	return transaction.origin.SetLinkUp(link)
+}
+
+func (transaction *Transaction) SetLinkDown(link string) (err error) {
+	if err = transaction.origin.SetLinkDown(link); err != nil {
+		return
+	}
+	transaction.Register(fmt.Sprintf("set %s down", link), func(operator HostOperator) error {
+		return operator.SetLinkUp(link)
+	})
+	return nil
+}
+
+func (transaction *Transaction) DeleteLink(link string) error {
+	return transaction.origin.DeleteLink(link)
+}
+
+// saveFile keeps original content of file before first modification in transaction
+func (transaction *Transaction) saveFile(filename string) (err error) {
+	if transaction.saved[filename] {
+		return nil
+	}
+	transaction.saved[filename] = true
+	if !transaction.origin.Exists(filename) {
+		transaction.Register(fmt.Sprintf("create file '%s'", filename), func(operator HostOperator) error {
+			return operator.Remove(filename)
+		})
+		return nil
+	}
+	var content []byte
+	if content, err = transaction.origin.ReadFile(filename); err != nil {
+		return
+	}
+	var perm os.FileMode = DefaultFilePerm
+	if info, statError := os.Stat(filename); nil == statError {
+		perm = info.Mode().Perm()
+	}
+	transaction.Register(fmt.Sprintf("modify file '%s'", filename), func(operator HostOperator) (err error) {
+		if err = operator.WriteFile(filename, content, perm); err != nil {
+			return
+		}
+		return operator.Chmod(filename, perm)
+	})
+	return nil
+}
+
+// registerUndo registers an undo action when running in a transaction
+func registerUndo(description string, undo UndoFunction) {
+	if transaction, ok := host.(*Transaction); ok {
+		transaction.Register(description, undo)
+	}
+}
+
+// beginTransaction wraps current host operator with a new transaction
+func beginTransaction() *Transaction {
+	var transaction = NewTransaction(host)
+	host = transaction
+	return transaction
+}
+
+// finishTransaction restores host operator, and reverts all changes when failed
+func finishTransaction(transaction *Transaction, failed bool) {
+	host = transaction.Origin()
+	if !failed {
+		return
+	}
+	fmt.Println("installation failed, try reverting changes...")
+	var reverted = transaction.Rollback()
+	for _, description := range reverted {
+		fmt.Printf("reverted: %s\n", description)
+	}
+	fmt.Printf("%d change(s) reverted\n", len(reverted))
+}
+
+// beginNestedTransaction starts a transaction for a step of current run, which could be reverted alone.
+// Changes applied by underlying operator directly, and merged into current transaction when succeed
+func beginNestedTransaction() *Transaction {
+	var parent, nested = host.(*Transaction)
+	var transaction *Transaction
+	if nested {
+		transaction = NewTransaction(parent.Origin())
+		transaction.parent = parent
+	} else {
+		transaction = NewTransaction(host)
+	}
+	host = transaction
+	return transaction
+}
+
+// finishNestedTransaction merges changes into parent transaction, or reverts them when failed
+func finishNestedTransaction(transaction *Transaction, failed bool) {
+	var restored HostOperator = transaction.Origin()
+	if nil != transaction.parent {
+		restored = transaction.parent
+	}
+	if !failed {
+		host = restored
+		if nil != transaction.parent {
+			transaction.parent.actions = append(transaction.parent.actions, transaction.actions...)
+			for filename := range transaction.saved {
+				transaction.parent.saved[filename] = true
+			}
+		}
+		return
+	}
+	//undo actions expect the underlying operator
+	host = transaction.Origin()
+	var reverted = transaction.Rollback()
+	host = restored
+	for _, description := range reverted {
+		fmt.Printf("reverted: %s\n", description)
+	}
+	fmt.Printf("%d change(s) reverted\n", len(reverted))
+}
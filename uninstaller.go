@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UninstallModules stops installed modules and reverts every change made by installation.
+// Certificates and configures kept for reinstallation when keepData enabled
+func UninstallModules(keepData bool) (err error) {
+	const (
+		CertPathName   = "cert"
+		ConfigPathName = "config"
+	)
+	//stop in reverse order of installation
+	var moduleOrder = []string{"frontend", "cell", "core"}
+	var modulePorts = map[string][]PortRange{
+		"core":     corePortRanges(),
+		"frontend": frontendPortRanges(),
+		"cell":     cellPortRanges(),
+	}
+	var projectPath string
+	var manifest *InstallManifest
+	if projectPath, manifest, err = resolveProjectPath(); err != nil {
+		return
+	}
+	var installed []string
+	for _, moduleName := range moduleOrder {
+		if nil != manifest {
+			if manifest.HasModule(moduleName) {
+				installed = append(installed, moduleName)
+			}
+		} else if host.Exists(filepath.Join(projectPath, moduleName)) {
+			installed = append(installed, moduleName)
+		}
+	}
+	if 0 == len(installed) {
+		fmt.Printf("no module installed in '%s'\n", projectPath)
+	} else {
+		fmt.Printf("module(s) %s installed in '%s'\n", strings.Join(installed, ", "), projectPath)
+	}
+	var confirmed bool
+	if confirmed, err = prompter.Confirm(AnswerConfirmUninstall, "Uninstall all modules and revert system configure"); err != nil {
+		return
+	}
+	if !confirmed {
+		return errors.New("uninstall interrupted by user")
+	}
+	var ranges = defaultPortRanges()
+	//uplink unknown without manifest, restored by scripts attached to bridge
+	var bridges = []CellBridge{{Name: DefaultBridgeName, Uplink: &Uplink{}}}
+	var session = &SessionInfo{}
+	if nil != manifest {
+		ranges = manifest.Ports
+		session = &manifest.Session
+		bridges = installedBridges(session)
+	}
+	var cellInstalled = false
+	for _, moduleName := range installed {
+		var modulePath = filepath.Join(projectPath, moduleName)
+		if err = stopInstalledModule(moduleName, filepath.Join(modulePath, moduleName)); err != nil {
+			return
+		}
+		if err = removeModuleFiles(modulePath, keepData, ConfigPathName, CertPathName); err != nil {
+			err = fmt.Errorf("remove module %s fail: %s", moduleName, err.Error())
+			return
+		}
+		if nil == manifest {
+			ranges = append(ranges, modulePorts[moduleName]...)
+		}
+		if "cell" == moduleName {
+			cellInstalled = true
+		}
+	}
+	if cellInstalled {
+		if err = revertQEMUAuthority(session); err != nil {
+			fmt.Printf("warning: revert qemu authority fail: %s\n", err.Error())
+		}
+		if !session.PolkitAccessCreated {
+			fmt.Printf("polkit access '%s' not created by installer, kept\n", PolkitAccessFile)
+		} else if err = host.Remove(PolkitAccessFile); err != nil {
+			fmt.Printf("warning: remove polkit access fail: %s\n", err.Error())
+		} else {
+			fmt.Printf("polkit access '%s' removed\n", PolkitAccessFile)
+		}
+		removeCellBridges(session, bridges)
+	}
+	var firewall Firewall
+	if firewall, err = installedFirewall(manifest); err != nil {
+		fmt.Printf("warning: choose firewall fail: %s\n", err.Error())
+	} else if err = firewall.Remove(ranges); err != nil {
+		fmt.Printf("warning: disable port ranges fail: %s\n", err.Error())
+	}
+	if err = removeKernelParameters(); err != nil {
+		fmt.Printf("warning: remove kernel parameters fail: %s\n", err.Error())
+	}
+	if keepData {
+		if nil != manifest {
+			manifest.Modules = nil
+			manifest.Ports = nil
+			manifest.Session.BridgeName = ""
+			manifest.Session.BridgeInterface = ""
+			manifest.Session.Bridges = nil
+			manifest.Session.NetworkBackend = ""
+			manifest.Session.FirewallZoneCreated = false
+			manifest.Session.FirewallInterfaces = nil
+			manifest.Session.FirewallRules = nil
+			manifest.Session.NftablesTableAdded = false
+			manifest.Session.NftablesChainAdded = false
+			manifest.Session.LibvirtGroupMember = ""
+			manifest.Session.PolkitAccessCreated = false
+			if err = manifest.Save(projectPath, &manifest.Session); err != nil {
+				fmt.Printf("warning: save install manifest fail: %s\n", err.Error())
+			}
+		}
+		fmt.Printf("certificates and configures kept in '%s'\n", projectPath)
+	} else {
+		if err = removeRootCA(); err != nil {
+			fmt.Printf("warning: remove root CA fail: %s\n", err.Error())
+		}
+		if err = removeProjectFiles(projectPath); err != nil {
+			err = fmt.Errorf("clear project path '%s' fail: %s", projectPath, err.Error())
+			return
+		}
+	}
+	fmt.Printf("%d module(s) uninstalled\n", len(installed))
+	return nil
+}
+
+func stopInstalledModule(moduleName, binaryPath string) (err error) {
+	if !host.Exists(binaryPath) {
+		return nil
+	}
+	var running bool
+	if running, err = isModuleRunning(binaryPath); err != nil {
+		fmt.Printf("warning: check status of module %s fail: %s\n", moduleName, err.Error())
+		return nil
+	}
+	if !running {
+		return nil
+	}
+	if err = stopModule(binaryPath); err != nil {
+		err = fmt.Errorf("stop module %s fail: %s", moduleName, err.Error())
+		return
+	}
+	const (
+		StopGap = time.Millisecond * 300
+	)
+	time.Sleep(StopGap)
+	fmt.Printf("module %s stopped\n", moduleName)
+	return nil
+}
+
+// removeModuleFiles removes module path, or everything except kept paths when keepData enabled
+func removeModuleFiles(modulePath string, keepData bool, keptPaths ...string) (err error) {
+	if !keepData {
+		if err = host.Remove(modulePath); err != nil {
+			return
+		}
+		fmt.Printf("module path '%s' removed\n", modulePath)
+		return nil
+	}
+	var kept = map[string]bool{}
+	for _, name := range keptPaths {
+		kept[name] = true
+	}
+	var entries []string
+	if entries, err = filepath.Glob(filepath.Join(modulePath, "*")); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if kept[filepath.Base(entry)] {
+			continue
+		}
+		if err = host.Remove(entry); err != nil {
+			return
+		}
+	}
+	fmt.Printf("module path '%s' cleared, %s kept\n", modulePath, strings.Join(keptPaths, ", "))
+	return nil
+}
+
+// removeProjectFiles removes CA and manifest left after modules removed. Anything not created by installer kept,
+// so path removed only when nothing left
+func removeProjectFiles(projectPath string) (err error) {
+	for _, path := range []string{filepath.Join(projectPath, CertificatePathName), manifestPath(projectPath)} {
+		if !host.Exists(path) {
+			continue
+		}
+		if err = host.Remove(path); err != nil {
+			return
+		}
+		fmt.Printf("'%s' removed\n", path)
+	}
+	for _, path := range []string{filepath.Join(projectPath, InstallerDataPathName), projectPath} {
+		if entries, readError := ioutil.ReadDir(path); nil != readError || 0 != len(entries) {
+			continue
+		}
+		if err = host.Remove(path); err != nil {
+			return
+		}
+		fmt.Printf("empty path '%s' removed\n", path)
+	}
+	if host.Exists(backups.path) {
+		fmt.Printf("backups of system files kept in '%s', use restore to put a file back\n", backups.path)
+	} else if host.Exists(projectPath) {
+		fmt.Printf("project path '%s' kept for files not created by installer\n", projectPath)
+	}
+	return nil
+}
+
+// revertQEMUAuthority restores values in qemu.conf recorded before installation, and owner of KVM devices.
+// qemu.conf kept when nothing recorded, and user removed from libvirt group only when added by installer
+func revertQEMUAuthority(session *SessionInfo) (err error) {
+	var data []byte
+	if data, err = host.ReadFile(QEMUConfigPath); err != nil {
+		return
+	}
+	var config = parseLibvirtConfig(data)
+	var origins = session.QEMUConfigOrigin
+	if 0 == len(origins) {
+		fmt.Printf("warning: original values of %s not recorded, kept unchanged\n", QEMUConfigPath)
+	}
+	var keys []string
+	for key := range origins {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var changed = false
+	for _, key := range keys {
+		var origin = origins[key]
+		if "" == origin {
+			if config.Unset(key) {
+				fmt.Printf("%s reverted to default in %s\n", key, QEMUConfigPath)
+				changed = true
+			}
+		} else if config.Set(key, origin) {
+			fmt.Printf("%s reverted to %s in %s\n", key, origin, QEMUConfigPath)
+			changed = true
+		}
+	}
+	if changed {
+		if err = host.WriteFile(QEMUConfigPath, config.Bytes(), DefaultFilePerm); err != nil {
+			return
+		}
+		fmt.Printf("%s updated\n", QEMUConfigPath)
+		if active, _ := services.IsActive("libvirtd"); active {
+			if err = services.Restart("libvirtd"); err != nil {
+				fmt.Printf("warning: restart libvirt fail: %s\n", err.Error())
+			} else {
+				fmt.Println("libvirt restarted for configure changed")
+			}
+		}
+	}
+	if member := session.LibvirtGroupMember; "" == member {
+		fmt.Printf("no user added to group %s by installer\n", LibvirtGroupName)
+	} else if err = host.Execute("gpasswd", "-d", member, LibvirtGroupName); err != nil {
+		fmt.Printf("warning: remove user %s from group %s fail: %s\n", member, LibvirtGroupName, err.Error())
+	} else {
+		fmt.Printf("user %s removed from group %s\n", member, LibvirtGroupName)
+	}
+	return removeKVMUdevRule()
+}
+
+// removeCellBridges removes bridges created by installation in reverse order, bridges existed before kept
+func removeCellBridges(session *SessionInfo, bridges []CellBridge) {
+	if 0 == len(bridges) {
+		fmt.Println("no bridge created by installer")
+		return
+	}
+	var configurator BridgeConfigurator
+	var err error
+	for index := len(bridges) - 1; index >= 0; index-- {
+		var bridge = bridges[index]
+		if nil == bridge.Uplink {
+			fmt.Printf("bridge %s not created by installer, kept\n", bridge.Name)
+			continue
+		}
+		if nil == configurator {
+			if configurator, err = installedBridgeConfigurator(session); err != nil {
+				fmt.Printf("warning: choose network backend fail: %s\n", err.Error())
+				return
+			}
+		}
+		if err = configurator.Unlink(*bridge.Uplink, bridge.Name); err != nil {
+			fmt.Printf("warning: remove bridge %s fail: %s\n", bridge.Name, err.Error())
+		}
+	}
+}
+
+// unlinkBridge restores address params to carrier of uplink, or scripts attached to bridge when carrier not recorded
+func unlinkBridge(uplink Uplink, bridgeName string) (err error) {
+	var bridgeScript = interfaceScriptPath(bridgeName)
+	if !host.Exists(bridgeScript) {
+		return nil
+	}
+	var bridgeConfig InterfaceConfig
+	if bridgeConfig, err = readInterfaceConfig(bridgeScript); err != nil {
+		return
+	}
+	var generated = []string{bridgeScript}
+	if uplink.CreateBond() {
+		generated = append(generated, interfaceScriptPath(uplink.Interface))
+	}
+	if 0 != uplink.VLAN {
+		generated = append(generated, interfaceScriptPath(uplink.Port()))
+	}
+	var scripts []string
+	if scripts, err = filepath.Glob(interfaceScriptPath("*")); err != nil {
+		return
+	}
+	for _, script := range scripts {
+		var isGenerated = false
+		for _, name := range generated {
+			if script == name {
+				isGenerated = true
+				break
+			}
+		}
+		if isGenerated {
+			continue
+		}
+		var interfaceConfig InterfaceConfig
+		if interfaceConfig, err = readInterfaceConfig(script); err != nil {
+			return
+		}
+		var isCarrier = script == interfaceScriptPath(uplink.Carrier)
+		var isDevice = false
+		for _, device := range uplink.Devices() {
+			if script == interfaceScriptPath(device) {
+				isDevice = true
+				break
+			}
+		}
+		if "" == uplink.Carrier {
+			isCarrier = bridgeName == interfaceConfig.Params["BRIDGE"]
+		}
+		if !isCarrier && !isDevice {
+			continue
+		}
+		if isCarrier {
+			for _, name := range bridgeMigrateParams {
+				if value, exists := bridgeConfig.Params[name]; exists {
+					interfaceConfig.Params[name] = value
+				}
+			}
+		}
+		delete(interfaceConfig.Params, "BRIDGE")
+		delete(interfaceConfig.Params, "NM_CONTROLLED")
+		if uplink.CreateBond() {
+			delete(interfaceConfig.Params, "MASTER")
+			delete(interfaceConfig.Params, "SLAVE")
+		}
+		if err = writeInterfaceConfig(interfaceConfig, script); err != nil {
+			return
+		}
+		fmt.Printf("interface script %s restored\n", script)
+	}
+	for _, script := range generated {
+		if !host.Exists(script) {
+			continue
+		}
+		if err = host.Remove(script); err != nil {
+			return
+		}
+		fmt.Printf("script %s removed\n", script)
+	}
+	if err = deleteUplinkLinks(uplink, bridgeName); err != nil {
+		return
+	}
+	if err = services.Restart("network"); err != nil {
+		fmt.Printf("warning: restart network service fail: %s\n", err.Error())
+	} else {
+		fmt.Println("network service restarted")
+	}
+	return nil
+}
+
+// deleteUplinkLinks deletes bridge, and VLAN or bond created for it
+func deleteUplinkLinks(uplink Uplink, bridgeName string) (err error) {
+	var links = []string{bridgeName}
+	if 0 != uplink.VLAN {
+		links = append(links, uplink.Port())
+	}
+	if uplink.CreateBond() {
+		links = append(links, uplink.Interface)
+	}
+	for _, name := range links {
+		if _, linkError := net.InterfaceByName(name); linkError != nil {
+			continue
+		}
+		if err = host.DeleteLink(name); err != nil {
+			return
+		}
+		fmt.Printf("link %s deleted\n", name)
+	}
+	return nil
+}
+
+func removeRootCA() (err error) {
+	var trustedCertFile = filepath.Join(TrustedCAPath, fmt.Sprintf("%s_ca.crt.pem", ProjectName))
+	if !host.Exists(trustedCertFile) {
+		return nil
+	}
+	if err = host.Remove(trustedCertFile); err != nil {
+		return
+	}
+	if err = host.Execute("update-ca-trust"); err != nil {
+		return
+	}
+	fmt.Printf("trusted CA '%s' removed\n", trustedCertFile)
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultBondName     = "bond0"
+	DefaultBondMode     = "802.3ad"
+	BondMonitorInterval = 100 // milliseconds
+	MaxVLANID           = 4094
+)
+
+// types of uplink selected by operator
+const (
+	UplinkEthernet     = "ethernet"
+	UplinkNewBond      = "new_bond"
+	UplinkExistingBond = "existing_bond"
+	UplinkVLAN         = "vlan"
+)
+
+var uplinkTypes = []string{UplinkEthernet, UplinkNewBond, UplinkExistingBond, UplinkVLAN}
+
+var bondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+// Uplink is the device attached to bridge of cell: an ethernet interface or existing bond,
+// a bond created from slaves, and optionally a VLAN on top of them
+type Uplink struct {
+	// Interface is the bridged interface, or parent of VLAN
+	Interface string `json:"interface"`
+	// Slaves bonded as Interface, empty when Interface already exists
+	Slaves   []string `json:"slaves,omitempty"`
+	BondMode string   `json:"bond_mode,omitempty"`
+	VLAN     int      `json:"vlan,omitempty"`
+	// Carrier is the device carries address before bridged, whose configure migrated to bridge
+	Carrier string `json:"carrier"`
+}
+
+// CreateBond returns true when bond created by installer
+func (uplink *Uplink) CreateBond() bool {
+	return 0 != len(uplink.Slaves)
+}
+
+// Port returns the device attached to bridge
+func (uplink *Uplink) Port() string {
+	if 0 != uplink.VLAN {
+		return fmt.Sprintf("%s.%d", uplink.Interface, uplink.VLAN)
+	}
+	return uplink.Interface
+}
+
+// Devices returns existing devices configured by host before bridged
+func (uplink *Uplink) Devices() []string {
+	if uplink.CreateBond() {
+		return uplink.Slaves
+	}
+	return []string{uplink.Interface}
+}
+
+func (uplink *Uplink) String() string {
+	var description = fmt.Sprintf("interface '%s'", uplink.Interface)
+	if uplink.CreateBond() {
+		description = fmt.Sprintf("bond '%s'(%s of %s)", uplink.Interface, uplink.BondMode, strings.Join(uplink.Slaves, ","))
+	}
+	if 0 != uplink.VLAN {
+		description = fmt.Sprintf("VLAN %d on %s", uplink.VLAN, description)
+	}
+	return description
+}
+
+// chooseUplink uses uplink preset by answer, or asks operator for type of uplink and devices required by the type
+func chooseUplink(bridgeName string, first bool) (uplink Uplink, err error) {
+	var key = func(name string) string {
+		return bridgeAnswerKey(name, bridgeName, first)
+	}
+	if "" != optionalAnswer(key(AnswerBondSlaves), "") {
+		return newUplink(bridgeName, first, "")
+	}
+	var values = map[string]string{}
+	var interfaceDescription = fmt.Sprintf("interface to bridge %s", bridgeName)
+	if "" != optionalAnswer(key(AnswerBridgeInterface), "") {
+		var interfaceName string
+		if interfaceName, err = prompter.SelectEthernetInterface(key(AnswerBridgeInterface), interfaceDescription, true); err != nil {
+			return
+		}
+		return newUplink(bridgeName, first, interfaceName)
+	}
+	var uplinkType string
+	if uplinkType, err = prompter.ChooseOption(key(AnswerUplinkType), fmt.Sprintf("uplink type of bridge %s", bridgeName),
+		uplinkTypes, UplinkEthernet); err != nil {
+		return
+	}
+	switch uplinkType {
+	case UplinkEthernet:
+		values[AnswerBridgeInterface], err = prompter.SelectEthernetInterface(key(AnswerBridgeInterface), interfaceDescription, true)
+	case UplinkExistingBond:
+		var bonds []string
+		if bonds, err = uplinkCandidates(true); err != nil {
+			return
+		} else if 0 == len(bonds) {
+			err = errors.New("no bond available")
+			return
+		}
+		values[AnswerBridgeInterface], err = prompter.ChooseOption(key(AnswerBridgeInterface),
+			fmt.Sprintf("bond to bridge %s", bridgeName), bonds, bonds[0])
+	case UplinkNewBond:
+		if values[AnswerBondSlaves], err = prompter.InputString(key(AnswerBondSlaves),
+			"slaves of new bond, split by ','", ""); err != nil {
+			return
+		}
+		if values[AnswerBondName], err = prompter.InputString(key(AnswerBondName), "name of new bond", DefaultBondName); err != nil {
+			return
+		}
+		values[AnswerBondMode], err = prompter.ChooseOption(key(AnswerBondMode), "mode of new bond", bondModes, DefaultBondMode)
+	case UplinkVLAN:
+		var parents []string
+		if parents, err = uplinkCandidates(false); err != nil {
+			return
+		} else if 0 == len(parents) {
+			err = errors.New("no interface or bond available for VLAN")
+			return
+		}
+		if values[AnswerBridgeInterface], err = prompter.ChooseOption(key(AnswerBridgeInterface),
+			"parent of VLAN", parents, parents[0]); err != nil {
+			return
+		}
+		values[AnswerBridgeVLAN], err = prompter.InputString(key(AnswerBridgeVLAN), fmt.Sprintf("VLAN ID (1~%d)", MaxVLANID), "")
+	}
+	if err != nil {
+		return
+	}
+	return buildUplink(values[AnswerBridgeInterface], func(name, defaultValue string) string {
+		if value, exists := values[name]; exists {
+			return value
+		}
+		return optionalAnswer(key(name), defaultValue)
+	})
+}
+
+// uplinkCandidates returns ethernet interfaces and bonds not attached to any master yet, or only bonds when required
+func uplinkCandidates(bondOnly bool) (names []string, err error) {
+	var links []netlink.Link
+	if links, err = netlink.LinkList(); err != nil {
+		return
+	}
+	for _, link := range links {
+		var attrs = link.Attrs()
+		if 0 != attrs.MasterIndex || 0 != attrs.Flags&net.FlagLoopback {
+			continue
+		}
+		if "bond" == link.Type() || (!bondOnly && "device" == link.Type()) {
+			names = append(names, attrs.Name)
+		}
+	}
+	return names, nil
+}
+
+// newUplink completes uplink with bond and VLAN preset by answer, then locates the carrier
+func newUplink(bridgeName string, first bool, interfaceName string) (uplink Uplink, err error) {
+	return buildUplink(interfaceName, func(key, defaultValue string) string {
+		return optionalAnswer(bridgeAnswerKey(key, bridgeName, first), defaultValue)
+	})
+}
+
+// buildUplink completes uplink with bond and VLAN read from answer, then locates the carrier
+func buildUplink(interfaceName string, answer func(key, defaultValue string) string) (uplink Uplink, err error) {
+	uplink.Interface = interfaceName
+	if slaves := answer(AnswerBondSlaves, ""); "" != slaves {
+		for _, slave := range strings.Split(slaves, ",") {
+			if slave = strings.TrimSpace(slave); "" == slave {
+				continue
+			}
+			if _, err = net.InterfaceByName(slave); err != nil {
+				err = fmt.Errorf("invalid bond slave '%s': %s", slave, err.Error())
+				return
+			}
+			uplink.Slaves = append(uplink.Slaves, slave)
+		}
+		if 0 == len(uplink.Slaves) {
+			err = fmt.Errorf("no slave specified by '%s'", AnswerBondSlaves)
+			return
+		}
+		uplink.Interface = answer(AnswerBondName, DefaultBondName)
+		if _, linkError := net.InterfaceByName(uplink.Interface); nil == linkError {
+			err = fmt.Errorf("bond %s already exists, specify it by '%s' instead", uplink.Interface, AnswerBridgeInterface)
+			return
+		}
+		uplink.BondMode = answer(AnswerBondMode, DefaultBondMode)
+		var validMode = false
+		for _, mode := range bondModes {
+			if mode == uplink.BondMode {
+				validMode = true
+				break
+			}
+		}
+		if !validMode {
+			err = fmt.Errorf("invalid bond mode '%s', must be %s", uplink.BondMode, strings.Join(bondModes, "/"))
+			return
+		}
+	} else if "" == uplink.Interface {
+		err = errors.New("no interface to bridge")
+		return
+	}
+	if value := answer(AnswerBridgeVLAN, ""); "" != value {
+		if uplink.VLAN, err = strconv.Atoi(value); err != nil || uplink.VLAN <= 0 || uplink.VLAN > MaxVLANID {
+			err = fmt.Errorf("invalid VLAN '%s', must be 1~%d", value, MaxVLANID)
+			return
+		}
+		if _, linkError := net.InterfaceByName(uplink.Port()); nil == linkError {
+			err = fmt.Errorf("%s already exists, specify it by '%s' instead", uplink.Port(), AnswerBridgeInterface)
+			return
+		}
+	}
+	uplink.Carrier = addressCarrier(uplink.Devices())
+	return uplink, nil
+}
+
+// bridgeAnswerKey returns key specified for bridge like "bridge_interface.br1", the plain key also accepted by the first bridge
+func bridgeAnswerKey(key, bridgeName string, first bool) string {
+	var specified = fmt.Sprintf("%s.%s", key, bridgeName)
+	if answers, ok := prompter.(*AnswerPrompter); (ok && answers.Has(specified)) || !first {
+		return specified
+	}
+	return key
+}
+
+// CellBridge is a bridge for instances of cell, the first one carries management network
+type CellBridge struct {
+	Name string `json:"name"`
+	// Uplink is nil when bridge existed before installation
+	Uplink *Uplink `json:"uplink,omitempty"`
+}
+
+// cellBridgeNames returns bridges listed by answer, or the single bridge named by answer
+func cellBridgeNames() (names []string, err error) {
+	const (
+		MaxNameLength = 15
+	)
+	var value = optionalAnswer(AnswerBridges, "")
+	if "" == value {
+		value = optionalAnswer(AnswerBridgeName, DefaultBridgeName)
+	}
+	var exists = map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); "" == name {
+			continue
+		}
+		if len(name) > MaxNameLength || strings.ContainsAny(name, "/ :.") {
+			err = fmt.Errorf("invalid bridge name '%s'", name)
+			return
+		}
+		if exists[name] {
+			err = fmt.Errorf("duplicate bridge '%s'", name)
+			return
+		}
+		exists[name] = true
+		names = append(names, name)
+	}
+	if 0 == len(names) {
+		err = errors.New("no bridge specified")
+		return
+	}
+	return names, nil
+}
+
+// installedBridges returns bridges recorded in session, the single bridge for installation before multiple bridges supported
+func installedBridges(session *SessionInfo) []CellBridge {
+	if 0 != len(session.Bridges) || "" == session.BridgeName {
+		return session.Bridges
+	}
+	var bridge = CellBridge{Name: session.BridgeName}
+	if "" != session.BridgeInterface {
+		bridge.Uplink = &Uplink{Interface: session.BridgeInterface, Carrier: session.BridgeInterface}
+	}
+	return []CellBridge{bridge}
+}
+
+// validateBridge checks that existing link is a bridge with expected port attached, and management address on the first bridge.
+// expected port is empty when not specified
+func validateBridge(bridgeName, expectedPort string, first bool) (err error) {
+	var link netlink.Link
+	if link, err = netlink.LinkByName(bridgeName); err != nil {
+		return
+	}
+	if "bridge" != link.Type() {
+		return fmt.Errorf("%s is a %s link, not a bridge", bridgeName, link.Type())
+	}
+	var links []netlink.Link
+	if links, err = netlink.LinkList(); err != nil {
+		return
+	}
+	var members []string
+	for _, current := range links {
+		var attrs = current.Attrs()
+		if attrs.MasterIndex != link.Attrs().Index {
+			continue
+		}
+		members = append(members, attrs.Name)
+		var addresses []netlink.Addr
+		if addresses, err = netlink.AddrList(current, netlink.FAMILY_ALL); err != nil {
+			return
+		}
+		for _, address := range addresses {
+			if address.IP.IsGlobalUnicast() {
+				return fmt.Errorf("member %s of %s carries address %s", attrs.Name, bridgeName, address.IPNet)
+			}
+		}
+	}
+	if 0 == len(members) {
+		return fmt.Errorf("no uplink attached to %s", bridgeName)
+	}
+	if "" != expectedPort {
+		var attached = false
+		for _, member := range members {
+			if expectedPort == member {
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			return fmt.Errorf("%s not attached to %s, members: %s", expectedPort, bridgeName, strings.Join(members, ","))
+		}
+	}
+	if first {
+		var addresses []netlink.Addr
+		if addresses, err = netlink.AddrList(link, netlink.FAMILY_V4); err != nil {
+			return
+		}
+		var hasAddress = false
+		for _, address := range addresses {
+			if address.IP.IsGlobalUnicast() {
+				hasAddress = true
+				break
+			}
+		}
+		if !hasAddress {
+			return fmt.Errorf("no IPv4 address on %s for management", bridgeName)
+		}
+	}
+	return nil
+}
+
+// expectedBridgePort returns port of uplink preset for bridge without prompting, empty when not specified
+func expectedBridgePort(bridgeName string, first bool) string {
+	if "" != optionalAnswer(bridgeAnswerKey(AnswerBondSlaves, bridgeName, first), "") {
+		//bond not created yet
+		return ""
+	}
+	var interfaceName = optionalAnswer(bridgeAnswerKey(AnswerBridgeInterface, bridgeName, first), "")
+	if "" == interfaceName {
+		return ""
+	}
+	if vlan := optionalAnswer(bridgeAnswerKey(AnswerBridgeVLAN, bridgeName, first), ""); "" != vlan {
+		return fmt.Sprintf("%s.%s", interfaceName, vlan)
+	}
+	return interfaceName
+}
+
+// addressCarrier returns device with default route, or the first device with global address
+func addressCarrier(devices []string) string {
+	var routeInterface, _ = defaultRouteInterface()
+	for _, device := range devices {
+		if routeInterface == device {
+			return device
+		}
+	}
+	for _, device := range devices {
+		link, err := net.InterfaceByName(device)
+		if err != nil {
+			continue
+		}
+		addresses, err := link.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if ip, ok := address.(*net.IPNet); ok && ip.IP.IsGlobalUnicast() {
+				return device
+			}
+		}
+	}
+	return devices[0]
+}
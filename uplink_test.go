@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChooseUplink(t *testing.T) {
+	const (
+		BondName = "nanotest0"
+	)
+	var cases = []struct {
+		name     string
+		preset   map[string]string
+		input    map[string]string
+		expected *Uplink
+	}{
+		{"preset interface", map[string]string{AnswerBridgeInterface: "lo"}, nil,
+			&Uplink{Interface: "lo", Carrier: "lo"}},
+		{"preset slaves", map[string]string{AnswerBondSlaves: "lo", AnswerBondName: BondName}, nil,
+			&Uplink{Interface: BondName, Slaves: []string{"lo"}, BondMode: DefaultBondMode, Carrier: "lo"}},
+		{"input ethernet", nil, map[string]string{AnswerUplinkType: UplinkEthernet, AnswerBridgeInterface: "lo"},
+			&Uplink{Interface: "lo", Carrier: "lo"}},
+		{"input new bond", nil, map[string]string{AnswerUplinkType: UplinkNewBond, AnswerBondSlaves: "lo",
+			AnswerBondName: BondName, AnswerBondMode: "active-backup"},
+			&Uplink{Interface: BondName, Slaves: []string{"lo"}, BondMode: "active-backup", Carrier: "lo"}},
+		{"input new bond with preset VLAN", map[string]string{AnswerBridgeVLAN: "100"}, map[string]string{
+			AnswerUplinkType: UplinkNewBond, AnswerBondSlaves: "lo", AnswerBondName: BondName, AnswerBondMode: DefaultBondMode},
+			&Uplink{Interface: BondName, Slaves: []string{"lo"}, BondMode: DefaultBondMode, VLAN: 100, Carrier: "lo"}},
+		{"invalid type", nil, map[string]string{AnswerUplinkType: "wifi"}, nil},
+		{"invalid bond mode", nil, map[string]string{AnswerUplinkType: UplinkNewBond, AnswerBondSlaves: "lo",
+			AnswerBondName: BondName, AnswerBondMode: "lacp"}, nil},
+		{"invalid slave", nil, map[string]string{AnswerUplinkType: UplinkNewBond, AnswerBondSlaves: "nanotest-missing",
+			AnswerBondName: BondName, AnswerBondMode: DefaultBondMode}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			//values not preset answered by fallback, like input on console
+			var input = NewAnswerPrompter("test input", nil)
+			for key, value := range c.input {
+				input.Set(key, value)
+			}
+			var answers = NewAnswerPrompter("test answers", input)
+			for key, value := range c.preset {
+				answers.Set(key, value)
+			}
+			var origin = prompter
+			prompter = answers
+			defer func() { prompter = origin }()
+			uplink, err := chooseUplink(DefaultBridgeName, true)
+			if nil == c.expected {
+				if nil == err {
+					t.Fatalf("expect error, but got %s", uplink.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(*c.expected, uplink) {
+				t.Fatalf("expect %+v, but got %+v", *c.expected, uplink)
+			}
+		})
+	}
+}